@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/middleware"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+// Recorder appends events to audit log
+type Recorder interface {
+	Record(ctx context.Context, event *models.AuditEvent)
+}
+type recorder struct {
+	events pgadapter.AuditAdapter
+}
+
+func NewRecorder(events pgadapter.AuditAdapter) Recorder {
+	return &recorder{events: events}
+}
+
+// Record stores event stamped with time and request id of ctx.
+// Failure to audit is logged and never undoes the audited action
+func (a *recorder) Record(ctx context.Context, event *models.AuditEvent) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if event.RequestID == "" {
+		event.RequestID = middleware.GetReqID(ctx)
+	}
+	if err := a.events.CreateEvent(ctx, event); err != nil {
+		log.Error().Err(err).Msgf("Failed to audit %s of %s", event.Action, event.Subject)
+	}
+}
+
+// Event makes event of action done by actor to subject, payload is marshaled to JSON
+func Event(actor, action, subject string, payload any) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Actor:   actor,
+		Action:  action,
+		Subject: subject,
+	}
+	if payload != nil {
+		event.Payload, _ = json.Marshal(payload)
+	}
+	return event
+}
+
+// FromRequest makes event of action done in request by authorized user, anonymous requests have empty actor
+func FromRequest(r *http.Request, action, subject string, payload any) *models.AuditEvent {
+	actor, _ := r.Context().Value(models.UserID).(string)
+	event := Event(actor, action, subject, payload)
+	event.RequestID = middleware.GetReqID(r.Context())
+	event.IP = helpers.ClientIP(r)
+	return event
+}
@@ -4,34 +4,74 @@ import (
 	"context"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
 	"github.com/gynshu-one/gophermart-loyalty-system/config"
 	"github.com/gynshu-one/gophermart-loyalty-system/external"
+	"github.com/gynshu-one/gophermart-loyalty-system/guard"
 	"github.com/gynshu-one/gophermart-loyalty-system/handlers"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/jobs"
+	"github.com/gynshu-one/gophermart-loyalty-system/loyalty"
 	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/notify"
+	"github.com/gynshu-one/gophermart-loyalty-system/oidc"
 	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 var (
 	sessionManager *scs.SessionManager
 	handler        handlers.Handler
+	profile        handlers.ProfileHandler
+	referrals      handlers.ReferralHandler
+	campaigns      handlers.CampaignHandler
+	vouchers       handlers.VoucherHandler
+	transfers      handlers.TransferHandler
+	households     handlers.HouseholdHandler
+	statements     handlers.StatementHandler
+	admin          handlers.AdminHandler
+	tokens         handlers.TokenHandler
+	sessions       handlers.SessionHandler
+	mfas           handlers.MFAHandler
+	merchants      handlers.MerchantHandler
+	passwords      handlers.PasswordHandler
+	oidcLogins     handlers.OIDCHandler
+	jwks           handlers.JWKSHandler
 	balance        pgadapter.BalanceAdapter
 	order          pgadapter.OrderAdapter
 	user           pgadapter.UserAdapter
 	withdrawal     pgadapter.WithdrawalAdapter
+	tier           pgadapter.TierAdapter
+	referral       pgadapter.ReferralAdapter
+	campaign       pgadapter.CampaignAdapter
+	voucher        pgadapter.VoucherAdapter
+	transfer       pgadapter.TransferAdapter
+	household      pgadapter.HouseholdAdapter
+	statement      pgadapter.StatementAdapter
+	adjustment     pgadapter.AdjustmentAdapter
+	auditEvents    pgadapter.AuditAdapter
+	token          pgadapter.TokenAdapter
+	session        pgadapter.SessionAdapter
+	merchantKey    pgadapter.MerchantKeyAdapter
+	loginAttempt   pgadapter.LoginAttemptAdapter
+	mfa            pgadapter.MFAAdapter
+	passwordReset  pgadapter.PasswordResetAdapter
+	identity       pgadapter.IdentityAdapter
+	auditor        audit.Recorder
 	db             *sqlx.DB
 )
 
 func init() {
 	sessionManager = scs.New()
 	sessionManager.Lifetime = 24 * 30 * time.Hour
-	sessionManager.Cookie.Persist = true
-	sessionManager.Cookie.SameSite = http.SameSiteLaxMode
-	sessionManager.Cookie.Secure = false // set to true when using HTTPS
-
 }
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -43,28 +83,273 @@ func main() {
 	balance = pgadapter.NewBalanceAdapter(ctx, db)
 	order = pgadapter.NewOrderAdapter(ctx, db)
 	withdrawal = pgadapter.NewWithdrawalAdapter(ctx, db)
-	accrualAdapter := external.Start(config.GetConfig().AccrualSystemAddress, order, balance)
+	tier = pgadapter.NewTierAdapter(ctx, db)
+	referral = pgadapter.NewReferralAdapter(ctx, db)
+	campaign = pgadapter.NewCampaignAdapter(ctx, db)
+	voucher = pgadapter.NewVoucherAdapter(ctx, db)
+	transfer = pgadapter.NewTransferAdapter(ctx, db)
+	household = pgadapter.NewHouseholdAdapter(ctx, db)
+	statement = pgadapter.NewStatementAdapter(ctx, db)
+	adjustment = pgadapter.NewAdjustmentAdapter(ctx, db)
+	auditEvents = pgadapter.NewAuditAdapter(ctx, db)
+	auditor = audit.NewRecorder(auditEvents)
+	token = pgadapter.NewTokenAdapter(ctx, db)
+	session = pgadapter.NewSessionAdapter(ctx, db)
+	merchantKey = pgadapter.NewMerchantKeyAdapter(ctx, db)
+	mfa = pgadapter.NewMFAAdapter(ctx, db)
+	passwordReset = pgadapter.NewPasswordResetAdapter(ctx, db)
+	identity = pgadapter.NewIdentityAdapter(ctx, db)
+	if config.GetConfig().Command == "reconcile" {
+		runReconcile(ctx)
+		return
+	}
+	authMode := config.GetConfig().AuthMode
+	if authMode != middlwares.AuthModeJWT && authMode != middlwares.AuthModeSession {
+		log.Fatal().Msgf("unknown auth mode %s", authMode)
+	}
+	if mfaKey := config.GetConfig().MFAKey; mfaKey == "" || mfaKey == config.DefaultKey {
+		log.Fatal().Msg("MFA_KEY must be set to a secret key of its own")
+	}
+	if pepper := config.GetConfig().VoucherPepper; pepper == "" || pepper == config.DefaultKey {
+		log.Fatal().Msg("VOUCHER_PEPPER must be set to a secret of its own")
+	}
+	if err := middlwares.ConfigureSessionCookie(&sessionManager.Cookie); err != nil {
+		log.Fatal().Err(err).Msg("invalid cookie config")
+	}
+	userSessions := middlwares.NewSessions(sessionManager, session)
+	tokenKeys := middlwares.NewSecretKeySet(config.GetConfig().Key)
+	if config.GetConfig().JWTKeysDir != "" {
+		var err error
+		tokenKeys, err = middlwares.LoadKeySet(config.GetConfig().JWTKeysDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load jwt keys")
+		}
+		reloadOnHangup(ctx, tokenKeys)
+	}
+	middlwares.UseKeySet(tokenKeys)
+	switch config.GetConfig().LoginAttemptStore {
+	case guard.StorePostgres:
+		loginAttempt = pgadapter.NewLoginAttemptAdapter(ctx, db)
+	case guard.StoreMemory:
+		loginAttempt = guard.NewMemoryAttempts()
+	default:
+		log.Fatal().Msgf("unknown login attempt store %s", config.GetConfig().LoginAttemptStore)
+	}
+	logins := guard.NewLoginGuard(loginAttempt, guard.Policy{
+		MaxAttempts:   config.GetConfig().LoginMaxAttempts,
+		IPMaxAttempts: config.GetConfig().LoginIPMaxAttempts,
+		Window:        config.GetConfig().LoginAttemptWindow,
+		Lockout:       config.GetConfig().LoginLockout,
+		Delay:         config.GetConfig().LoginDelay,
+	})
+	passwordPolicy, err := guard.NewPasswordPolicy(config.GetConfig().PasswordMinLength,
+		config.GetConfig().BreachedPasswordsFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load breached passwords")
+	}
+	providerConfigs, err := oidc.LoadProviders(config.GetConfig().OIDCProvidersFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load oidc providers")
+	}
+	var providers []*oidc.Provider
+	for _, providerConfig := range providerConfigs {
+		redirectURL := handlers.OIDCRedirectURL(config.GetConfig().OIDCBaseURL, providerConfig.Name)
+		providers = append(providers, oidc.NewProvider(providerConfig, redirectURL))
+	}
+	jobs.StartLoginAttemptCleanup(ctx, loginAttempt, config.GetConfig().LoginAttemptWindow, 5*time.Minute)
+	rateLimits, err := middlwares.ParseRateLimits(config.GetConfig().RateLimits)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse rate limits")
+	}
+	tierRules, err := loyalty.ParseTiers(config.GetConfig().Tiers)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse loyalty tiers")
+	}
+	tiers := loyalty.NewTierService(tierRules, config.GetConfig().TierWindow, order, tier)
+	if config.GetConfig().PointsLifetime > 0 {
+		jobs.StartExpiration(ctx, balance, config.GetConfig().ExpirationInterval)
+	}
+	jobs.StartMonthlyStatements(ctx, statement, config.GetConfig().StatementInterval)
+	if config.GetConfig().ReconcileInterval > 0 {
+		jobs.StartReconciliation(ctx, adjustment, auditor, config.GetConfig().ReconcileInterval, config.GetConfig().ReconcileFix)
+	}
+	accrualAdapter := external.Start(config.GetConfig().AccrualSystemAddress,
+		order,
+		balance,
+		tiers,
+		loyalty.NewReferralService(config.GetConfig().ReferralBonus,
+			config.GetConfig().ReferralLimit,
+			config.GetConfig().ReferralMinAccrual,
+			referral),
+		loyalty.NewCampaignService(campaign),
+		household,
+		auditor)
 	handler = handlers.NewHandler(balance,
 		order,
 		user,
 		withdrawal,
-		accrualAdapter)
+		accrualAdapter,
+		referral,
+		household,
+		token,
+		userSessions,
+		logins,
+		passwordPolicy,
+		mfa,
+		auditor)
+	profile = handlers.NewProfileHandler(user, tiers)
+	referrals = handlers.NewReferralHandler(user, referral)
+	campaigns = handlers.NewCampaignHandler(campaign, auditor)
+	vouchers = handlers.NewVoucherHandler(voucher,
+		helpers.NewLimiter(config.GetConfig().VoucherAttempts, config.GetConfig().VoucherWindow), auditor)
+	transfers = handlers.NewTransferHandler(user, transfer, auditor, config.GetConfig().TransferDailyLimit)
+	households = handlers.NewHouseholdHandler(user, household, order, withdrawal, auditor, accrualAdapter)
+	statements = handlers.NewStatementHandler(statement, order, withdrawal)
+	admin = handlers.NewAdminHandler(user, balance, order, withdrawal, adjustment, auditEvents, auditor,
+		token, userSessions, accrualAdapter)
+	tokens = handlers.NewTokenHandler(user, token, userSessions, mfa, auditor)
+	sessions = handlers.NewSessionHandler(session, auditor)
+	mfas = handlers.NewMFAHandler(user, mfa, token, userSessions, logins, auditor)
+	merchants = handlers.NewMerchantHandler(merchantKey, user, order, accrualAdapter, auditor)
+	passwords = handlers.NewPasswordHandler(user, passwordReset, token, userSessions, passwordPolicy, logins,
+		notify.NewNotifier(config.GetConfig().NotifyWebhook, config.GetConfig().NotifyLog), auditor)
+	jwks = handlers.NewJWKSHandler(tokenKeys)
+	oidcLogins = handlers.NewOIDCHandler(providers, identity, user, mfa, token, userSessions, auditor)
+
+	// Locked users are rejected even with valid token, orders are limited apart as each one loads accrual system.
+	// Requests authorized by cookie must carry CSRF token
+	authenticate := middlwares.AuthMiddleware(token, userSessions)
+	userLimit := middlwares.RateLimitMiddleware(rateLimits[middlwares.RateLimitUser])
+	auth := chi.Chain(authenticate, middlwares.CSRFMiddleware, middlwares.LockMiddleware(user), userLimit)
+	// Operators without second factor enroll in the middle of login
+	enrollMFA := chi.Chain(middlwares.MFAEnrollMiddleware(token, userSessions), middlwares.CSRFMiddleware,
+		middlwares.LockMiddleware(user), userLimit)
+	submitOrders := chi.Chain(authenticate, middlwares.CSRFMiddleware, middlwares.LockMiddleware(user),
+		middlwares.RateLimitMiddleware(rateLimits[middlwares.RateLimitOrders]))
+	authLimit := middlwares.RateLimitMiddleware(rateLimits[middlwares.RateLimitAuth])
 
 	r := chi.NewRouter()
+	// Request id ties audit events to requests
+	r.Use(middleware.RequestID)
+	if authMode == middlwares.AuthModeSession {
+		r.Use(sessionManager.LoadAndSave)
+		jobs.StartSessionCleanup(ctx, session, 5*time.Minute)
+	}
+	r.Get("/.well-known/jwks.json", jwks.GetJWKSHandler)
 	r.Route("/api/user", func(r chi.Router) {
 		r.Use(Logger)
-		r.Post("/login", handler.LoginHandler)
-		r.Post("/register", handler.RegisterHandler)
+		r.With(authLimit).Post("/login", handler.LoginHandler)
+		r.With(authLimit).Post("/register", handler.RegisterHandler)
+		r.With(authLimit).Post("/token/refresh", tokens.RefreshTokenHandler)
+		r.With(authLimit).Post("/mfa/verify", mfas.VerifyMFAHandler)
+		r.With(authLimit).Post("/password/reset", passwords.RequestPasswordResetHandler)
+		r.With(authLimit).Post("/password/reset/confirm", passwords.ResetPasswordHandler)
+		r.With(authLimit).Get("/oidc/{provider}/login", oidcLogins.OIDCLoginHandler)
+		r.With(authLimit).Get("/oidc/{provider}/callback", oidcLogins.OIDCCallbackHandler)
+		r.With(authenticate, middlwares.CSRFMiddleware).Post("/logout", tokens.LogoutHandler)
+		r.With(enrollMFA...).Post("/mfa/enroll", mfas.EnrollMFAHandler)
+		r.With(enrollMFA...).Post("/mfa/confirm", mfas.ConfirmMFAHandler)
+
+		r.With(submitOrders...).Post("/orders", handler.AddOrderHandler)
+		r.With(auth...).Get("/orders", handler.GetOrderHandler)
+		r.With(auth...).Get("/balance", handler.GetBalanceHandler)
+		r.With(auth...).Post("/balance/withdraw", handler.WithdrawBalanceHandler)
+		r.With(auth...).Post("/balance/transfer", transfers.TransferBalanceHandler)
+		r.With(auth...).Get("/transfers", transfers.GetTransfersHandler)
+		r.With(auth...).Get("/withdrawals", handler.GetWithdrawalsHandler)
+		r.With(auth...).Get("/profile", profile.GetProfileHandler)
+		r.With(auth...).Post("/password", passwords.ChangePasswordHandler)
+		r.With(auth...).Get("/referrals", referrals.GetReferralsHandler)
+		r.With(auth...).Post("/vouchers/redeem", vouchers.RedeemVoucherHandler)
+		r.With(auth...).Post("/household", households.CreateHouseholdHandler)
+		r.With(auth...).Get("/household", households.GetHouseholdHandler)
+		r.With(auth...).Post("/household/invitations", households.InviteHandler)
+		r.With(auth...).Get("/household/invitations", households.GetInvitationsHandler)
+		r.With(auth...).Post("/household/invitations/{id}/accept", households.AcceptInvitationHandler)
+		r.With(auth...).Post("/household/invitations/{id}/decline", households.DeclineInvitationHandler)
+		r.With(auth...).Delete("/household/invitations/{id}", households.CancelInvitationHandler)
+		r.With(auth...).Delete("/household/members/{login}", households.RemoveMemberHandler)
+		r.With(auth...).Put("/household/owner", households.TransferOwnershipHandler)
+		r.With(auth...).Put("/household/pooling", households.SetPoolingHandler)
+		r.With(auth...).Post("/household/withdraw", households.WithdrawHouseholdHandler)
+		r.With(auth...).Get("/statement", statements.GetStatementHandler)
+		r.With(auth...).Get("/statement/export", statements.ExportStatementHandler)
+		r.With(auth...).Get("/statements/{month}", statements.GetMonthlyStatementHandler)
+		if authMode == middlwares.AuthModeSession {
+			r.With(auth...).Get("/sessions", sessions.GetSessionsHandler)
+			r.With(auth...).Delete("/sessions/{id}", sessions.RevokeSessionHandler)
+		}
 
-		r.With(middlwares.AuthMiddleware).Post("/orders", handler.AddOrderHandler)
-		r.With(middlwares.AuthMiddleware).Get("/orders", handler.GetOrderHandler)
-		r.With(middlwares.AuthMiddleware).Get("/balance", handler.GetBalanceHandler)
-		r.With(middlwares.AuthMiddleware).Post("/balance/withdraw", handler.WithdrawBalanceHandler)
-		r.With(middlwares.AuthMiddleware).Get("/withdrawals", handler.GetWithdrawalsHandler)
+	})
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(Logger)
+		r.Use(middlwares.AdminMiddleware(token, userSessions))
+		r.Use(middlwares.LockMiddleware(user))
+		r.Use(middlwares.CSRFMiddleware)
+		r.Use(middlwares.RateLimitMiddleware(rateLimits[middlwares.RateLimitAdmin]))
 
+		// Support staff can look, only admins can change anything
+		r.Group(func(r chi.Router) {
+			r.Use(middlwares.RequireRole(models.RoleAdmin, models.RoleSupport))
+			r.Get("/users", admin.SearchUsersHandler)
+			r.Get("/users/{id}", admin.GetUserHandler)
+			r.Get("/users/{id}/orders", admin.GetUserOrdersHandler)
+			r.Get("/users/{id}/withdrawals", admin.GetUserWithdrawalsHandler)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middlwares.RequireRole(models.RoleAdmin))
+			r.Get("/campaigns", campaigns.GetCampaignsHandler)
+			r.Post("/campaigns", campaigns.CreateCampaignHandler)
+			r.Get("/campaigns/{id}", campaigns.GetCampaignHandler)
+			r.Put("/campaigns/{id}", campaigns.UpdateCampaignHandler)
+			r.Delete("/campaigns/{id}", campaigns.DeleteCampaignHandler)
+			r.Get("/vouchers", vouchers.GetVouchersHandler)
+			r.Post("/vouchers", vouchers.MintVouchersHandler)
+			r.Post("/users/{id}/adjustments", admin.AdjustBalanceHandler)
+			r.Post("/users/{id}/lock", admin.LockUserHandler)
+			r.Delete("/users/{id}/lock", admin.UnlockUserHandler)
+			r.Put("/users/{id}/role", admin.SetRoleHandler)
+			r.Get("/audit", admin.GetAuditEventsHandler)
+			r.Get("/audit/export", admin.ExportAuditEventsHandler)
+			r.Get("/merchant-keys", merchants.GetMerchantKeysHandler)
+			r.Post("/merchant-keys", merchants.CreateMerchantKeyHandler)
+			r.Delete("/merchant-keys/{id}", merchants.RevokeMerchantKeyHandler)
+		})
+		// Internal services may ask to re-poll orders too
+		r.With(middlwares.RequireRole(models.RoleAdmin, models.RoleService)).
+			Post("/orders/{number}/repoll", admin.RepollOrderHandler)
+	})
+	// Merchant backends submit orders of their customers by API key
+	r.Route("/api/merchant", func(r chi.Router) {
+		r.Use(Logger)
+		r.With(middlwares.MerchantKeyMiddleware(merchantKey, models.ScopeOrdersWrite),
+			middlwares.RateLimitMiddleware(rateLimits[middlwares.RateLimitMerchant])).
+			Post("/orders", merchants.SubmitOrderHandler)
 	})
 	http.ListenAndServe(config.GetConfig().RunAddress, r)
 }
+
+// reloadOnHangup rereads jwt keys on SIGHUP, so they are rotated without restart
+func reloadOnHangup(ctx context.Context, keys *middlwares.KeySet) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				if err := keys.Reload(); err != nil {
+					log.Error().Err(err).Msg("Failed to reload jwt keys, keeping the old ones")
+					continue
+				}
+				log.Info().Msg("Reloaded jwt keys")
+			}
+		}
+	}()
+}
+
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/jobs"
+	"github.com/rs/zerolog/log"
+	"os"
+)
+
+// runReconcile checks balances once and exits with 1 if drifts are left unresolved, so it can be run by cron
+func runReconcile(ctx context.Context) {
+	fix := config.GetConfig().ReconcileFix
+	report, err := jobs.Reconcile(ctx, adjustment, auditor, fix)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to reconcile balances")
+	}
+	log.Info().Msgf("Found %d drifted balances, corrected %d", len(report.Drifts), len(report.Corrected))
+	if report.Unresolved(fix) {
+		os.Exit(1)
+	}
+}
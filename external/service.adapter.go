@@ -4,34 +4,57 @@ import (
 	"context"
 	"github.com/gammazero/workerpool"
 	resty "github.com/go-resty/resty/v2"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/loyalty"
 	"github.com/gynshu-one/gophermart-loyalty-system/models"
 	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
 	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter/composer"
 	"github.com/rs/zerolog/log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type AccrualAdapter interface {
 	FallowOrder(order *models.Order) error
+	Repoll(order *models.Order) error
 }
 
 // orderService is an independent service that continuously updates state of orders in db
 type orderService struct {
-	addr     string
-	orders   pgadapter.OrderAdapter
-	balances pgadapter.BalanceAdapter
-	client   *resty.Client
+	addr       string
+	orders     pgadapter.OrderAdapter
+	balances   pgadapter.BalanceAdapter
+	tiers      loyalty.Tiers
+	referrals  loyalty.Referrals
+	campaigns  loyalty.Campaigns
+	households pgadapter.HouseholdAdapter
+	audit      audit.Recorder
+	client     *resty.Client
+	// polling holds ids of orders being followed, so one order is never followed twice
+	polling sync.Map
 }
 
-func Start(addr string, orders pgadapter.OrderAdapter, balances pgadapter.BalanceAdapter) *orderService {
+func Start(addr string,
+	orders pgadapter.OrderAdapter,
+	balances pgadapter.BalanceAdapter,
+	tiers loyalty.Tiers,
+	referrals loyalty.Referrals,
+	campaigns loyalty.Campaigns,
+	households pgadapter.HouseholdAdapter,
+	auditor audit.Recorder) *orderService {
 	client := resty.New()
 	e := &orderService{
-		addr:     addr,
-		orders:   orders,
-		balances: balances,
-		client:   client,
+		addr:       addr,
+		orders:     orders,
+		balances:   balances,
+		tiers:      tiers,
+		referrals:  referrals,
+		campaigns:  campaigns,
+		households: households,
+		audit:      auditor,
+		client:     client,
 	}
 
 	// Read all orders that are not processed yet from DB
@@ -54,6 +77,9 @@ func Start(addr string, orders pgadapter.OrderAdapter, balances pgadapter.Balanc
 	return e
 }
 
+// AuditActor is recorded as author of order status changes
+const AuditActor = "accrual"
+
 type responseStruct struct {
 	OrderID    string  `json:"order"`
 	Status     string  `json:"status"`
@@ -80,6 +106,7 @@ func (e *orderService) FallowOrder(order *models.Order) error {
 	}
 
 	// Pass order to worker pool
+	e.polling.Store(order.ID, struct{}{})
 	workerPool.Submit(func() {
 		e.worker(ctx, order)
 	})
@@ -88,6 +115,18 @@ func (e *orderService) FallowOrder(order *models.Order) error {
 
 var workerPool = workerpool.New(50)
 
+// Repoll follows existing order again, e.g. when it got stuck or accrual system changed its mind about invalid order.
+// Processed orders are never polled again, so accrual can't be credited twice
+func (e *orderService) Repoll(order *models.Order) error {
+	if order.Status == models.OrderStatusProcessed {
+		return models.ErrorOrderProcessed
+	}
+	if _, polling := e.polling.LoadOrStore(order.ID, struct{}{}); polling {
+		return nil
+	}
+	return e.FallowOrder(order)
+}
+
 func (e *orderService) worker(ctx context.Context, order *models.Order) {
 	response := e.check(*order)
 
@@ -109,30 +148,70 @@ func (e *orderService) worker(ctx context.Context, order *models.Order) {
 
 	// No changes
 	if response.Status == order.Status {
+		if order.Status == models.OrderStatusInvalid {
+			e.polling.Delete(order.ID)
+			return
+		}
 		time.Sleep(300 * time.Millisecond)
 		e.FallowOrder(order)
 		return
 	}
 
 	// Update order status and increment balance
+	previous := order.Status
+	switch response.Status {
+	case models.OrderStatusProcessing, models.OrderStatusInvalid:
+		order.Status = response.Status
+	}
 	if response.Status == models.OrderStatusProcessed {
-		ctx_, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
-		if response.Accrual > 0 {
-			e.updateBalance(ctx_, order.UserID, response.Accrual)
-		}
-		cancel()
+		// Order keeps accrual of accrual system, tier and campaigns add bonus on top of it
 		order.Accrual = response.Accrual
+		if order.Accrual > 0 {
+			bonus := order.Accrual * (e.multiplier(ctx, order.UserID) - 1)
+			// Order is checked again if accrual can't be credited, crediting skips orders credited before
+			householdID, err := e.pool(ctx, order.UserID)
+			if err == nil {
+				ctx_, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+				err = e.updateBalance(ctx_, order, bonus, householdID)
+				cancel()
+			}
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to credit order %s, will try again", order.ID)
+				time.Sleep(300 * time.Millisecond)
+				e.FallowOrder(order)
+				return
+			}
+			ctx_, cancel := context.WithTimeout(ctx, time.Second)
+			e.campaigns.Apply(ctx_, order, order.Accrual+bonus, householdID)
+			cancel()
+		}
 		order.Status = models.OrderStatusProcessed
 	}
 	order.UpdatedAt = time.Now()
 	err := e.orders.UpdateOrders(context.Background(), order)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to update order")
+		e.polling.Delete(order.ID)
 		return
 	}
+	if order.Status != previous {
+		e.audit.Record(ctx, audit.Event(AuditActor, models.AuditOrderStatus, order.UserID, map[string]any{
+			"order":   order.ID,
+			"from":    previous,
+			"to":      order.Status,
+			"accrual": order.Accrual,
+		}))
+	}
+
+	// Accrual may move user to the next tier and reward referral
+	if order.Status == models.OrderStatusProcessed {
+		e.evaluateTier(ctx, order.UserID)
+		e.referrals.OrderProcessed(ctx, order)
+	}
 
 	// If order is Invalid or Processed - we will not check it again
 	if response.Status == models.OrderStatusInvalid || response.Status == models.OrderStatusProcessed {
+		e.polling.Delete(order.ID)
 		return
 	}
 	time.Sleep(300 * time.Millisecond)
@@ -171,10 +250,54 @@ func (e *orderService) check(order models.Order) *responseStruct {
 	return response
 }
 
-func (e *orderService) updateBalance(ctx context.Context, userID string, addAmount float64) {
-	err := e.balances.IncrementBalance(ctx, userID, addAmount)
+// updateBalance credits accrual of order and bonus on top of it to household pool, if householdID isn't empty,
+// or to personal balance
+func (e *orderService) updateBalance(ctx context.Context, order *models.Order, bonus float64, householdID string) error {
+	if householdID != "" {
+		return e.households.CreditPool(ctx, &models.HouseholdEntry{
+			HouseholdID: householdID,
+			UserID:      order.UserID,
+			OrderID:     order.ID,
+			Amount:      order.Accrual + bonus,
+			CreatedAt:   time.Now(),
+		})
+	}
+	return e.balances.CreditOrder(ctx, order.UserID, order.ID, order.Accrual, bonus)
+}
+
+// pool returns household user pools accruals into, empty if user keeps them.
+// Membership that can't be read is an error, crediting personal balance instead would bypass the pool
+func (e *orderService) pool(ctx context.Context, userID string) (string, error) {
+	ctx_, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	member, err := e.households.ReadMembership(ctx_, userID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to update balance")
-		return
+		return "", err
+	}
+	if member != nil && member.Pooling {
+		return member.HouseholdID, nil
+	}
+	return "", nil
+}
+
+// multiplier evaluates user's tier and returns its accrual multiplier
+// Falls back to 1 if tier can't be evaluated, so accrual is never lost
+func (e *orderService) multiplier(ctx context.Context, userID string) float64 {
+	ctx_, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	tier, err := e.tiers.Evaluate(ctx_, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to evaluate tier")
+		return 1
+	}
+	return tier.Tier.Multiplier
+}
+
+// evaluateTier records tier change accrual may have caused, so it dates from the order rather than next profile view
+func (e *orderService) evaluateTier(ctx context.Context, userID string) {
+	ctx_, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := e.tiers.Evaluate(ctx_, userID); err != nil {
+		log.Error().Err(err).Msg("Failed to evaluate tier")
 	}
 }
@@ -0,0 +1,156 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"time"
+)
+
+// Stores of failed logins
+const (
+	StorePostgres = "postgres"
+	StoreMemory   = "memory"
+)
+
+// maxDelayShift keeps doubled delay from overflowing, it is capped by lockout long before
+const maxDelayShift = 30
+
+// Policy tells how failed logins are throttled, zero limits and delay disable corresponding protection
+type Policy struct {
+	// MaxAttempts failed logins to an account within Window lock it out for Lockout
+	MaxAttempts int
+	// IPMaxAttempts failed logins from an IP within Window lock it out for Lockout
+	IPMaxAttempts int
+	Window        time.Duration
+	Lockout       time.Duration
+	// Delay is wait after the first failed login to an account, it doubles with each next failure up to Lockout
+	Delay time.Duration
+}
+
+// Lockout is temporary block of logins caused by failed attempt, Login is empty if IP was blocked
+type Lockout struct {
+	Login string
+	IP    string
+	Until time.Time
+}
+
+// LoginGuard throttles password guessing, failures are counted per account and per IP
+type LoginGuard struct {
+	attempts pgadapter.LoginAttemptAdapter
+	policy   Policy
+}
+
+func NewLoginGuard(attempts pgadapter.LoginAttemptAdapter, policy Policy) *LoginGuard {
+	return &LoginGuard{
+		attempts: attempts,
+		policy:   policy,
+	}
+}
+
+// Check tells whether login to account from ip may be tried now. Locked out account is models.ErrorLoginLocked,
+// locked out IP or account tried again before its delay passed is models.ErrorRequestLimitExceeded,
+// both come with time to retry after. Accounts are counted by login, so unknown ones are throttled alike
+func (g *LoginGuard) Check(ctx context.Context, login, ip string) (time.Duration, error) {
+	now := time.Now()
+	account, err := g.read(ctx, loginKey(login))
+	if err != nil {
+		return 0, err
+	}
+	if account != nil {
+		if account.LockedUntil != nil && now.Before(*account.LockedUntil) {
+			return account.LockedUntil.Sub(now), models.ErrorLoginLocked
+		}
+		if next := account.LastFailureAt.Add(g.delay(account.Failures)); now.Before(next) {
+			return next.Sub(now), models.ErrorRequestLimitExceeded
+		}
+	}
+
+	address, err := g.read(ctx, ipKey(ip))
+	if err != nil {
+		return 0, err
+	}
+	if address != nil && address.LockedUntil != nil && now.Before(*address.LockedUntil) {
+		return address.LockedUntil.Sub(now), models.ErrorRequestLimitExceeded
+	}
+	return 0, nil
+}
+
+// Fail counts failed login to account from ip and returns lockouts it caused
+func (g *LoginGuard) Fail(ctx context.Context, login, ip string) ([]*Lockout, error) {
+	var lockouts []*Lockout
+	limits := []struct {
+		key     string
+		max     int
+		lockout *Lockout
+	}{
+		{key: loginKey(login), max: g.policy.MaxAttempts, lockout: &Lockout{Login: login, IP: ip}},
+		{key: ipKey(ip), max: g.policy.IPMaxAttempts, lockout: &Lockout{IP: ip}},
+	}
+	now := time.Now()
+	for _, limit := range limits {
+		attempts, err := g.attempts.RegisterLoginFailure(ctx, limit.key, now.Add(-g.policy.Window))
+		if err != nil {
+			return lockouts, err
+		}
+		if limit.max <= 0 || attempts.Failures < limit.max {
+			continue
+		}
+		if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+			continue
+		}
+		limit.lockout.Until = now.Add(g.policy.Lockout)
+		if err = g.attempts.LockLogin(ctx, limit.key, limit.lockout.Until); err != nil {
+			return lockouts, err
+		}
+		lockouts = append(lockouts, limit.lockout)
+	}
+	return lockouts, nil
+}
+
+// Succeed forgets failed logins to account, failures from IP are kept,
+// so attacker can't reset them by logging in to own account
+func (g *LoginGuard) Succeed(ctx context.Context, login string) error {
+	return g.attempts.ResetLoginAttempts(ctx, loginKey(login))
+}
+
+// delay is how long to wait after given number of failures
+func (g *LoginGuard) delay(failures int) time.Duration {
+	if g.policy.Delay <= 0 || failures <= 0 {
+		return 0
+	}
+	shift := failures - 1
+	if shift > maxDelayShift {
+		shift = maxDelayShift
+	}
+	delay := g.policy.Delay << shift
+	if g.policy.Lockout > 0 && delay > g.policy.Lockout {
+		delay = g.policy.Lockout
+	}
+	return delay
+}
+
+// read returns failures of key or nil if there are none within window
+func (g *LoginGuard) read(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	attempts, err := g.attempts.ReadLoginAttempts(ctx, key)
+	if errors.Is(err, models.ErrorNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	locked := attempts.LockedUntil != nil && time.Now().Before(*attempts.LockedUntil)
+	if !locked && time.Since(attempts.LastFailureAt) > g.policy.Window {
+		return nil, nil
+	}
+	return attempts, nil
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
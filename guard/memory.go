@@ -0,0 +1,79 @@
+package guard
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"sync"
+	"time"
+)
+
+// memoryAttempts keeps failed logins in memory, so they are counted by each instance on its own
+type memoryAttempts struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempts
+}
+
+func NewMemoryAttempts() *memoryAttempts {
+	return &memoryAttempts{attempts: make(map[string]*models.LoginAttempts)}
+}
+
+func (m *memoryAttempts) ReadLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.attempts[key]
+	if !ok {
+		return nil, models.ErrorNotFound
+	}
+	out := *attempts
+	return &out, nil
+}
+
+func (m *memoryAttempts) RegisterLoginFailure(ctx context.Context, key string, since time.Time) (*models.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.attempts[key]
+	if !ok || attempts.LastFailureAt.Before(since) {
+		attempts = &models.LoginAttempts{Key: key}
+		m.attempts[key] = attempts
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = time.Now()
+	out := *attempts
+	return &out, nil
+}
+
+func (m *memoryAttempts) LockLogin(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempts, ok := m.attempts[key]; ok {
+		attempts.LockedUntil = &until
+	}
+	return nil
+}
+
+func (m *memoryAttempts) ResetLoginAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *memoryAttempts) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	deleted := 0
+	for key, attempts := range m.attempts {
+		locked := attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil)
+		if attempts.LastFailureAt.Before(before) && !locked {
+			delete(m.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,83 @@
+package guard
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"os"
+	"strings"
+)
+
+// maxPasswordLength is where bcrypt stops reading passwords, longer ones would be silently truncated
+const maxPasswordLength = 72
+
+// PasswordPolicy tells which passwords users may choose
+type PasswordPolicy struct {
+	minLength int
+	// breached holds upper case hex SHA-1 of known breached passwords
+	breached map[string]struct{}
+}
+
+// NewPasswordPolicy makes policy of passwords at least minLength long which aren't in breached file.
+// The file has one password per line, either plain or as hex SHA-1 optionally followed by ":count",
+// so downloaded breach corpora can be used as is. Empty path disables the check
+func NewPasswordPolicy(minLength int, breachedFile string) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{minLength: minLength, breached: make(map[string]struct{})}
+	if breachedFile == "" {
+		return policy, nil
+	}
+	file, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1(hash) {
+			policy.breached[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		policy.breached[passwordSHA1(line)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breached passwords: %w", err)
+	}
+	return policy, nil
+}
+
+// Validate tells why password of user with given login can't be used, nil if it can
+func (p *PasswordPolicy) Validate(login, password string) error {
+	if len([]rune(password)) < p.minLength {
+		return fmt.Errorf("%w: at least %d characters", models.ErrorWeakPassword, p.minLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: at most %d bytes", models.ErrorWeakPassword, maxPasswordLength)
+	}
+	if strings.EqualFold(password, login) {
+		return fmt.Errorf("%w: same as login", models.ErrorWeakPassword)
+	}
+	if _, ok := p.breached[passwordSHA1(password)]; ok {
+		return fmt.Errorf("%w: found in known breaches", models.ErrorWeakPassword)
+	}
+	return nil
+}
+
+func passwordSHA1(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
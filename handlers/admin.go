@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/external"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFoundUsers limits the number of users returned by search
+const maxFoundUsers = 50
+
+type AdminHandler interface {
+	SearchUsersHandler(w http.ResponseWriter, r *http.Request)
+	GetUserHandler(w http.ResponseWriter, r *http.Request)
+	GetUserOrdersHandler(w http.ResponseWriter, r *http.Request)
+	GetUserWithdrawalsHandler(w http.ResponseWriter, r *http.Request)
+	AdjustBalanceHandler(w http.ResponseWriter, r *http.Request)
+	LockUserHandler(w http.ResponseWriter, r *http.Request)
+	UnlockUserHandler(w http.ResponseWriter, r *http.Request)
+	RepollOrderHandler(w http.ResponseWriter, r *http.Request)
+	SetRoleHandler(w http.ResponseWriter, r *http.Request)
+	GetAuditEventsHandler(w http.ResponseWriter, r *http.Request)
+	ExportAuditEventsHandler(w http.ResponseWriter, r *http.Request)
+}
+type adminHandler struct {
+	user         pgadapter.UserAdapter
+	balance      pgadapter.BalanceAdapter
+	order        pgadapter.OrderAdapter
+	withdrawal   pgadapter.WithdrawalAdapter
+	adjustment   pgadapter.AdjustmentAdapter
+	events       pgadapter.AuditAdapter
+	audit        audit.Recorder
+	tokens       pgadapter.TokenAdapter
+	sessions     *middlwares.Sessions
+	orderService external.AccrualAdapter
+}
+
+func NewAdminHandler(user pgadapter.UserAdapter,
+	balance pgadapter.BalanceAdapter,
+	order pgadapter.OrderAdapter,
+	withdrawal pgadapter.WithdrawalAdapter,
+	adjustment pgadapter.AdjustmentAdapter,
+	events pgadapter.AuditAdapter,
+	auditor audit.Recorder,
+	tokens pgadapter.TokenAdapter,
+	sessions *middlwares.Sessions,
+	orderService external.AccrualAdapter) AdminHandler {
+	return &adminHandler{
+		user:         user,
+		balance:      balance,
+		order:        order,
+		withdrawal:   withdrawal,
+		adjustment:   adjustment,
+		events:       events,
+		audit:        auditor,
+		tokens:       tokens,
+		sessions:     sessions,
+		orderService: orderService,
+	}
+}
+
+// SearchUsersHandler finds users by part of login given in login query parameter
+func (h *adminHandler) SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	login := strings.TrimSpace(r.URL.Query().Get("login"))
+	if login == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	users, err := h.user.SearchUsers(r.Context(), login, maxFoundUsers)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.record(r, models.AuditSearchUsers, "", map[string]any{"login": login})
+
+	response := make([]*models.ResponseAdminUser, 0, len(users))
+	for _, user := range users {
+		response = append(response, &models.ResponseAdminUser{
+			ID:       user.ID,
+			Login:    user.Login,
+			Role:     user.Role,
+			LockedAt: user.LockedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *adminHandler) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.readUser(w, r)
+	if !ok {
+		return
+	}
+	balance, err := h.balance.ReadBalance(r.Context(), user.ID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	// User without balance row has never been credited
+	if balance == nil {
+		balance = &models.Balance{UserID: user.ID}
+	}
+	h.record(r, models.AuditViewUser, user.ID, nil)
+
+	writeJSON(w, http.StatusOK, &models.ResponseAdminUser{
+		ID:       user.ID,
+		Login:    user.Login,
+		Role:     user.Role,
+		LockedAt: user.LockedAt,
+		Balance:  &models.ResponseBalance{Current: balance.Amount, Withdrawn: balance.Withdrawn},
+	})
+}
+
+func (h *adminHandler) GetUserOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.readUser(w, r)
+	if !ok {
+		return
+	}
+	orders, err := h.order.ReadOrder(r.Context(), models.UserID.EqualTo(user.ID))
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.record(r, models.AuditViewOrders, user.ID, nil)
+
+	if orders == nil {
+		orders = []*models.Order{}
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+func (h *adminHandler) GetUserWithdrawalsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.readUser(w, r)
+	if !ok {
+		return
+	}
+	withdrawals, err := h.withdrawal.ReadWithdrawal(r.Context(), user.ID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.record(r, models.AuditViewWithdrawals, user.ID, nil)
+
+	if withdrawals == nil {
+		withdrawals = []*models.Withdrawal{}
+	}
+	writeJSON(w, http.StatusOK, withdrawals)
+}
+
+// AdjustBalanceHandler credits or debits user's balance, reason is mandatory
+func (h *adminHandler) AdjustBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var bodyJSON struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Amount == 0 || strings.TrimSpace(bodyJSON.Reason) == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Amount and reason are required", http.StatusBadRequest)
+		return
+	}
+	user, ok := h.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	adjustment := &models.Adjustment{
+		ID:        helpers.GenerateUUID(),
+		UserID:    user.ID,
+		Amount:    bodyJSON.Amount,
+		Reason:    strings.TrimSpace(bodyJSON.Reason),
+		Actor:     actor(r),
+		CreatedAt: time.Now(),
+	}
+	if err = h.adjustment.AdjustBalance(r.Context(), adjustment); err != nil {
+		switch {
+		case errors.Is(err, models.ErrorInsufficientFunds):
+			http.Error(w, "Insufficient funds", http.StatusPaymentRequired)
+		case errors.Is(err, models.ErrorNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	h.record(r, models.AuditAdjustBalance, user.ID, map[string]any{
+		"adjustment_id": adjustment.ID,
+		"amount":        adjustment.Amount,
+		"reason":        adjustment.Reason,
+	})
+	writeJSON(w, http.StatusCreated, adjustment)
+}
+
+func (h *adminHandler) LockUserHandler(w http.ResponseWriter, r *http.Request) {
+	h.setLocked(w, r, true)
+}
+
+func (h *adminHandler) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	h.setLocked(w, r, false)
+}
+
+func (h *adminHandler) setLocked(w http.ResponseWriter, r *http.Request, locked bool) {
+	id := chi.URLParam(r, "id")
+	if err := h.user.SetLocked(r.Context(), id, locked); err != nil {
+		if errors.Is(err, models.ErrorNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Locked user is logged out, so refresh tokens and sessions can't outlive the lock
+	if locked {
+		if err := logOutEverywhere(r.Context(), h.tokens, h.sessions, id, ""); err != nil {
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	action := models.AuditUnlockUser
+	if locked {
+		action = models.AuditLockUser
+	}
+	h.record(r, action, id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RepollOrderHandler makes order service check order in accrual system again
+func (h *adminHandler) RepollOrderHandler(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	orders, err := h.order.ReadOrder(r.Context(), models.ID.EqualTo(number))
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(orders) == 0 {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err = h.orderService.Repoll(orders[0]); err != nil {
+		if errors.Is(err, models.ErrorOrderProcessed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.record(r, models.AuditRepollOrder, orders[0].UserID, map[string]any{
+		"order":  number,
+		"status": orders[0].Status,
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// SetRoleHandler changes role of user, new role applies once user refreshes token
+func (h *adminHandler) SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var bodyJSON struct {
+		Role string `json:"role"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || !validRole(bodyJSON.Role) {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err = h.user.SetRole(r.Context(), id, bodyJSON.Role); err != nil {
+		if errors.Is(err, models.ErrorNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Role is copied into sessions and tokens at login, user logs in again to get the new one
+	if err = logOutEverywhere(r.Context(), h.tokens, h.sessions, id, ""); err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.record(r, models.AuditChangeRole, id, map[string]any{"role": bodyJSON.Role})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuditEventsHandler shows audit events filtered by actor, subject, action, from and to, oldest first
+func (h *adminHandler) GetAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.events.ReadEvents(r.Context(), filter)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.record(r, models.AuditViewAudit, filter.Subject, auditFilterPayload(filter))
+	if events == nil {
+		events = []*models.AuditEvent{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// ExportAuditEventsHandler streams all audit events matching the same filters as GetAuditEventsHandler
+// as a downloadable csv, json or ndjson file
+func (h *adminHandler) ExportAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := exportFormatOf(r)
+	if !ok {
+		log.Debug().Msgf("Unknown export format %s", r.URL.Query().Get("format"))
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	// Export is recorded before it starts, so it shows up in itself
+	h.record(r, models.AuditExportAudit, filter.Subject, auditFilterPayload(filter))
+
+	records := startExport(w, format, auditColumns, "audit_"+time.Now().UTC().Format("20060102T150405Z"))
+	err = h.events.StreamEvents(r.Context(), filter, func(event *models.AuditEvent) error {
+		return records.Write(event)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to export audit events")
+		return
+	}
+	if err = records.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to finish export of audit events")
+	}
+}
+
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Actor:   query.Get("actor"),
+		Subject: query.Get("subject"),
+		Action:  query.Get("action"),
+		Limit:   defaultStatementLimit,
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = parseStatementTime(from, false); err != nil {
+			return filter, err
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = parseStatementTime(to, true); err != nil {
+			return filter, err
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxStatementLimit {
+			return filter, errors.New("invalid limit")
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		filter.Offset, err = strconv.Atoi(offset)
+		if err != nil || filter.Offset < 0 {
+			return filter, errors.New("invalid offset")
+		}
+	}
+	return filter, nil
+}
+
+func auditFilterPayload(filter models.AuditFilter) map[string]any {
+	return map[string]any{
+		"actor":   filter.Actor,
+		"subject": filter.Subject,
+		"action":  filter.Action,
+		"from":    filter.From,
+		"to":      filter.To,
+	}
+}
+
+func validRole(role string) bool {
+	switch role {
+	case models.RoleCustomer, models.RoleSupport, models.RoleAdmin, models.RoleService:
+		return true
+	}
+	return false
+}
+
+// actor is id of authorized operator
+func actor(r *http.Request) string {
+	userID, _ := r.Context().Value(models.UserID).(string)
+	return userID
+}
+
+// readUser reads user given by id URL parameter and writes 404 if there is none
+func (h *adminHandler) readUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := h.user.ReadUserByID(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// record writes admin action to audit log
+func (h *adminHandler) record(r *http.Request, action, subject string, payload any) {
+	h.audit.Record(r.Context(), audit.FromRequest(r, action, subject, payload))
+}
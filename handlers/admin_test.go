@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_adminHandler_SearchUsersHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		user           pgadapter.UserAdapter
+		wantStatusCode int
+	}{
+		{
+			name:           "Found",
+			query:          "?login=ann",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Login: "anna", Password: "hash"}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Empty login",
+			query:          "",
+			user:           mockUserAdapter{},
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &adminHandler{user: tt.user, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/users"+tt.query, nil)
+			h.SearchUsersHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.SearchUsersHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if strings.Contains(w.Body.String(), "hash") {
+				t.Errorf("adminHandler.SearchUsersHandler() exposed password hash: %s", w.Body.String())
+			}
+			if w.Code == http.StatusOK && len(events) != 1 {
+				t.Errorf("adminHandler.SearchUsersHandler() audited %d events, want 1", len(events))
+			}
+		})
+	}
+}
+
+func Test_adminHandler_GetUserHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		balance        pgadapter.BalanceAdapter
+		wantStatusCode int
+		wantCurrent    float64
+	}{
+		{
+			name:           "With balance",
+			balance:        mockBalanceAdapter{balance: &models.Balance{UserID: "user_id", Amount: 150, Withdrawn: 20}},
+			wantStatusCode: http.StatusOK,
+			wantCurrent:    150,
+		},
+		{
+			name:           "Without balance",
+			balance:        mockBalanceAdapter{},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Balance error",
+			balance:        mockBalanceAdapter{err: sql.ErrConnDone},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &adminHandler{user: mockUserAdapter{}, balance: tt.balance, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/users/user_id", nil)
+			h.GetUserHandler(w, withURLParam(r, "id", "user_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.GetUserHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var got models.ResponseAdminUser
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.Balance == nil {
+				t.Fatalf("adminHandler.GetUserHandler() body = %v, err %v", w.Body.String(), err)
+			}
+			if got.Balance.Current != tt.wantCurrent {
+				t.Errorf("adminHandler.GetUserHandler() current = %v, want %v", got.Balance.Current, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_AdjustBalanceHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		user           pgadapter.UserAdapter
+		adjustment     pgadapter.AdjustmentAdapter
+		body           string
+		wantStatusCode int
+		wantAudited    bool
+	}{
+		{
+			name:           "Credited",
+			user:           mockUserAdapter{},
+			adjustment:     mockAdjustmentAdapter{},
+			body:           `{"amount": 100, "reason": "compensation for lost order"}`,
+			wantStatusCode: http.StatusCreated,
+			wantAudited:    true,
+		},
+		{
+			name:           "Missing reason",
+			user:           mockUserAdapter{},
+			adjustment:     mockAdjustmentAdapter{},
+			body:           `{"amount": 100, "reason": " "}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Zero amount",
+			user:           mockUserAdapter{},
+			adjustment:     mockAdjustmentAdapter{},
+			body:           `{"amount": 0, "reason": "nothing"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Debit over balance",
+			user:           mockUserAdapter{},
+			adjustment:     mockAdjustmentAdapter{err: models.ErrorInsufficientFunds},
+			body:           `{"amount": -100, "reason": "fraud"}`,
+			wantStatusCode: http.StatusPaymentRequired,
+		},
+		{
+			name:           "Unknown user",
+			user:           mockUserAdapter{err: sql.ErrNoRows},
+			adjustment:     mockAdjustmentAdapter{},
+			body:           `{"amount": 100, "reason": "compensation"}`,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &adminHandler{user: tt.user, adjustment: tt.adjustment, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/users/user_id/adjustments", strings.NewReader(tt.body))
+			h.AdjustBalanceHandler(w, withURLParam(r, "id", "user_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.AdjustBalanceHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if audited := len(events) > 0; audited != tt.wantAudited {
+				t.Errorf("adminHandler.AdjustBalanceHandler() audited = %v, want %v", audited, tt.wantAudited)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_LockUserHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		user           pgadapter.UserAdapter
+		wantStatusCode int
+		wantAction     string
+	}{
+		{
+			name:           "Locked",
+			user:           mockUserAdapter{},
+			wantStatusCode: http.StatusNoContent,
+			wantAction:     models.AuditLockUser,
+		},
+		{
+			name:           "Unknown user",
+			user:           mockUserAdapter{err: models.ErrorNotFound},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			var revoked []string
+			h := &adminHandler{
+				user:   tt.user,
+				audit:  mockRecorder{events: &events},
+				tokens: mockTokenAdapter{revokedUsers: &revoked},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/users/user_id/lock", nil)
+			h.LockUserHandler(w, withURLParam(r, "id", "user_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.LockUserHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if wantRevoked := w.Code == http.StatusNoContent; wantRevoked != (len(revoked) > 0 && revoked[0] == "user_id") {
+				t.Errorf("adminHandler.LockUserHandler() revoked sessions of %v, want revoked %v", revoked, wantRevoked)
+			}
+			if tt.wantAction != "" && (len(events) != 1 || events[0].Action != tt.wantAction) {
+				t.Errorf("adminHandler.LockUserHandler() audited %v, want %v", events, tt.wantAction)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_RepollOrderHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		order          pgadapter.OrderAdapter
+		wantStatusCode int
+	}{
+		{
+			name:           "Repolled",
+			order:          mockOrderAdapter{order: &models.Order{ID: "2377225624", Status: models.OrderStatusNew}},
+			wantStatusCode: http.StatusAccepted,
+		},
+		{
+			name:           "Already processed",
+			order:          mockOrderAdapter{order: &models.Order{ID: "2377225624", Status: models.OrderStatusProcessed}},
+			wantStatusCode: http.StatusConflict,
+		},
+		{
+			name:           "Unknown order",
+			order:          mockOrderAdapter{},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &adminHandler{order: tt.order, orderService: mockAccrualAdapter{}, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/orders/2377225624/repoll", nil)
+			h.RepollOrderHandler(w, withURLParam(r, "number", "2377225624"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.RepollOrderHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_SetRoleHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		user           pgadapter.UserAdapter
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Promoted",
+			user:           mockUserAdapter{},
+			body:           `{"role": "support"}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Unknown role",
+			user:           mockUserAdapter{},
+			body:           `{"role": "root"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown user",
+			user:           mockUserAdapter{err: models.ErrorNotFound},
+			body:           `{"role": "admin"}`,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			var revoked []string
+			h := &adminHandler{
+				user:   tt.user,
+				audit:  mockRecorder{events: &events},
+				tokens: mockTokenAdapter{revokedUsers: &revoked},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "/users/user_id/role", strings.NewReader(tt.body))
+			h.SetRoleHandler(w, withURLParam(r, "id", "user_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.SetRoleHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if wantRevoked := w.Code == http.StatusNoContent; wantRevoked != (len(revoked) > 0 && revoked[0] == "user_id") {
+				t.Errorf("adminHandler.SetRoleHandler() revoked sessions of %v, want revoked %v", revoked, wantRevoked)
+			}
+			if w.Code == http.StatusNoContent && (len(events) != 1 || events[0].Action != models.AuditChangeRole) {
+				t.Errorf("adminHandler.SetRoleHandler() audited %v, want %v", events, models.AuditChangeRole)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_GetAuditEventsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantStatusCode int
+		wantFilter     models.AuditFilter
+	}{
+		{
+			name:           "Filtered",
+			query:          "?actor=admin_id&action=LOGIN_FAILED&limit=10",
+			wantStatusCode: http.StatusOK,
+			wantFilter:     models.AuditFilter{Actor: "admin_id", Action: models.AuditLoginFailed, Limit: 10},
+		},
+		{
+			name:           "Default limit",
+			query:          "?subject=user_id",
+			wantStatusCode: http.StatusOK,
+			wantFilter:     models.AuditFilter{Subject: "user_id", Limit: defaultStatementLimit},
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=0",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid from",
+			query:          "?from=yesterday",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter models.AuditFilter
+			var events []*models.AuditEvent
+			h := &adminHandler{events: mockAuditAdapter{filter: &filter}, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			h.GetAuditEventsHandler(w, httptest.NewRequest("GET", "/audit"+tt.query, nil))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.GetAuditEventsHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if filter != tt.wantFilter {
+				t.Errorf("adminHandler.GetAuditEventsHandler() filter = %+v, want %+v", filter, tt.wantFilter)
+			}
+			if len(events) != 1 || events[0].Action != models.AuditViewAudit {
+				t.Errorf("adminHandler.GetAuditEventsHandler() audited %v, want %v", events, models.AuditViewAudit)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_ExportAuditEventsHandler(t *testing.T) {
+	event := &models.AuditEvent{
+		ID:        "event_id",
+		Actor:     "admin_id",
+		Action:    models.AuditLockUser,
+		Subject:   "user_id",
+		Payload:   []byte(`{}`),
+		RequestID: "request_id",
+		IP:        "192.0.2.1",
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	tests := []struct {
+		name           string
+		query          string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "CSV",
+			query:          "",
+			wantStatusCode: http.StatusOK,
+			wantBody: "id,created_at,actor,action,subject,request_id,ip,payload\n" +
+				"event_id,2026-01-02T03:04:05Z,admin_id,LOCK_USER,user_id,request_id,192.0.2.1,{}\n",
+		},
+		{
+			name:           "NDJSON",
+			query:          "?format=ndjson",
+			wantStatusCode: http.StatusOK,
+			wantBody: `{"id":"event_id","actor":"admin_id","action":"LOCK_USER","subject":"user_id","payload":{},` +
+				`"request_id":"request_id","ip":"192.0.2.1","created_at":"2026-01-02T03:04:05Z"}` + "\n",
+		},
+		{
+			name:           "Unknown format",
+			query:          "?format=xml",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &adminHandler{events: mockAuditAdapter{events: []*models.AuditEvent{event}}, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			h.ExportAuditEventsHandler(w, httptest.NewRequest("GET", "/audit/export"+tt.query, nil))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("adminHandler.ExportAuditEventsHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("adminHandler.ExportAuditEventsHandler() body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if w.Code == http.StatusOK && (len(events) != 1 || events[0].Action != models.AuditExportAudit) {
+				t.Errorf("adminHandler.ExportAuditEventsHandler() audited %v, want %v", events, models.AuditExportAudit)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+type CampaignHandler interface {
+	CreateCampaignHandler(w http.ResponseWriter, r *http.Request)
+	GetCampaignsHandler(w http.ResponseWriter, r *http.Request)
+	GetCampaignHandler(w http.ResponseWriter, r *http.Request)
+	UpdateCampaignHandler(w http.ResponseWriter, r *http.Request)
+	DeleteCampaignHandler(w http.ResponseWriter, r *http.Request)
+}
+type campaignHandler struct {
+	campaign pgadapter.CampaignAdapter
+	audit    audit.Recorder
+}
+
+func NewCampaignHandler(campaign pgadapter.CampaignAdapter, auditor audit.Recorder) CampaignHandler {
+	return &campaignHandler{campaign: campaign, audit: auditor}
+}
+
+func (h *campaignHandler) CreateCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	campaign, ok := decodeCampaign(w, r)
+	if !ok {
+		return
+	}
+	campaign.ID = helpers.GenerateUUID()
+	campaign.Spent = 0
+	campaign.CreatedAt = time.Now()
+	if err := h.campaign.CreateCampaign(r.Context(), campaign); err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditCreateCampaign, campaign.ID, campaign))
+	writeJSON(w, http.StatusCreated, campaign)
+}
+
+func (h *campaignHandler) GetCampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	campaigns, err := h.campaign.ReadCampaigns(r.Context())
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if campaigns == nil {
+		campaigns = []*models.Campaign{}
+	}
+	writeJSON(w, http.StatusOK, campaigns)
+}
+
+func (h *campaignHandler) GetCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	campaign, err := h.campaign.ReadCampaign(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaign)
+}
+
+func (h *campaignHandler) UpdateCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	campaign, ok := decodeCampaign(w, r)
+	if !ok {
+		return
+	}
+	campaign.ID = chi.URLParam(r, "id")
+	if err := h.campaign.UpdateCampaign(r.Context(), campaign); err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditUpdateCampaign, campaign.ID, campaign))
+
+	// Return stored campaign, so spent budget is shown
+	campaign, err := h.campaign.ReadCampaign(r.Context(), campaign.ID)
+	if err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaign)
+}
+
+func (h *campaignHandler) DeleteCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.campaign.DeleteCampaign(r.Context(), id); err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditDeleteCampaign, id, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeCampaign reads campaign from request body and writes 400 if it is invalid
+func decodeCampaign(w http.ResponseWriter, r *http.Request) (*models.Campaign, bool) {
+	var campaign *models.Campaign
+	err := json.NewDecoder(r.Body).Decode(&campaign)
+	if err != nil || campaign == nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil, false
+	}
+	if campaign.Name == "" || !campaign.EndsAt.After(campaign.StartsAt) ||
+		campaign.Multiplier < 0 || campaign.Bonus < 0 || campaign.PerUserCap < 0 || campaign.Budget < 0 ||
+		(campaign.Multiplier <= 1 && campaign.Bonus == 0) {
+		log.Debug().Msg("Invalid campaign")
+		http.Error(w, "Invalid campaign", http.StatusBadRequest)
+		return nil, false
+	}
+	return campaign, true
+}
+
+func writeCampaignError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrorNotFound) {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return
+	}
+	log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+	http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+}
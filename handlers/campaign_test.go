@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withURLParam imitates chi routing of url parameter
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func Test_campaignHandler_CreateCampaignHandler(t *testing.T) {
+	type args struct {
+		w *httptest.ResponseRecorder
+		r *http.Request
+	}
+	tests := []struct {
+		name           string
+		campaign       pgadapter.CampaignAdapter
+		args           args
+		wantStatusCode int
+	}{
+		{
+			name:     "Double points weekend",
+			campaign: mockCampaignAdapter{},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/campaigns", strings.NewReader(`{
+					"name": "Double points",
+					"starts_at": "2023-06-03T00:00:00Z",
+					"ends_at": "2023-06-05T00:00:00Z",
+					"multiplier": 2,
+					"budget": 10000
+				}`)),
+			},
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name:     "Window ends before start",
+			campaign: mockCampaignAdapter{},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/campaigns", strings.NewReader(`{
+					"name": "Broken",
+					"starts_at": "2023-06-05T00:00:00Z",
+					"ends_at": "2023-06-03T00:00:00Z",
+					"bonus": 10
+				}`)),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Neither multiplier nor bonus",
+			campaign: mockCampaignAdapter{},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/campaigns", strings.NewReader(`{
+					"name": "Nothing",
+					"starts_at": "2023-06-03T00:00:00Z",
+					"ends_at": "2023-06-05T00:00:00Z",
+					"multiplier": 1
+				}`)),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Internal error",
+			campaign: mockCampaignAdapter{err: errors.New("error")},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/campaigns", strings.NewReader(`{
+					"name": "Bonus",
+					"starts_at": "2023-06-03T00:00:00Z",
+					"ends_at": "2023-06-05T00:00:00Z",
+					"bonus": 50
+				}`)),
+			},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &campaignHandler{campaign: tt.campaign, audit: mockRecorder{events: &events}}
+			h.CreateCampaignHandler(tt.args.w, tt.args.r)
+			if tt.args.w.Code != tt.wantStatusCode {
+				t.Errorf("campaignHandler.CreateCampaignHandler() error = %v, wantErr %v", tt.args.w.Code, tt.wantStatusCode)
+			}
+			if tt.args.w.Code == http.StatusCreated && (len(events) != 1 || events[0].Action != models.AuditCreateCampaign) {
+				t.Errorf("campaignHandler.CreateCampaignHandler() audited %v, want %v", events, models.AuditCreateCampaign)
+			}
+		})
+	}
+}
+
+func Test_campaignHandler_GetCampaignHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		campaign       pgadapter.CampaignAdapter
+		wantStatusCode int
+	}{
+		{
+			name:           "Found",
+			campaign:       mockCampaignAdapter{campaign: &models.Campaign{ID: "id", Name: "Double points"}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Not found",
+			campaign:       mockCampaignAdapter{},
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Internal error",
+			campaign:       mockCampaignAdapter{err: errors.New("error")},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &campaignHandler{campaign: tt.campaign}
+			w := httptest.NewRecorder()
+			h.GetCampaignHandler(w, withURLParam(httptest.NewRequest("GET", "/campaigns/id", nil), "id", "id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("campaignHandler.GetCampaignHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_campaignHandler_DeleteCampaignHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		campaign       pgadapter.CampaignAdapter
+		wantStatusCode int
+	}{
+		{
+			name:           "Deleted",
+			campaign:       mockCampaignAdapter{},
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Not found",
+			campaign:       mockCampaignAdapter{err: models.ErrorNotFound},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &campaignHandler{campaign: tt.campaign, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			h.DeleteCampaignHandler(w, withURLParam(httptest.NewRequest("DELETE", "/campaigns/id", nil), "id", "id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("campaignHandler.DeleteCampaignHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if wantAudited := w.Code == http.StatusNoContent; wantAudited != (len(events) == 1) {
+				t.Errorf("campaignHandler.DeleteCampaignHandler() audited %d events, want audited %v", len(events), wantAudited)
+			}
+		})
+	}
+}
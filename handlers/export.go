@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportFlushEvery is the number of records sent to client at once
+const exportFlushEvery = 100
+
+// recordWriter encodes exported records one by one, Close finishes the document
+type recordWriter interface {
+	Write(record any) error
+	Flush() error
+	Close() error
+}
+
+// csvColumns flattens exported records into csv rows
+type csvColumns struct {
+	header []string
+	row    func(record any) []string
+}
+
+// exportFormat describes supported export format
+type exportFormat struct {
+	contentType string
+	extension   string
+	newWriter   func(w io.Writer, columns csvColumns) recordWriter
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":    {contentType: "text/csv; charset=utf-8", extension: "csv", newWriter: newCSVWriter},
+	"json":   {contentType: "application/json", extension: "json", newWriter: newJSONWriter},
+	"ndjson": {contentType: "application/x-ndjson", extension: "ndjson", newWriter: newNDJSONWriter},
+}
+
+var statementColumns = csvColumns{
+	header: []string{"type", "number", "status", "amount", "date"},
+	row: func(record any) []string {
+		r := record.(*models.ExportRecord)
+		return []string{r.Type, r.Number, r.Status, strconv.FormatFloat(r.Amount, 'f', -1, 64), r.Date.Format(time.RFC3339)}
+	},
+}
+
+var auditColumns = csvColumns{
+	header: []string{"id", "created_at", "actor", "action", "subject", "request_id", "ip", "payload"},
+	row: func(record any) []string {
+		e := record.(*models.AuditEvent)
+		return []string{e.ID, e.CreatedAt.Format(time.RFC3339Nano), e.Actor, e.Action, e.Subject, e.RequestID, e.IP, string(e.Payload)}
+	},
+}
+
+// exportFormatOf reads format query parameter, csv is default
+func exportFormatOf(r *http.Request) (exportFormat, bool) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := exportFormats[name]
+	return format, ok
+}
+
+// exporter streams records to client as attachment, pushing them every exportFlushEvery records.
+// Once started errors can only cut the file short
+type exporter struct {
+	records recordWriter
+	flusher http.Flusher
+	count   int
+}
+
+func startExport(w http.ResponseWriter, format exportFormat, columns csvColumns, filename string) *exporter {
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename + "." + format.extension,
+	}))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	return &exporter{records: format.newWriter(w, columns), flusher: flusher}
+}
+
+func (e *exporter) Write(record any) error {
+	if err := e.records.Write(record); err != nil {
+		return err
+	}
+	e.count++
+	if e.count%exportFlushEvery != 0 {
+		return nil
+	}
+	if err := e.records.Flush(); err != nil {
+		return err
+	}
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+	return nil
+}
+
+func (e *exporter) Close() error {
+	return e.records.Close()
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns csvColumns
+	header  bool
+}
+
+func newCSVWriter(w io.Writer, columns csvColumns) recordWriter {
+	return &csvWriter{w: csv.NewWriter(w), columns: columns}
+}
+
+func (c *csvWriter) Write(record any) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(c.columns.header); err != nil {
+			return err
+		}
+	}
+	return c.w.Write(c.columns.row(record))
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	// Empty export still has a header
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(c.columns.header); err != nil {
+			return err
+		}
+	}
+	return c.Flush()
+}
+
+// jsonWriter writes records as one array without keeping them in memory
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func newJSONWriter(w io.Writer, _ csvColumns) recordWriter {
+	return &jsonWriter{w: w}
+}
+
+func (j *jsonWriter) Write(record any) error {
+	delimiter := ","
+	if j.count == 0 {
+		delimiter = "["
+	}
+	j.count++
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = j.w.Write(append([]byte(delimiter), data...))
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	return nil
+}
+
+func (j *jsonWriter) Close() error {
+	end := "]"
+	if j.count == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer, _ csvColumns) recordWriter {
+	return &ndjsonWriter{encoder: json.NewEncoder(w)}
+}
+
+func (n *ndjsonWriter) Write(record any) error {
+	return n.encoder.Encode(record)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
@@ -3,15 +3,18 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/google/uuid"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
 	"github.com/gynshu-one/gophermart-loyalty-system/external"
+	"github.com/gynshu-one/gophermart-loyalty-system/guard"
 	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
 	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
 	"github.com/gynshu-one/gophermart-loyalty-system/models"
 	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
 	"github.com/rs/zerolog/log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -30,19 +33,43 @@ type handler struct {
 	user           pgadapter.UserAdapter
 	accrualAdapter external.AccrualAdapter
 	withdrawal     pgadapter.WithdrawalAdapter
+	referral       pgadapter.ReferralAdapter
+	household      pgadapter.HouseholdAdapter
+	tokens         pgadapter.TokenAdapter
+	sessions       *middlwares.Sessions
+	logins         *guard.LoginGuard
+	passwords      *guard.PasswordPolicy
+	mfa            pgadapter.MFAAdapter
+	audit          audit.Recorder
 }
 
 func NewHandler(balance pgadapter.BalanceAdapter,
 	order pgadapter.OrderAdapter,
 	user pgadapter.UserAdapter,
 	withdrawal pgadapter.WithdrawalAdapter,
-	orderService external.AccrualAdapter) Handler {
+	orderService external.AccrualAdapter,
+	referral pgadapter.ReferralAdapter,
+	household pgadapter.HouseholdAdapter,
+	tokens pgadapter.TokenAdapter,
+	sessions *middlwares.Sessions,
+	logins *guard.LoginGuard,
+	passwords *guard.PasswordPolicy,
+	mfa pgadapter.MFAAdapter,
+	auditor audit.Recorder) Handler {
 	return &handler{
 		balance:        balance,
 		order:          order,
 		accrualAdapter: orderService,
 		user:           user,
 		withdrawal:     withdrawal,
+		referral:       referral,
+		household:      household,
+		tokens:         tokens,
+		sessions:       sessions,
+		logins:         logins,
+		passwords:      passwords,
+		mfa:            mfa,
+		audit:          auditor,
 	}
 }
 func (h *handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -69,6 +96,31 @@ func (h *handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	if strings.Contains(user.Login, models.LoginSeparator) {
+		log.Debug().Msgf("Bad request: %v", models.ErrorReservedLogin)
+		http.Error(w, models.ErrorReservedLogin.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = h.passwords.Validate(user.Login, user.Password); err != nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Optional referral code of inviting user
+	var invite struct {
+		ReferralCode string `json:"referral_code"`
+	}
+	_ = json.Unmarshal(body, &invite)
+	var referrer *models.User
+	if invite.ReferralCode != "" {
+		referrer, err = h.user.ReadUserByReferralCode(r.Context(), invite.ReferralCode)
+		if err != nil {
+			log.Debug().Msgf("%s: %v", models.ErrorUnknownReferralCode.Error(), err)
+			http.Error(w, "Unknown referral code", http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Create and check user
 	user.ID = helpers.GenerateUUID()
@@ -77,6 +129,10 @@ func (h *handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			log.Debug().Msg("User already exists")
 			http.Error(w, "User already exists", http.StatusConflict)
 			return
+		} else if errors.Is(err, models.ErrorReservedLogin) {
+			log.Debug().Msgf("Bad request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		} else {
 			log.Debug().Msgf("Internal server error: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -84,23 +140,37 @@ func (h *handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	event := audit.FromRequest(r, models.AuditRegister, user.ID, map[string]any{
+		"login":         user.Login,
+		"referral_code": invite.ReferralCode,
+	})
+	event.Actor = user.ID
+	h.audit.Record(r.Context(), event)
+
+	// Bonus is credited later, when the first order is processed
+	if referrer != nil {
+		err = h.referral.CreateReferral(r.Context(), &models.Referral{
+			ID:         helpers.GenerateUUID(),
+			ReferrerID: referrer.ID,
+			ReferredID: user.ID,
+			Status:     models.ReferralStatusPending,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create referral")
+		}
+	}
+
 	// Authorize user
-	jwt, err := middlwares.GenerateJWT(user.ID)
+	tokens, err := logIn(w, r, h.tokens, h.sessions, user)
 	if err != nil {
 		log.Debug().Msgf("Internal server error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Authorization",
-		Value:    jwt,
-		HttpOnly: true,
-		Secure:   false,
-	})
 
 	// Return response
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Registered, Please login!"))
+	writeLoggedIn(w, tokens, "Registered!")
 }
 
 func (h *handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -127,36 +197,71 @@ func (h *handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Save password before hashing
-	pass := user.Password
+	// Save password and login before reading user
+	pass, login := user.Password, user.Login
+	ip := helpers.ClientIP(r)
+
+	// Throttle password guessing before the password is checked
+	if retryAfter, err := h.logins.Check(r.Context(), login, ip); err != nil {
+		writeThrottled(w, retryAfter, err)
+		return
+	}
 
 	// Read user from db
-	user, err = h.user.ReadUser(r.Context(), user.Login)
+	user, err = h.user.ReadUser(r.Context(), login)
 
 	// Check password
 	if err != nil || !helpers.CheckPasswordHash(pass, user.Password) {
 		log.Debug().Msgf("Invalid username or password: %v", err)
+		subject := ""
+		if err == nil {
+			subject = user.ID
+		}
+		h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditLoginFailed, subject, map[string]any{
+			"login":  login,
+			"reason": "invalid credentials",
+		}))
+		countFailedLogin(r, h.logins, h.audit, login, subject)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	if user.LockedAt != nil {
+		log.Debug().Msgf("Account %s is locked", user.ID)
+		h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditLoginFailed, user.ID, map[string]any{
+			"login":  login,
+			"reason": "locked",
+		}))
+		http.Error(w, "Account locked", http.StatusLocked)
+		return
+	}
+
+	// Second factor goes after the password, failures are forgotten only once it is given too
+	if purpose, err := mfaPurpose(r.Context(), h.mfa, user); err != nil || purpose != "" {
+		if err != nil {
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeMFARequired(w, user, purpose)
+		return
+	}
+	if err = h.logins.Succeed(r.Context(), login); err != nil {
+		log.Error().Err(err).Msgf("Failed to forget failed logins to %s", login)
+	}
 
 	// Authorize user
-	jwt, err := middlwares.GenerateJWT(user.ID)
+	tokens, err := logIn(w, r, h.tokens, h.sessions, user)
 	if err != nil {
 		log.Debug().Msgf("Internal server error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Authorization",
-		Value:    jwt,
-		HttpOnly: true,
-		Secure:   false,
-	})
+	event := audit.FromRequest(r, models.AuditLogin, user.ID, map[string]any{"login": login})
+	event.Actor = user.ID
+	h.audit.Record(r.Context(), event)
 
 	// Return response
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Logged in!"))
+	writeLoggedIn(w, tokens, "Logged in!")
 }
 func (h *handler) AddOrderHandler(w http.ResponseWriter, r *http.Request) {
 	userID, _ := r.Context().Value(models.UserID).(string)
@@ -169,21 +274,26 @@ func (h *handler) AddOrderHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
 		return
 	}
+	submitOrder(w, r, h.order, h.accrualAdapter, userID, OrderID)
+}
 
+// submitOrder validates order of user and starts following it, it is shared by users and merchants.
+// It reports whether the order was accepted as new one
+func submitOrder(w http.ResponseWriter, r *http.Request, orders pgadapter.OrderAdapter, accrual external.AccrualAdapter, userID, OrderID string) bool {
 	// Lunar func also checks if order is not empty
 	ok := helpers.LunaOrderCheck(OrderID)
 	if !ok {
 		log.Debug().Msgf("Wrong order id %s", OrderID)
 		http.Error(w, "Wrong order id", http.StatusUnprocessableEntity)
-		return
+		return false
 	}
 
 	// Check if order already exists
-	order, err := h.order.ReadOrder(r.Context(), models.ID.EqualTo(OrderID))
+	order, err := orders.ReadOrder(r.Context(), models.ID.EqualTo(OrderID))
 	if err != nil {
 		log.Debug().Msgf("Internal server error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// If already added by this user
@@ -191,29 +301,30 @@ func (h *handler) AddOrderHandler(w http.ResponseWriter, r *http.Request) {
 		if order[0].UserID == userID {
 			log.Debug().Msg("Order already added by this user")
 			http.Error(w, "Order already added by this user", http.StatusOK)
-			return
+			return false
 		} else {
 			log.Debug().Msg("Order already added by another user")
 			http.Error(w, "Order already added by another user", http.StatusConflict)
-			return
+			return false
 		}
 	}
 
 	// Add order to db and fallow it until processed
-	err = h.accrualAdapter.FallowOrder(&models.Order{
+	err = accrual.FallowOrder(&models.Order{
 		ID:     OrderID,
 		UserID: userID,
 	})
 	if err != nil {
 		log.Debug().Msgf("Internal server error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Return response
 	w.Header().Add("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusAccepted)
 	w.Write([]byte("Order created and processing"))
+	return true
 }
 
 func (h *handler) GetOrderHandler(w http.ResponseWriter, r *http.Request) {
@@ -265,11 +376,56 @@ func (h *handler) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Find balance by user id
 	balance, err := h.balance.ReadBalance(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
 	if balance == nil {
 		log.Debug().Msgf("No balance for user %s", userID)
 		http.Error(w, "No balance", http.StatusNoContent)
 		return
 	}
+
+	// Find points which are about to expire
+	expiring, err := h.balance.ReadExpiring(r.Context(), userID, time.Now().Add(config.GetConfig().ExpiringSoonWindow))
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := models.ResponseBalance{
+		Current:   balance.Amount,
+		Withdrawn: balance.Withdrawn,
+		Expiring:  expiring,
+	}
+	for _, e := range expiring {
+		response.ExpiringSoon += e.Amount
+	}
+
+	// Household pool is shown separately from personal balance
+	member, err := h.household.ReadMembership(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if member != nil {
+		household, err := h.household.ReadHousehold(r.Context(), member.HouseholdID)
+		if err != nil {
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Household = &models.ResponseHouseholdBalance{
+			Name:      household.Name,
+			Current:   household.Amount,
+			Withdrawn: household.Withdrawn,
+		}
+	}
+
+	// Pack
+	balanceJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
 		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
@@ -279,7 +435,7 @@ func (h *handler) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
 	// Send
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf(`{"current": %f, "withdrawn": %f}`, balance.Amount, balance.Withdrawn)))
+	w.Write(balanceJSON)
 }
 
 func (h *handler) WithdrawBalanceHandler(w http.ResponseWriter, r *http.Request) {
@@ -319,43 +475,48 @@ func (h *handler) WithdrawBalanceHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Increment withdrawn balance
-	err = h.balance.IncrementWithdrawn(r.Context(), userID, bodyJSON.Sum)
-	if err != nil {
-		if errors.Is(err, models.ErrorInsufficientFunds) {
-			log.Debug().Msg("Insufficient funds")
-			http.Error(w, "Insufficient funds", http.StatusPaymentRequired)
-			return
-		}
-		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
-		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Fallow order continuously
-	err = h.accrualAdapter.FallowOrder(&models.Order{
-		ID:     bodyJSON.Order,
-		UserID: userID,
-	})
-	if err != nil {
-		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
-		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
-		return
+	// Register order, debit balance and record withdrawal at once
+	now := time.Now()
+	newOrder := &models.Order{
+		ID:         bodyJSON.Order,
+		UserID:     userID,
+		Status:     models.OrderStatusNew,
+		UploadedAt: now,
+		UpdatedAt:  now,
 	}
-
-	// Create withdrawal record
 	withdrawal := &models.Withdrawal{
 		ID:          uuid.New().String(),
 		UserID:      userID,
 		OrderID:     bodyJSON.Order,
 		Sum:         bodyJSON.Sum,
-		ProcessedAt: time.Now(),
+		ProcessedAt: now,
 	}
-	if err = h.withdrawal.CreateWithdrawal(r.Context(), withdrawal); err != nil {
-		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+	err = h.withdrawal.Withdraw(r.Context(), newOrder, withdrawal)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrorInsufficientFunds):
+			log.Debug().Msg("Insufficient funds")
+			http.Error(w, "Insufficient funds", http.StatusPaymentRequired)
+		case errors.Is(err, models.ErrorOrderRegistered):
+			log.Debug().Msgf("Order already registered %s", bodyJSON.Order)
+			http.Error(w, "Wrong order id", http.StatusUnprocessableEntity)
+		default:
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
+	// Fallow order continuously, withdrawal is already done, so order that isn't followed can be repolled by admin
+	if err = h.accrualAdapter.FallowOrder(newOrder); err != nil {
+		log.Error().Err(err).Msgf("Failed to follow order %s", newOrder.ID)
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditWithdraw, userID, map[string]any{
+		"withdrawal_id": withdrawal.ID,
+		"order":         withdrawal.OrderID,
+		"sum":           withdrawal.Sum,
+	}))
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -394,3 +555,39 @@ func (h *handler) GetWithdrawalsHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	w.Write(withdrawalsJSON)
 }
+
+// writeThrottled responds to login refused by guard, see guard.LoginGuard.Check
+func writeThrottled(w http.ResponseWriter, retryAfter time.Duration, err error) {
+	switch {
+	case errors.Is(err, models.ErrorLoginLocked):
+		setRetryAfter(w, retryAfter)
+		http.Error(w, "Account temporarily locked", http.StatusLocked)
+	case errors.Is(err, models.ErrorRequestLimitExceeded):
+		setRetryAfter(w, retryAfter)
+		http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+	default:
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+	}
+}
+
+// countFailedLogin counts failed password or second factor of login and audits lockouts it causes,
+// subject is id of user if login is known
+func countFailedLogin(r *http.Request, logins *guard.LoginGuard, auditor audit.Recorder, login, subject string) {
+	ip := helpers.ClientIP(r)
+	lockouts, err := logins.Fail(r.Context(), login, ip)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to count failed login to %s", login)
+	}
+	for _, lockout := range lockouts {
+		lockoutSubject := ""
+		if lockout.Login != "" {
+			lockoutSubject = subject
+		}
+		auditor.Record(r.Context(), audit.FromRequest(r, models.AuditLoginLockout, lockoutSubject, map[string]any{
+			"login": lockout.Login,
+			"ip":    lockout.IP,
+			"until": lockout.Until,
+		}))
+	}
+}
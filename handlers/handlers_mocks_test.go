@@ -4,17 +4,44 @@ import (
 	"context"
 	"github.com/gynshu-one/gophermart-loyalty-system/models"
 	comp "github.com/gynshu-one/gophermart-loyalty-system/pgadapter/composer"
+	"time"
 )
 
 type mockUserAdapter struct {
-	err error
+	user *models.User
+	err  error
 }
 
 func (m mockUserAdapter) CreateUser(ctx context.Context, user *models.User) error {
 	return m.err
 }
 func (m mockUserAdapter) ReadUser(ctx context.Context, login string) (*models.User, error) {
-	return nil, m.err
+	return m.user, m.err
+}
+func (m mockUserAdapter) ReadUserByID(ctx context.Context, id string) (*models.User, error) {
+	if m.user != nil {
+		return m.user, m.err
+	}
+	return &models.User{ID: id}, m.err
+}
+func (m mockUserAdapter) ReadUserByReferralCode(ctx context.Context, code string) (*models.User, error) {
+	return &models.User{ReferralCode: code}, m.err
+}
+func (m mockUserAdapter) SearchUsers(ctx context.Context, login string, limit int) ([]*models.User, error) {
+	var out []*models.User
+	if m.user != nil {
+		out = append(out, m.user)
+	}
+	return out, m.err
+}
+func (m mockUserAdapter) SetLocked(ctx context.Context, id string, locked bool) error {
+	return m.err
+}
+func (m mockUserAdapter) SetRole(ctx context.Context, id, role string) error {
+	return m.err
+}
+func (m mockUserAdapter) SetPassword(ctx context.Context, id, password string) error {
+	return m.err
 }
 
 type mockAccrualAdapter struct {
@@ -24,6 +51,12 @@ type mockAccrualAdapter struct {
 func (m mockAccrualAdapter) FallowOrder(order *models.Order) error {
 	return m.err
 }
+func (m mockAccrualAdapter) Repoll(order *models.Order) error {
+	if order.Status == models.OrderStatusProcessed {
+		return models.ErrorOrderProcessed
+	}
+	return m.err
+}
 
 type mockOrderAdapter struct {
 	order *models.Order
@@ -43,30 +76,478 @@ func (m mockOrderAdapter) CreateOrder(ctx context.Context, order *models.Order)
 func (m mockOrderAdapter) UpdateOrders(ctx context.Context, orders ...*models.Order) error {
 	return nil
 }
+func (m mockOrderAdapter) SumAccrual(ctx context.Context, userID string, since time.Time) (float64, error) {
+	return 0, m.err
+}
+func (m mockOrderAdapter) StreamOrders(ctx context.Context, userID string, from, to time.Time, fn func(order *models.Order) error) error {
+	if m.order != nil {
+		if err := fn(m.order); err != nil {
+			return err
+		}
+	}
+	return m.err
+}
 
 type mockBalanceAdapter struct {
-	balance *models.Balance
-	err     error
+	balance  *models.Balance
+	expiring []*models.ExpiringPoints
+	err      error
 }
 
 func (m mockBalanceAdapter) ReadBalance(ctx context.Context, userID string) (*models.Balance, error) {
 	return m.balance, m.err
 }
-func (m mockBalanceAdapter) IncrementBalance(ctx context.Context, userID string, amount float64) error {
+func (m mockBalanceAdapter) IncrementBalance(ctx context.Context, userID string, amount float64, source, reference string) error {
 	return m.err
 }
-func (m mockBalanceAdapter) IncrementWithdrawn(ctx context.Context, userID string, amount float64) error {
+func (m mockBalanceAdapter) CreditOrder(ctx context.Context, userID, orderID string, accrual, bonus float64) error {
 	return m.err
 }
+func (m mockBalanceAdapter) ReadExpiring(ctx context.Context, userID string, until time.Time) ([]*models.ExpiringPoints, error) {
+	return m.expiring, m.err
+}
+func (m mockBalanceAdapter) ExpireLots(ctx context.Context, now time.Time) (int, error) {
+	return 0, m.err
+}
 
 type mockWithdrawalAdapter struct {
 	withdrawal []*models.Withdrawal
+	created    *[]*models.Withdrawal
 	err        error
 }
 
+func (m mockWithdrawalAdapter) Withdraw(ctx context.Context, order *models.Order, withdrawal *models.Withdrawal) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.created != nil {
+		*m.created = append(*m.created, withdrawal)
+	}
+	return nil
+}
 func (m mockWithdrawalAdapter) CreateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error {
+	if m.created != nil {
+		*m.created = append(*m.created, withdrawal)
+	}
 	return nil
 }
 func (m mockWithdrawalAdapter) ReadWithdrawal(ctx context.Context, userID string) ([]*models.Withdrawal, error) {
 	return m.withdrawal, m.err
 }
+func (m mockWithdrawalAdapter) StreamWithdrawals(ctx context.Context, userID string, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error {
+	for _, withdrawal := range m.withdrawal {
+		if err := fn(withdrawal); err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+type mockTiers struct {
+	tier *models.UserTier
+	err  error
+}
+
+func (m mockTiers) Evaluate(ctx context.Context, userID string) (*models.UserTier, error) {
+	return m.tier, m.err
+}
+
+type mockReferralAdapter struct {
+	referrals []*models.Referral
+	err       error
+}
+
+func (m mockReferralAdapter) CreateReferral(ctx context.Context, referral *models.Referral) error {
+	return m.err
+}
+func (m mockReferralAdapter) ReadReferrals(ctx context.Context, referrerID string) ([]*models.Referral, error) {
+	return m.referrals, m.err
+}
+func (m mockReferralAdapter) ClaimReferral(ctx context.Context, referredID string, bonus float64, limit int) (*models.Referral, error) {
+	return nil, m.err
+}
+
+type mockCampaignAdapter struct {
+	campaign *models.Campaign
+	err      error
+}
+
+func (m mockCampaignAdapter) CreateCampaign(ctx context.Context, campaign *models.Campaign) error {
+	return m.err
+}
+func (m mockCampaignAdapter) ReadCampaigns(ctx context.Context) ([]*models.Campaign, error) {
+	var out []*models.Campaign
+	if m.campaign != nil {
+		out = append(out, m.campaign)
+	}
+	return out, m.err
+}
+func (m mockCampaignAdapter) ReadCampaign(ctx context.Context, id string) (*models.Campaign, error) {
+	if m.campaign == nil && m.err == nil {
+		return nil, models.ErrorNotFound
+	}
+	return m.campaign, m.err
+}
+func (m mockCampaignAdapter) ReadActiveCampaigns(ctx context.Context, at time.Time) ([]*models.Campaign, error) {
+	return m.ReadCampaigns(ctx)
+}
+func (m mockCampaignAdapter) UpdateCampaign(ctx context.Context, campaign *models.Campaign) error {
+	return m.err
+}
+func (m mockCampaignAdapter) DeleteCampaign(ctx context.Context, id string) error {
+	return m.err
+}
+func (m mockCampaignAdapter) Contribute(ctx context.Context, contribution *models.CampaignContribution) (float64, error) {
+	return contribution.Amount, m.err
+}
+
+type mockVoucherAdapter struct {
+	redemption *models.VoucherRedemption
+	err        error
+}
+
+func (m mockVoucherAdapter) CreateVouchers(ctx context.Context, vouchers ...*models.Voucher) error {
+	return m.err
+}
+func (m mockVoucherAdapter) ReadVouchers(ctx context.Context) ([]*models.Voucher, error) {
+	return nil, m.err
+}
+func (m mockVoucherAdapter) RedeemVoucher(ctx context.Context, codeHash, userID string) (*models.VoucherRedemption, error) {
+	return m.redemption, m.err
+}
+
+type mockTransferAdapter struct {
+	transfers []*models.Transfer
+	err       error
+}
+
+func (m mockTransferAdapter) CreateTransfer(ctx context.Context, transfer *models.Transfer, dailyLimit float64) error {
+	return m.err
+}
+func (m mockTransferAdapter) ReadTransfers(ctx context.Context, userID string) ([]*models.Transfer, error) {
+	return m.transfers, m.err
+}
+
+type mockHouseholdAdapter struct {
+	household   *models.Household
+	member      *models.HouseholdMember
+	invitations []*models.HouseholdInvitation
+	// left is set to user who left household
+	left *string
+	err  error
+}
+
+func (m mockHouseholdAdapter) CreateHousehold(ctx context.Context, household *models.Household) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) ReadHousehold(ctx context.Context, id string) (*models.Household, error) {
+	return m.household, m.err
+}
+func (m mockHouseholdAdapter) ReadMembership(ctx context.Context, userID string) (*models.HouseholdMember, error) {
+	return m.member, m.err
+}
+func (m mockHouseholdAdapter) ReadMembers(ctx context.Context, householdID string) ([]*models.HouseholdMember, error) {
+	return []*models.HouseholdMember{m.member}, m.err
+}
+func (m mockHouseholdAdapter) RemoveMember(ctx context.Context, householdID, userID string) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) LeaveHousehold(ctx context.Context, householdID, userID string) error {
+	if m.left != nil {
+		*m.left = userID
+	}
+	return m.err
+}
+func (m mockHouseholdAdapter) TransferOwnership(ctx context.Context, householdID, userID string) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) SetPooling(ctx context.Context, userID string, pooling bool) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) CreateInvitation(ctx context.Context, invitation *models.HouseholdInvitation) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) ReadInvitations(ctx context.Context, userID string) ([]*models.HouseholdInvitation, error) {
+	return m.invitations, m.err
+}
+func (m mockHouseholdAdapter) AcceptInvitation(ctx context.Context, id, userID string) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) DeclineInvitation(ctx context.Context, id, userID string) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) CancelInvitation(ctx context.Context, id, householdID string) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) CreditPool(ctx context.Context, entry *models.HouseholdEntry) error {
+	return m.err
+}
+func (m mockHouseholdAdapter) WithdrawPool(ctx context.Context, entry *models.HouseholdEntry) error {
+	return m.err
+}
+
+type mockStatementAdapter struct {
+	entries   []*models.StatementEntry
+	filter    *models.StatementFilter
+	statement *models.MonthlyStatement
+	err       error
+}
+
+func (m mockStatementAdapter) ReadStatement(ctx context.Context, userID string, filter models.StatementFilter) ([]*models.StatementEntry, error) {
+	if m.filter != nil {
+		*m.filter = filter
+	}
+	return m.entries, m.err
+}
+func (m mockStatementAdapter) GenerateMonthlyStatements(ctx context.Context, month time.Time) (int, error) {
+	return 0, m.err
+}
+func (m mockStatementAdapter) ReadMonthlyStatement(ctx context.Context, userID, month string) (*models.MonthlyStatement, error) {
+	return m.statement, m.err
+}
+
+type mockAdjustmentAdapter struct {
+	err error
+}
+
+func (m mockAdjustmentAdapter) CheckBalances(ctx context.Context, after string) ([]*models.BalanceDrift, string, error) {
+	return nil, "", m.err
+}
+func (m mockAdjustmentAdapter) CorrectDrift(ctx context.Context, userID, reason, actor string) (*models.Adjustment, error) {
+	return nil, m.err
+}
+func (m mockAdjustmentAdapter) AdjustBalance(ctx context.Context, adjustment *models.Adjustment) error {
+	return m.err
+}
+
+type mockAuditAdapter struct {
+	events []*models.AuditEvent
+	filter *models.AuditFilter
+	err    error
+}
+
+func (m mockAuditAdapter) CreateEvent(ctx context.Context, event *models.AuditEvent) error {
+	return m.err
+}
+func (m mockAuditAdapter) ReadEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	if m.filter != nil {
+		*m.filter = filter
+	}
+	return m.events, m.err
+}
+func (m mockAuditAdapter) StreamEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	for _, event := range m.events {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+type mockRecorder struct {
+	events *[]*models.AuditEvent
+}
+
+func (m mockRecorder) Record(ctx context.Context, event *models.AuditEvent) {
+	if m.events != nil {
+		*m.events = append(*m.events, event)
+	}
+}
+
+type mockTokenAdapter struct {
+	family  *models.TokenFamily
+	revoked *[]string
+	// revokedUsers gets user id and kept session id of each RevokeUserFamilies
+	revokedUsers *[]string
+	err          error
+}
+
+func (m mockTokenAdapter) CreateFamily(ctx context.Context, family *models.TokenFamily, token *models.RefreshToken) error {
+	return m.err
+}
+func (m mockTokenAdapter) RotateRefreshToken(ctx context.Context, hash string, next *models.RefreshToken) error {
+	next.FamilyID, next.UserID = "family_id", "user_id"
+	return m.err
+}
+func (m mockTokenAdapter) RevokeFamily(ctx context.Context, familyID string) error {
+	if m.revoked != nil {
+		*m.revoked = append(*m.revoked, familyID)
+	}
+	return m.err
+}
+func (m mockTokenAdapter) RevokeUserFamilies(ctx context.Context, userID, exceptID string) error {
+	if m.revokedUsers != nil {
+		*m.revokedUsers = append(*m.revokedUsers, userID, exceptID)
+	}
+	return m.err
+}
+func (m mockTokenAdapter) ReadFamily(ctx context.Context, familyID string) (*models.TokenFamily, error) {
+	return m.family, m.err
+}
+
+type mockSessionAdapter struct {
+	sessions []*models.Session
+	err      error
+}
+
+func (m mockSessionAdapter) Find(token string) ([]byte, bool, error) {
+	return nil, false, m.err
+}
+func (m mockSessionAdapter) Commit(token string, b []byte, expiry time.Time) error {
+	return m.err
+}
+func (m mockSessionAdapter) Delete(token string) error {
+	return m.err
+}
+func (m mockSessionAdapter) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	return nil, false, m.err
+}
+func (m mockSessionAdapter) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	return m.err
+}
+func (m mockSessionAdapter) DeleteCtx(ctx context.Context, token string) error {
+	return m.err
+}
+func (m mockSessionAdapter) CreateSession(ctx context.Context, session *models.Session) error {
+	return m.err
+}
+func (m mockSessionAdapter) ReadSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	return m.sessions, m.err
+}
+func (m mockSessionAdapter) TouchSession(ctx context.Context, token string) error {
+	return m.err
+}
+func (m mockSessionAdapter) DeleteSession(ctx context.Context, userID, id string) error {
+	return m.err
+}
+func (m mockSessionAdapter) DeleteUserSessions(ctx context.Context, userID, exceptID string) error {
+	return m.err
+}
+func (m mockSessionAdapter) DeleteExpiredSessions(ctx context.Context) (int, error) {
+	return 0, m.err
+}
+
+type mockMerchantKeyAdapter struct {
+	keys    []*models.MerchantKey
+	created *[]*models.MerchantKey
+	err     error
+}
+
+func (m mockMerchantKeyAdapter) CreateMerchantKey(ctx context.Context, key *models.MerchantKey) error {
+	if m.created != nil {
+		*m.created = append(*m.created, key)
+	}
+	return m.err
+}
+func (m mockMerchantKeyAdapter) ReadMerchantKeys(ctx context.Context) ([]*models.MerchantKey, error) {
+	return m.keys, m.err
+}
+func (m mockMerchantKeyAdapter) ReadMerchantKeyByHash(ctx context.Context, hash string) (*models.MerchantKey, error) {
+	return nil, m.err
+}
+func (m mockMerchantKeyAdapter) RevokeMerchantKey(ctx context.Context, id string) error {
+	return m.err
+}
+func (m mockMerchantKeyAdapter) TouchMerchantKey(ctx context.Context, id string) error {
+	return m.err
+}
+
+type mockMFAAdapter struct {
+	mfa        *models.MFA
+	codeHashes []string
+	created    *[]string
+	err        error
+	stepErr    error
+}
+
+func (m mockMFAAdapter) CreateMFA(ctx context.Context, mfa *models.MFA, codeHashes []string) error {
+	if m.created != nil {
+		*m.created = append(append(*m.created, mfa.Secret), codeHashes...)
+	}
+	return m.err
+}
+func (m mockMFAAdapter) ReadMFA(ctx context.Context, userID string) (*models.MFA, error) {
+	if m.mfa == nil {
+		return nil, models.ErrorNotFound
+	}
+	return m.mfa, m.err
+}
+func (m mockMFAAdapter) EnableMFA(ctx context.Context, userID string, step int64) error {
+	return m.err
+}
+func (m mockMFAAdapter) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return m.stepErr
+}
+func (m mockMFAAdapter) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	for _, h := range m.codeHashes {
+		if h == hash {
+			return m.err
+		}
+	}
+	return models.ErrorNotFound
+}
+
+type mockPasswordResetAdapter struct {
+	userID  string
+	created *[]*models.PasswordReset
+	// used is set to hashes of spent tokens
+	used *[]string
+	err  error
+}
+
+func (m mockPasswordResetAdapter) CreatePasswordReset(ctx context.Context, reset *models.PasswordReset) error {
+	if m.created != nil {
+		*m.created = append(*m.created, reset)
+	}
+	return m.err
+}
+func (m mockPasswordResetAdapter) ReadPasswordReset(ctx context.Context, hash string) (string, error) {
+	if m.userID == "" {
+		return "", models.ErrorNotFound
+	}
+	return m.userID, nil
+}
+func (m mockPasswordResetAdapter) ResetPassword(ctx context.Context, hash, userID, password string) error {
+	if m.err == nil && m.used != nil {
+		*m.used = append(*m.used, hash)
+	}
+	return m.err
+}
+
+type mockNotifier struct {
+	tokens *[]string
+	err    error
+}
+
+func (m mockNotifier) PasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error {
+	if m.tokens != nil {
+		*m.tokens = append(*m.tokens, token)
+	}
+	return m.err
+}
+
+type mockIdentityAdapter struct {
+	identity *models.Identity
+	// taken logins make CreateUserWithIdentity fail as existing users
+	taken   []string
+	created *[]*models.User
+	err     error
+}
+
+func (m mockIdentityAdapter) ReadIdentity(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	if m.identity == nil {
+		return nil, models.ErrorNotFound
+	}
+	return m.identity, m.err
+}
+func (m mockIdentityAdapter) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.Identity) error {
+	for _, login := range m.taken {
+		if login == user.Login {
+			return models.ErrorUserAlreadyExists
+		}
+	}
+	if m.created != nil {
+		*m.created = append(*m.created, user)
+	}
+	return m.err
+}
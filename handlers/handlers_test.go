@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"github.com/gynshu-one/gophermart-loyalty-system/external"
+	"github.com/gynshu-one/gophermart-loyalty-system/guard"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
 	"github.com/gynshu-one/gophermart-loyalty-system/models"
 	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
 	"net/http"
@@ -49,6 +52,39 @@ func Test_handler_RegisterHandler(t *testing.T) {
 			},
 			wantStatusCode: http.StatusBadRequest,
 		},
+		{
+			name: "Login of OIDC user",
+			fields: fields{
+				user: mockUserAdapter{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/register", strings.NewReader(`{"Login": "google:alice", "Password": "Long-password-1"}`)),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Short password",
+			fields: fields{
+				user: mockUserAdapter{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/register", strings.NewReader(`{"Login": "test", "Password": "test"}`)),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Password same as login",
+			fields: fields{
+				user: mockUserAdapter{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/register", strings.NewReader(`{"Login": "LongLogin", "Password": "longlogin"}`)),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
 		{
 			name: "User already exists",
 			fields: fields{
@@ -58,15 +94,35 @@ func Test_handler_RegisterHandler(t *testing.T) {
 			},
 			args: args{
 				w: httptest.NewRecorder(),
-				r: httptest.NewRequest("POST", "/register", strings.NewReader(`{"Login": "test", "Password": "test"}`)),
+				r: httptest.NewRequest("POST", "/register", strings.NewReader(`{"Login": "test", "Password": "correct horse"}`)),
 			},
 			wantStatusCode: http.StatusConflict,
 		},
+		{
+			name: "Unknown referral code",
+			fields: fields{
+				user: mockUserAdapter{
+					err: errors.New("no rows in result set"),
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/register", strings.NewReader(`{"Login": "test", "Password": "correct horse", "referral_code": "CODE"}`)),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	passwords, err := guard.NewPasswordPolicy(8, "")
+	if err != nil {
+		t.Fatal(err)
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := &handler{
-				user: tt.fields.user,
+				audit:     mockRecorder{},
+				tokens:    mockTokenAdapter{},
+				user:      tt.fields.user,
+				passwords: passwords,
 			}
 			h.RegisterHandler(tt.args.w, tt.args.r)
 			if tt.args.w.Code != tt.wantStatusCode {
@@ -77,6 +133,8 @@ func Test_handler_RegisterHandler(t *testing.T) {
 }
 
 func Test_handler_LoginHandler(t *testing.T) {
+	hash, _ := helpers.HashPassword("test")
+	lockedAt := time.Now()
 	type fields struct {
 		user pgadapter.UserAdapter
 	}
@@ -89,6 +147,7 @@ func Test_handler_LoginHandler(t *testing.T) {
 		fields         fields
 		args           args
 		wantStatusCode int
+		wantAudit      string
 	}{
 		{
 			name: "Invalid request body",
@@ -124,17 +183,157 @@ func Test_handler_LoginHandler(t *testing.T) {
 				r: httptest.NewRequest("POST", "/login", strings.NewReader(`{"Login": "test", "Password": "test"}`)),
 			},
 			wantStatusCode: http.StatusUnauthorized,
+			wantAudit:      models.AuditLoginFailed,
+		},
+		{
+			name: "Locked account",
+			fields: fields{
+				user: mockUserAdapter{
+					user: &models.User{ID: "user_id", Login: "test", Password: hash, LockedAt: &lockedAt},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/login", strings.NewReader(`{"Login": "test", "Password": "test"}`)),
+			},
+			wantStatusCode: http.StatusLocked,
+			wantAudit:      models.AuditLoginFailed,
+		},
+		{
+			name: "Logged in",
+			fields: fields{
+				user: mockUserAdapter{
+					user: &models.User{ID: "user_id", Login: "test", Password: hash},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/login", strings.NewReader(`{"Login": "test", "Password": "test"}`)),
+			},
+			wantStatusCode: http.StatusOK,
+			wantAudit:      models.AuditLogin,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
 			h := &handler{
-				user: tt.fields.user,
+				audit:  mockRecorder{events: &events},
+				tokens: mockTokenAdapter{},
+				user:   tt.fields.user,
+				mfa:    mockMFAAdapter{},
+				logins: guard.NewLoginGuard(guard.NewMemoryAttempts(), guard.Policy{MaxAttempts: 5, Window: time.Minute}),
 			}
 			h.LoginHandler(tt.args.w, tt.args.r)
 			if tt.args.w.Code != tt.wantStatusCode {
 				t.Errorf("handler.LoginHandler() error = %v, wantErr %v", tt.args.w.Code, tt.wantStatusCode)
 			}
+			if tt.wantStatusCode == http.StatusOK {
+				var tokens models.ResponseTokens
+				if err := json.Unmarshal(tt.args.w.Body.Bytes(), &tokens); err != nil || tokens.AccessToken == "" {
+					t.Errorf("handler.LoginHandler() body = %v, want tokens", tt.args.w.Body.String())
+				}
+				if got := tt.args.w.Header().Get("Authorization"); got != "Bearer "+tokens.AccessToken {
+					t.Errorf("handler.LoginHandler() Authorization = %v, want bearer token", got)
+				}
+			}
+			if tt.wantAudit == "" {
+				if len(events) != 0 {
+					t.Errorf("handler.LoginHandler() audited %v, want nothing", events[0].Action)
+				}
+				return
+			}
+			if len(events) != 1 || events[0].Action != tt.wantAudit || events[0].IP == "" {
+				t.Errorf("handler.LoginHandler() audited %v, want %v with ip", events, tt.wantAudit)
+			}
+		})
+	}
+}
+
+func Test_handler_LoginHandler_Throttling(t *testing.T) {
+	hash, _ := helpers.HashPassword("test")
+	user := mockUserAdapter{user: &models.User{ID: "user_id", Login: "test", Password: hash}}
+	type attempt struct {
+		login          string
+		password       string
+		wantStatusCode int
+	}
+	tests := []struct {
+		name        string
+		policy      guard.Policy
+		attempts    []attempt
+		wantLockout int
+	}{
+		{
+			name:   "Account locked out even for right password",
+			policy: guard.Policy{MaxAttempts: 2, Window: time.Minute, Lockout: time.Minute},
+			attempts: []attempt{
+				{login: "test", password: "wrong", wantStatusCode: http.StatusUnauthorized},
+				{login: "test", password: "wrong", wantStatusCode: http.StatusUnauthorized},
+				{login: "test", password: "test", wantStatusCode: http.StatusLocked},
+			},
+			wantLockout: 1,
+		},
+		{
+			name:   "Success forgets failures",
+			policy: guard.Policy{MaxAttempts: 2, Window: time.Minute, Lockout: time.Minute},
+			attempts: []attempt{
+				{login: "test", password: "wrong", wantStatusCode: http.StatusUnauthorized},
+				{login: "test", password: "test", wantStatusCode: http.StatusOK},
+				{login: "test", password: "wrong", wantStatusCode: http.StatusUnauthorized},
+				{login: "test", password: "test", wantStatusCode: http.StatusOK},
+			},
+		},
+		{
+			name:   "Retry too soon",
+			policy: guard.Policy{MaxAttempts: 5, Window: time.Minute, Lockout: time.Minute, Delay: time.Minute},
+			attempts: []attempt{
+				{login: "test", password: "wrong", wantStatusCode: http.StatusUnauthorized},
+				{login: "test", password: "test", wantStatusCode: http.StatusTooManyRequests},
+			},
+		},
+		{
+			name:   "IP locked out",
+			policy: guard.Policy{IPMaxAttempts: 2, Window: time.Minute, Lockout: time.Minute},
+			attempts: []attempt{
+				{login: "first", password: "wrong", wantStatusCode: http.StatusUnauthorized},
+				{login: "second", password: "wrong", wantStatusCode: http.StatusUnauthorized},
+				{login: "test", password: "test", wantStatusCode: http.StatusTooManyRequests},
+			},
+			wantLockout: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &handler{
+				audit:  mockRecorder{events: &events},
+				tokens: mockTokenAdapter{},
+				user:   user,
+				mfa:    mockMFAAdapter{},
+				logins: guard.NewLoginGuard(guard.NewMemoryAttempts(), tt.policy),
+			}
+			for i, a := range tt.attempts {
+				w := httptest.NewRecorder()
+				body := `{"login":"` + a.login + `","password":"` + a.password + `"}`
+				h.LoginHandler(w, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+				if w.Code != a.wantStatusCode {
+					t.Fatalf("handler.LoginHandler() attempt %d error = %v, wantErr %v", i, w.Code, a.wantStatusCode)
+				}
+				throttled := w.Code == http.StatusLocked || w.Code == http.StatusTooManyRequests
+				if throttled && w.Header().Get("Retry-After") == "" {
+					t.Errorf("handler.LoginHandler() attempt %d has no Retry-After", i)
+				}
+			}
+			lockouts := 0
+			for _, event := range events {
+				if event.Action == models.AuditLoginLockout {
+					lockouts++
+				}
+			}
+			if lockouts != tt.wantLockout {
+				t.Errorf("handler.LoginHandler() audited %d lockouts, want %d", lockouts, tt.wantLockout)
+			}
 		})
 	}
 }
@@ -224,6 +423,7 @@ func Test_handler_AddOrderHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := &handler{
+				audit:          mockRecorder{},
 				order:          tt.fields.order,
 				accrualAdapter: tt.fields.accrualAdapter,
 			}
@@ -299,6 +499,7 @@ func Test_handler_GetOrderHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := &handler{
+				audit: mockRecorder{},
 				order: tt.fields.order,
 			}
 			h.GetOrderHandler(tt.args.w, tt.args.r)
@@ -311,7 +512,8 @@ func Test_handler_GetOrderHandler(t *testing.T) {
 
 func Test_handler_GetBalanceHandler(t *testing.T) {
 	type fields struct {
-		balance pgadapter.BalanceAdapter
+		balance   pgadapter.BalanceAdapter
+		household pgadapter.HouseholdAdapter
 	}
 	type args struct {
 		w *httptest.ResponseRecorder
@@ -322,6 +524,9 @@ func Test_handler_GetBalanceHandler(t *testing.T) {
 		fields         fields
 		args           args
 		expectedStatus int
+		// expectedExpiring is the number of expiring entries in response of status OK
+		expectedExpiring     int
+		expectedExpiringSoon float64
 	}{
 		{
 			name: "Test success case",
@@ -329,6 +534,44 @@ func Test_handler_GetBalanceHandler(t *testing.T) {
 				balance: mockBalanceAdapter{
 					balance: &models.Balance{Amount: 1000.0, Withdrawn: 500.0},
 				},
+				household: mockHouseholdAdapter{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/get_order", nil).WithContext(context.WithValue(context.Background(), models.UserID, "user1")),
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Test expiring points case",
+			fields: fields{
+				balance: mockBalanceAdapter{
+					balance: &models.Balance{Amount: 1000.0, Withdrawn: 500.0},
+					expiring: []*models.ExpiringPoints{
+						{Amount: 100, ExpiresAt: time.Now().Add(24 * time.Hour)},
+						{Amount: 50, ExpiresAt: time.Now().Add(48 * time.Hour)},
+					},
+				},
+				household: mockHouseholdAdapter{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/get_order", nil).WithContext(context.WithValue(context.Background(), models.UserID, "user1")),
+			},
+			expectedStatus:       http.StatusOK,
+			expectedExpiring:     2,
+			expectedExpiringSoon: 150,
+		},
+		{
+			name: "Test household member case",
+			fields: fields{
+				balance: mockBalanceAdapter{
+					balance: &models.Balance{Amount: 1000.0, Withdrawn: 500.0},
+				},
+				household: mockHouseholdAdapter{
+					member:    &models.HouseholdMember{HouseholdID: "household_id", Role: models.HouseholdRoleMember},
+					household: &models.Household{ID: "household_id", Name: "Family", Amount: 300},
+				},
 			},
 			args: args{
 				w: httptest.NewRecorder(),
@@ -367,7 +610,9 @@ func Test_handler_GetBalanceHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := &handler{
-				balance: tt.fields.balance,
+				audit:     mockRecorder{},
+				balance:   tt.fields.balance,
+				household: tt.fields.household,
 			}
 			h.GetBalanceHandler(tt.args.w, tt.args.r)
 			res := tt.args.w.Result()
@@ -375,6 +620,17 @@ func Test_handler_GetBalanceHandler(t *testing.T) {
 			if res.StatusCode != tt.expectedStatus {
 				t.Errorf("handler.GetBalanceHandler() expected status = %v, got = %v", tt.expectedStatus, res.StatusCode)
 			}
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+			var body models.ResponseBalance
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Expiring) != tt.expectedExpiring || body.ExpiringSoon != tt.expectedExpiringSoon {
+				t.Errorf("handler.GetBalanceHandler() expiring = %d entries, %v soon, want %d entries, %v soon",
+					len(body.Expiring), body.ExpiringSoon, tt.expectedExpiring, tt.expectedExpiringSoon)
+			}
 		})
 	}
 }
@@ -446,14 +702,11 @@ func Test_handler_WithdrawBalanceHandler(t *testing.T) {
 				order: mockOrderAdapter{
 					order: nil,
 				},
-				balance: mockBalanceAdapter{
-					err: models.ErrorInsufficientFunds,
-				},
 				accrual: mockAccrualAdapter{
 					err: nil,
 				},
 				withdrawal: mockWithdrawalAdapter{
-					err: nil,
+					err: models.ErrorInsufficientFunds,
 				},
 			},
 			args: args{
@@ -472,10 +725,6 @@ func Test_handler_WithdrawBalanceHandler(t *testing.T) {
 				order: mockOrderAdapter{
 					order: nil,
 				},
-				balance: mockBalanceAdapter{
-					balance: nil,
-					err:     models.ErrorInsufficientFunds,
-				},
 				accrual: mockAccrualAdapter{
 					err: nil,
 				},
@@ -491,12 +740,41 @@ func Test_handler_WithdrawBalanceHandler(t *testing.T) {
 																								} `)).
 					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
 			},
-			wantStatusCode: http.StatusPaymentRequired,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "Order registered concurrently",
+			fields: fields{
+				order:      mockOrderAdapter{},
+				accrual:    mockAccrualAdapter{},
+				withdrawal: mockWithdrawalAdapter{err: models.ErrorOrderRegistered},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/withdraw", strings.NewReader(`{"order": "2377225624", "sum": 751}`)).
+					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
+			},
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Withdrawal not recorded",
+			fields: fields{
+				order:      mockOrderAdapter{},
+				accrual:    mockAccrualAdapter{},
+				withdrawal: mockWithdrawalAdapter{err: errors.New("error")},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "/withdraw", strings.NewReader(`{"order": "2377225624", "sum": 751}`)).
+					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
+			},
+			wantStatusCode: http.StatusInternalServerError,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := &handler{
+				audit:          mockRecorder{},
 				balance:        tt.fields.balance,
 				order:          tt.fields.order,
 				withdrawal:     tt.fields.withdrawal,
@@ -576,6 +854,7 @@ func TestGetWithdrawalsHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := &handler{
+				audit:      mockRecorder{},
 				withdrawal: tt.fields.withdrawal,
 			}
 			h.GetWithdrawalsHandler(tt.args.w, tt.args.r)
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/external"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+type HouseholdHandler interface {
+	CreateHouseholdHandler(w http.ResponseWriter, r *http.Request)
+	GetHouseholdHandler(w http.ResponseWriter, r *http.Request)
+	InviteHandler(w http.ResponseWriter, r *http.Request)
+	GetInvitationsHandler(w http.ResponseWriter, r *http.Request)
+	AcceptInvitationHandler(w http.ResponseWriter, r *http.Request)
+	DeclineInvitationHandler(w http.ResponseWriter, r *http.Request)
+	CancelInvitationHandler(w http.ResponseWriter, r *http.Request)
+	RemoveMemberHandler(w http.ResponseWriter, r *http.Request)
+	TransferOwnershipHandler(w http.ResponseWriter, r *http.Request)
+	SetPoolingHandler(w http.ResponseWriter, r *http.Request)
+	WithdrawHouseholdHandler(w http.ResponseWriter, r *http.Request)
+}
+type householdHandler struct {
+	user           pgadapter.UserAdapter
+	household      pgadapter.HouseholdAdapter
+	order          pgadapter.OrderAdapter
+	withdrawal     pgadapter.WithdrawalAdapter
+	audit          audit.Recorder
+	accrualAdapter external.AccrualAdapter
+}
+
+func NewHouseholdHandler(
+	user pgadapter.UserAdapter,
+	household pgadapter.HouseholdAdapter,
+	order pgadapter.OrderAdapter,
+	withdrawal pgadapter.WithdrawalAdapter,
+	auditor audit.Recorder,
+	orderService external.AccrualAdapter,
+) HouseholdHandler {
+	return &householdHandler{
+		user:           user,
+		household:      household,
+		order:          order,
+		withdrawal:     withdrawal,
+		audit:          auditor,
+		accrualAdapter: orderService,
+	}
+}
+
+func (h *householdHandler) CreateHouseholdHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	var bodyJSON struct {
+		Name string `json:"name"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Name == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	household := &models.Household{
+		ID:        helpers.GenerateUUID(),
+		Name:      bodyJSON.Name,
+		OwnerID:   userID,
+		CreatedAt: time.Now(),
+	}
+	if err = h.household.CreateHousehold(r.Context(), household); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, household)
+}
+
+func (h *householdHandler) GetHouseholdHandler(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	household, err := h.household.ReadHousehold(r.Context(), member.HouseholdID)
+	if err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	members, err := h.household.ReadMembers(r.Context(), member.HouseholdID)
+	if err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, models.ResponseHousehold{
+		Household: household,
+		Role:      member.Role,
+		Members:   members,
+	})
+}
+
+// InviteHandler lets household owner invite user by login
+func (h *householdHandler) InviteHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+	if member.Role != models.HouseholdRoleOwner {
+		log.Debug().Msg("Only owner can invite")
+		http.Error(w, "Only owner can invite", http.StatusForbidden)
+		return
+	}
+
+	var bodyJSON struct {
+		Login string `json:"login"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Login == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	invitee, err := h.user.ReadUser(r.Context(), bodyJSON.Login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeHouseholdError(w, err)
+		return
+	}
+	if invitee.ID == member.UserID {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	invitation := &models.HouseholdInvitation{
+		ID:          helpers.GenerateUUID(),
+		HouseholdID: member.HouseholdID,
+		UserID:      invitee.ID,
+		InvitedBy:   member.UserID,
+		Status:      models.InvitationStatusPending,
+		CreatedAt:   time.Now(),
+	}
+	if err = h.household.CreateInvitation(r.Context(), invitation); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, invitation)
+}
+
+// GetInvitationsHandler shows pending invitations of current user
+func (h *householdHandler) GetInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	invitations, err := h.household.ReadInvitations(r.Context(), userID)
+	if err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	if invitations == nil {
+		invitations = []*models.HouseholdInvitation{}
+	}
+	writeJSON(w, http.StatusOK, invitations)
+}
+
+func (h *householdHandler) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	if err := h.household.AcceptInvitation(r.Context(), chi.URLParam(r, "id"), userID); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeclineInvitationHandler lets invited user turn down invitation
+func (h *householdHandler) DeclineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	if err := h.household.DeclineInvitation(r.Context(), chi.URLParam(r, "id"), userID); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// CancelInvitationHandler lets household owner withdraw invitation which is not accepted yet
+func (h *householdHandler) CancelInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+	if member.Role != models.HouseholdRoleOwner {
+		log.Debug().Msg("Only owner can cancel invitations")
+		http.Error(w, "Only owner can cancel invitations", http.StatusForbidden)
+		return
+	}
+
+	if err := h.household.CancelInvitation(r.Context(), chi.URLParam(r, "id"), member.HouseholdID); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMemberHandler lets owner remove any member and member leave household.
+// Owner leaving hands household over to the longest standing member or dissolves household if nobody is left
+func (h *householdHandler) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	removed, err := h.user.ReadUser(r.Context(), chi.URLParam(r, "login"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeHouseholdError(w, err)
+		return
+	}
+	if member.Role != models.HouseholdRoleOwner && removed.ID != member.UserID {
+		log.Debug().Msg("Only owner can remove members")
+		http.Error(w, "Only owner can remove members", http.StatusForbidden)
+		return
+	}
+
+	if removed.ID == member.UserID {
+		err = h.household.LeaveHousehold(r.Context(), member.HouseholdID, member.UserID)
+	} else {
+		err = h.household.RemoveMember(r.Context(), member.HouseholdID, removed.ID)
+	}
+	if err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TransferOwnershipHandler lets owner hand household over to another member
+func (h *householdHandler) TransferOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+	if member.Role != models.HouseholdRoleOwner {
+		log.Debug().Msg("Only owner can transfer household")
+		http.Error(w, "Only owner can transfer household", http.StatusForbidden)
+		return
+	}
+
+	var bodyJSON struct {
+		Login string `json:"login"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Login == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	owner, err := h.user.ReadUser(r.Context(), bodyJSON.Login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeHouseholdError(w, err)
+		return
+	}
+
+	if err = h.household.TransferOwnership(r.Context(), member.HouseholdID, owner.ID); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// SetPoolingHandler switches whether member's accruals go to household pool
+func (h *householdHandler) SetPoolingHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	var bodyJSON struct {
+		Pooling *bool `json:"pooling"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Pooling == nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err = h.household.SetPooling(r.Context(), userID, *bodyJSON.Pooling); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// WithdrawHouseholdHandler spends household points in payment of a new order.
+// It follows the same rules as personal withdrawal, the order is registered and recorded as withdrawal of member
+func (h *householdHandler) WithdrawHouseholdHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	var bodyJSON struct {
+		Order string  `json:"order"`
+		Sum   float64 `json:"sum"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Sum <= 0 {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !helpers.LunaOrderCheck(bodyJSON.Order) {
+		log.Debug().Msgf("Wrong order id %s", bodyJSON.Order)
+		http.Error(w, "Wrong order id", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Check if order is already registered
+	orders, err := h.order.ReadOrder(r.Context(), models.ID.EqualTo(bodyJSON.Order))
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(orders) > 0 {
+		log.Debug().Msgf("Order already registered %s", bodyJSON.Order)
+		http.Error(w, "Wrong order id", http.StatusUnprocessableEntity)
+		return
+	}
+
+	entry := &models.HouseholdEntry{
+		HouseholdID: member.HouseholdID,
+		UserID:      member.UserID,
+		OrderID:     bodyJSON.Order,
+		Amount:      bodyJSON.Sum,
+		CreatedAt:   time.Now(),
+	}
+	if err = h.household.WithdrawPool(r.Context(), entry); err != nil {
+		writeHouseholdError(w, err)
+		return
+	}
+
+	// Fallow order continuously
+	err = h.accrualAdapter.FallowOrder(&models.Order{
+		ID:     entry.OrderID,
+		UserID: member.UserID,
+	})
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	withdrawal := &models.Withdrawal{
+		ID:          helpers.GenerateUUID(),
+		UserID:      member.UserID,
+		OrderID:     entry.OrderID,
+		Sum:         entry.Amount,
+		ProcessedAt: entry.CreatedAt,
+		HouseholdID: entry.HouseholdID,
+	}
+	if err = h.withdrawal.CreateWithdrawal(r.Context(), withdrawal); err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditHouseholdWithdraw, member.UserID, map[string]any{
+		"household_id":  entry.HouseholdID,
+		"withdrawal_id": withdrawal.ID,
+		"order":         entry.OrderID,
+		"sum":           entry.Amount,
+	}))
+	w.WriteHeader(http.StatusOK)
+}
+
+// membership finds household of current user and writes 404 if there is none
+func (h *householdHandler) membership(w http.ResponseWriter, r *http.Request) (*models.HouseholdMember, bool) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	member, err := h.household.ReadMembership(r.Context(), userID)
+	if err != nil {
+		writeHouseholdError(w, err)
+		return nil, false
+	}
+	if member == nil {
+		log.Debug().Msgf("User %s has no household", userID)
+		http.Error(w, "No household", http.StatusNotFound)
+		return nil, false
+	}
+	return member, true
+}
+
+func writeHouseholdError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrorNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrorAlreadyInHousehold), errors.Is(err, models.ErrorInvitationPending),
+		errors.Is(err, models.ErrorHouseholdNotEmpty):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrorInsufficientFunds):
+		http.Error(w, "Insufficient funds", http.StatusPaymentRequired)
+	case errors.Is(err, models.ErrorOrderRegistered):
+		http.Error(w, "Wrong order id", http.StatusUnprocessableEntity)
+	default:
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+	}
+}
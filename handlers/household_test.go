@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_householdHandler_CreateHouseholdHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		household      pgadapter.HouseholdAdapter
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Created",
+			household:      mockHouseholdAdapter{},
+			body:           `{"name": "Family"}`,
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name:           "Empty name",
+			household:      mockHouseholdAdapter{},
+			body:           `{"name": ""}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Already in household",
+			household:      mockHouseholdAdapter{err: models.ErrorAlreadyInHousehold},
+			body:           `{"name": "Family"}`,
+			wantStatusCode: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &householdHandler{household: tt.household, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/household", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.CreateHouseholdHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.CreateHouseholdHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_householdHandler_GetHouseholdHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		household      pgadapter.HouseholdAdapter
+		wantStatusCode int
+	}{
+		{
+			name: "Member",
+			household: mockHouseholdAdapter{
+				member:    &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleMember},
+				household: &models.Household{ID: "household_id", Name: "Family"},
+			},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "No household",
+			household:      mockHouseholdAdapter{},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &householdHandler{household: tt.household, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/household", nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.GetHouseholdHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.GetHouseholdHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_householdHandler_InviteHandler(t *testing.T) {
+	owner := &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleOwner}
+	tests := []struct {
+		name           string
+		user           pgadapter.UserAdapter
+		household      pgadapter.HouseholdAdapter
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Invited",
+			user:           mockUserAdapter{user: &models.User{ID: "invitee_id"}},
+			household:      mockHouseholdAdapter{member: owner},
+			body:           `{"login": "invitee"}`,
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name: "Member can't invite",
+			user: mockUserAdapter{user: &models.User{ID: "invitee_id"}},
+			household: mockHouseholdAdapter{
+				member: &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleMember},
+			},
+			body:           `{"login": "invitee"}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Unknown invitee",
+			user:           mockUserAdapter{err: sql.ErrNoRows},
+			household:      mockHouseholdAdapter{member: owner},
+			body:           `{"login": "nobody"}`,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Invite self",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id"}},
+			household:      mockHouseholdAdapter{member: owner},
+			body:           `{"login": "me"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Already invited",
+			user:           mockUserAdapter{user: &models.User{ID: "invitee_id"}},
+			household:      mockHouseholdAdapter{member: owner, err: models.ErrorInvitationPending},
+			body:           `{"login": "invitee"}`,
+			wantStatusCode: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &householdHandler{user: tt.user, household: tt.household, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/household/invitations", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.InviteHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.InviteHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_householdHandler_RemoveMemberHandler(t *testing.T) {
+	member := &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleMember}
+	owner := &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleOwner}
+	tests := []struct {
+		name           string
+		user           pgadapter.UserAdapter
+		member         *models.HouseholdMember
+		err            error
+		wantStatusCode int
+		wantLeft       bool
+	}{
+		{
+			name:           "Member leaves",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id"}},
+			member:         member,
+			wantStatusCode: http.StatusNoContent,
+			wantLeft:       true,
+		},
+		{
+			name:           "Member can't remove others",
+			user:           mockUserAdapter{user: &models.User{ID: "other_id"}},
+			member:         member,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Owner removes member",
+			user:           mockUserAdapter{user: &models.User{ID: "other_id"}},
+			member:         owner,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Owner leaves",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id"}},
+			member:         owner,
+			wantStatusCode: http.StatusNoContent,
+			wantLeft:       true,
+		},
+		{
+			name:           "Owner leaves household with points",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id"}},
+			member:         owner,
+			err:            models.ErrorHouseholdNotEmpty,
+			wantStatusCode: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var left string
+			household := mockHouseholdAdapter{member: tt.member, err: tt.err, left: &left}
+			h := &householdHandler{user: tt.user, household: household, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("DELETE", "/household/members/login", nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.RemoveMemberHandler(w, withURLParam(r, "login", "login"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.RemoveMemberHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if tt.wantLeft != (left == "user_id") {
+				t.Errorf("householdHandler.RemoveMemberHandler() left = %q, want leaving %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func Test_householdHandler_TransferOwnershipHandler(t *testing.T) {
+	owner := &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleOwner}
+	tests := []struct {
+		name           string
+		user           pgadapter.UserAdapter
+		household      pgadapter.HouseholdAdapter
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Transferred",
+			user:           mockUserAdapter{user: &models.User{ID: "member_id"}},
+			household:      mockHouseholdAdapter{member: owner},
+			body:           `{"login": "member"}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "Member can't transfer",
+			user: mockUserAdapter{user: &models.User{ID: "member_id"}},
+			household: mockHouseholdAdapter{
+				member: &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleMember},
+			},
+			body:           `{"login": "member"}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Empty login",
+			user:           mockUserAdapter{user: &models.User{ID: "member_id"}},
+			household:      mockHouseholdAdapter{member: owner},
+			body:           `{"login": ""}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown user",
+			user:           mockUserAdapter{err: sql.ErrNoRows},
+			household:      mockHouseholdAdapter{member: owner},
+			body:           `{"login": "nobody"}`,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &householdHandler{user: tt.user, household: tt.household, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "/household/owner", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.TransferOwnershipHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.TransferOwnershipHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_householdHandler_CancelInvitationHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		household      pgadapter.HouseholdAdapter
+		wantStatusCode int
+	}{
+		{
+			name: "Cancelled",
+			household: mockHouseholdAdapter{
+				member: &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleOwner},
+			},
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name: "Member can't cancel",
+			household: mockHouseholdAdapter{
+				member: &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleMember},
+			},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "No household",
+			household:      mockHouseholdAdapter{},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &householdHandler{household: tt.household, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("DELETE", "/household/invitations/invitation_id", nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.CancelInvitationHandler(w, withURLParam(r, "id", "invitation_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.CancelInvitationHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_householdHandler_DeclineInvitationHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		household      pgadapter.HouseholdAdapter
+		wantStatusCode int
+	}{
+		{
+			name:           "Declined",
+			household:      mockHouseholdAdapter{},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Not pending",
+			household:      mockHouseholdAdapter{err: models.ErrorNotFound},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &householdHandler{household: tt.household, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/household/invitations/invitation_id/decline", nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.DeclineInvitationHandler(w, withURLParam(r, "id", "invitation_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.DeclineInvitationHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+func Test_householdHandler_WithdrawHouseholdHandler(t *testing.T) {
+	member := &models.HouseholdMember{HouseholdID: "household_id", UserID: "user_id", Role: models.HouseholdRoleMember}
+	tests := []struct {
+		name           string
+		household      pgadapter.HouseholdAdapter
+		order          pgadapter.OrderAdapter
+		body           string
+		wantStatusCode int
+		wantRecorded   bool
+	}{
+		{
+			name:           "Withdrawn",
+			household:      mockHouseholdAdapter{member: member},
+			order:          mockOrderAdapter{},
+			body:           `{"order": "2377225624", "sum": 100}`,
+			wantStatusCode: http.StatusOK,
+			wantRecorded:   true,
+		},
+		{
+			name:           "Wrong order",
+			household:      mockHouseholdAdapter{member: member},
+			order:          mockOrderAdapter{},
+			body:           `{"order": "123", "sum": 100}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Registered order",
+			household:      mockHouseholdAdapter{member: member},
+			order:          mockOrderAdapter{order: &models.Order{ID: "2377225624", UserID: "other_id"}},
+			body:           `{"order": "2377225624", "sum": 100}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Order paid from pool",
+			household:      mockHouseholdAdapter{member: member, err: models.ErrorOrderRegistered},
+			order:          mockOrderAdapter{},
+			body:           `{"order": "2377225624", "sum": 100}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Insufficient funds",
+			household:      mockHouseholdAdapter{member: member, err: models.ErrorInsufficientFunds},
+			order:          mockOrderAdapter{},
+			body:           `{"order": "2377225624", "sum": 100}`,
+			wantStatusCode: http.StatusPaymentRequired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []*models.Withdrawal
+			h := &householdHandler{
+				household:      tt.household,
+				order:          tt.order,
+				withdrawal:     mockWithdrawalAdapter{created: &created},
+				audit:          mockRecorder{},
+				accrualAdapter: mockAccrualAdapter{},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/household/withdraw", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.WithdrawHouseholdHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("householdHandler.WithdrawHouseholdHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if !tt.wantRecorded {
+				if len(created) != 0 {
+					t.Errorf("householdHandler.WithdrawHouseholdHandler() recorded %d withdrawals, want none", len(created))
+				}
+				return
+			}
+			if len(created) != 1 || created[0].HouseholdID != member.HouseholdID || created[0].OrderID != "2377225624" {
+				t.Errorf("householdHandler.WithdrawHouseholdHandler() recorded %+v, want withdrawal of household pool", created)
+			}
+		})
+	}
+}
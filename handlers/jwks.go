@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"net/http"
+)
+
+// jwksMaxAge lets verifiers cache keys for a while, new keys must be published this long before they sign
+const jwksMaxAge = "300"
+
+type JWKSHandler interface {
+	GetJWKSHandler(w http.ResponseWriter, r *http.Request)
+}
+type jwksHandler struct {
+	keys *middlwares.KeySet
+}
+
+func NewJWKSHandler(keys *middlwares.KeySet) JWKSHandler {
+	return &jwksHandler{keys: keys}
+}
+
+// GetJWKSHandler publishes public keys access tokens are verified with, secret HS256 key is never shown
+func (h *jwksHandler) GetJWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+	writeJSON(w, http.StatusOK, h.keys.JWKS())
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_jwksHandler_GetJWKSHandler(t *testing.T) {
+	h := NewJWKSHandler(middlwares.NewSecretKeySet("secret"))
+	w := httptest.NewRecorder()
+	h.GetJWKSHandler(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("jwksHandler.GetJWKSHandler() error = %v, want %v", w.Code, http.StatusOK)
+	}
+	var set helpers.JWKSet
+	if err := json.NewDecoder(w.Body).Decode(&set); err != nil || set.Keys == nil || len(set.Keys) != 0 {
+		t.Errorf("jwksHandler.GetJWKSHandler() = %+v, %v, want no keys of secret key set", set, err)
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/external"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// merchantKeyBytes is entropy of merchant keys
+	merchantKeyBytes = 32
+	// merchantKeyTag starts every merchant key, so leaked keys are easy to recognize
+	merchantKeyTag = "gm_"
+	// merchantKeyPrefixLen is how much of the key is stored in clear to tell keys apart
+	merchantKeyPrefixLen = len(merchantKeyTag) + 6
+	// merchantActorPrefix starts audit actor of requests authorized by merchant key
+	merchantActorPrefix = "merchant:"
+)
+
+type MerchantHandler interface {
+	CreateMerchantKeyHandler(w http.ResponseWriter, r *http.Request)
+	GetMerchantKeysHandler(w http.ResponseWriter, r *http.Request)
+	RevokeMerchantKeyHandler(w http.ResponseWriter, r *http.Request)
+	SubmitOrderHandler(w http.ResponseWriter, r *http.Request)
+}
+type merchantHandler struct {
+	keys           pgadapter.MerchantKeyAdapter
+	user           pgadapter.UserAdapter
+	order          pgadapter.OrderAdapter
+	accrualAdapter external.AccrualAdapter
+	audit          audit.Recorder
+}
+
+func NewMerchantHandler(keys pgadapter.MerchantKeyAdapter,
+	user pgadapter.UserAdapter,
+	order pgadapter.OrderAdapter,
+	orderService external.AccrualAdapter,
+	auditor audit.Recorder) MerchantHandler {
+	return &merchantHandler{
+		keys:           keys,
+		user:           user,
+		order:          order,
+		accrualAdapter: orderService,
+		audit:          auditor,
+	}
+}
+
+// CreateMerchantKeyHandler issues merchant key with given name and scopes, the key is shown only in this response
+func (h *merchantHandler) CreateMerchantKeyHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request models.RequestMerchantKey
+	err := json.NewDecoder(r.Body).Decode(&request)
+	request.Name = strings.TrimSpace(request.Name)
+	if err != nil || request.Name == "" || !validScopes(request.Scopes) {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	key := merchantKeyTag + helpers.GenerateCode(merchantKeyBytes)
+	merchantKey := &models.MerchantKey{
+		ID:        helpers.GenerateUUID(),
+		Name:      request.Name,
+		Prefix:    key[:merchantKeyPrefixLen],
+		Hash:      middlwares.HashMerchantKey(key),
+		Scopes:    request.Scopes,
+		CreatedBy: actor(r),
+		CreatedAt: time.Now(),
+	}
+	if err = h.keys.CreateMerchantKey(r.Context(), merchantKey); err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditCreateMerchantKey, merchantKey.ID, map[string]any{
+		"name":   merchantKey.Name,
+		"scopes": merchantKey.Scopes,
+	}))
+	writeJSON(w, http.StatusCreated, &models.ResponseMerchantKey{MerchantKey: merchantKey, Key: key})
+}
+
+// GetMerchantKeysHandler shows all merchant keys, revoked ones too
+func (h *merchantHandler) GetMerchantKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.ReadMerchantKeys(r.Context())
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []*models.MerchantKey{}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// RevokeMerchantKeyHandler stops merchant key from working at once
+func (h *merchantHandler) RevokeMerchantKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.keys.RevokeMerchantKey(r.Context(), id); err != nil {
+		if errors.Is(err, models.ErrorNotFound) {
+			http.Error(w, "Merchant key not found", http.StatusNotFound)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditRevokeMerchantKey, id, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SubmitOrderHandler adds order on behalf of customer given by login, it is validated
+// the same way as order added by the customer, see AddOrderHandler
+func (h *merchantHandler) SubmitOrderHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request models.RequestMerchantOrder
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Login == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.user.ReadUser(r.Context(), request.Login)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.LockedAt != nil {
+		http.Error(w, "Account locked", http.StatusLocked)
+		return
+	}
+
+	if !submitOrder(w, r, h.order, h.accrualAdapter, user.ID, request.Number) {
+		return
+	}
+	keyID, _ := r.Context().Value(models.MerchantKeyID).(string)
+	event := audit.FromRequest(r, models.AuditMerchantOrder, user.ID, map[string]any{"number": request.Number})
+	event.Actor = merchantActorPrefix + keyID
+	h.audit.Record(r.Context(), event)
+}
+
+// validScopes tells if scopes are known and there is at least one
+func validScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		switch scope {
+		case models.ScopeOrdersWrite:
+		default:
+			return false
+		}
+	}
+	return len(scopes) > 0
+}
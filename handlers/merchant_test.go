@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_merchantHandler_CreateMerchantKeyHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Created",
+			body:           `{"name":"POS","scopes":["orders:write"]}`,
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name:           "No name",
+			body:           `{"name":" ","scopes":["orders:write"]}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "No scopes",
+			body:           `{"name":"POS"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown scope",
+			body:           `{"name":"POS","scopes":["orders:write","balance:write"]}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []*models.MerchantKey
+			var events []*models.AuditEvent
+			h := &merchantHandler{keys: mockMerchantKeyAdapter{created: &created}, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/merchant-keys", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.UserID, "admin_id"))
+			h.CreateMerchantKeyHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("merchantHandler.CreateMerchantKeyHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			var got models.ResponseMerchantKey
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("merchantHandler.CreateMerchantKeyHandler() body = %v", w.Body.String())
+			}
+			if len(created) != 1 || created[0].Hash != middlwares.HashMerchantKey(got.Key) || created[0].Hash == got.Key {
+				t.Errorf("merchantHandler.CreateMerchantKeyHandler() stored %v, want hash of shown key", created)
+			}
+			if !strings.HasPrefix(got.Key, got.Prefix) || created[0].CreatedBy != "admin_id" {
+				t.Errorf("merchantHandler.CreateMerchantKeyHandler() key = %v, prefix %v", got.Key, got.Prefix)
+			}
+			if strings.Contains(w.Body.String(), created[0].Hash) {
+				t.Errorf("merchantHandler.CreateMerchantKeyHandler() shows key hash")
+			}
+			if len(events) != 1 || events[0].Action != models.AuditCreateMerchantKey {
+				t.Errorf("merchantHandler.CreateMerchantKeyHandler() audited %v, want %v", events, models.AuditCreateMerchantKey)
+			}
+		})
+	}
+}
+
+func Test_merchantHandler_RevokeMerchantKeyHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatusCode int
+	}{
+		{
+			name:           "Revoked",
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Unknown or already revoked key",
+			err:            models.ErrorNotFound,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &merchantHandler{keys: mockMerchantKeyAdapter{err: tt.err}, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			h.RevokeMerchantKeyHandler(w, withURLParam(httptest.NewRequest("DELETE", "/merchant-keys/key_id", nil), "id", "key_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("merchantHandler.RevokeMerchantKeyHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code == http.StatusNoContent && (len(events) != 1 || events[0].Subject != "key_id") {
+				t.Errorf("merchantHandler.RevokeMerchantKeyHandler() audited %v, want %v", events, models.AuditRevokeMerchantKey)
+			}
+		})
+	}
+}
+
+func Test_merchantHandler_SubmitOrderHandler(t *testing.T) {
+	lockedAt := time.Now()
+	tests := []struct {
+		name           string
+		body           string
+		user           mockUserAdapter
+		order          mockOrderAdapter
+		wantStatusCode int
+		wantAudit      bool
+	}{
+		{
+			name:           "Accepted",
+			body:           `{"login":"customer","number":"12345678903"}`,
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Login: "customer"}},
+			wantStatusCode: http.StatusAccepted,
+			wantAudit:      true,
+		},
+		{
+			name:           "Wrong order number",
+			body:           `{"login":"customer","number":"12345678904"}`,
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Login: "customer"}},
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Order of another user",
+			body:           `{"login":"customer","number":"12345678903"}`,
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Login: "customer"}},
+			order:          mockOrderAdapter{order: &models.Order{ID: "12345678903", UserID: "another_user_id"}},
+			wantStatusCode: http.StatusConflict,
+		},
+		{
+			name:           "Unknown customer",
+			body:           `{"login":"nobody","number":"12345678903"}`,
+			user:           mockUserAdapter{err: sql.ErrNoRows},
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Locked customer",
+			body:           `{"login":"customer","number":"12345678903"}`,
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", LockedAt: &lockedAt}},
+			wantStatusCode: http.StatusLocked,
+		},
+		{
+			name:           "No login",
+			body:           `{"number":"12345678903"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &merchantHandler{
+				user:           tt.user,
+				order:          tt.order,
+				accrualAdapter: mockAccrualAdapter{},
+				audit:          mockRecorder{events: &events},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/orders", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.MerchantKeyID, "key_id"))
+			h.SubmitOrderHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("merchantHandler.SubmitOrderHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if !tt.wantAudit {
+				if len(events) != 0 {
+					t.Errorf("merchantHandler.SubmitOrderHandler() audited %v, want nothing", events)
+				}
+				return
+			}
+			if len(events) != 1 || events[0].Actor != "merchant:key_id" || events[0].Subject != "user_id" {
+				t.Errorf("merchantHandler.SubmitOrderHandler() audited %v, want %v by merchant", events, models.AuditMerchantOrder)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/guard"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes user gets on enrollment
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is entropy of recovery code, it makes 8 characters
+	recoveryCodeBytes = 5
+)
+
+type MFAHandler interface {
+	EnrollMFAHandler(w http.ResponseWriter, r *http.Request)
+	ConfirmMFAHandler(w http.ResponseWriter, r *http.Request)
+	VerifyMFAHandler(w http.ResponseWriter, r *http.Request)
+}
+type mfaHandler struct {
+	user     pgadapter.UserAdapter
+	mfa      pgadapter.MFAAdapter
+	tokens   pgadapter.TokenAdapter
+	sessions *middlwares.Sessions
+	logins   *guard.LoginGuard
+	audit    audit.Recorder
+}
+
+func NewMFAHandler(user pgadapter.UserAdapter,
+	mfa pgadapter.MFAAdapter,
+	tokens pgadapter.TokenAdapter,
+	sessions *middlwares.Sessions,
+	logins *guard.LoginGuard,
+	auditor audit.Recorder) MFAHandler {
+	return &mfaHandler{
+		user:     user,
+		mfa:      mfa,
+		tokens:   tokens,
+		sessions: sessions,
+		logins:   logins,
+		audit:    auditor,
+	}
+}
+
+// EnrollMFAHandler generates TOTP secret and recovery codes of current user. They are shown only here
+// and start working once confirmed by ConfirmMFAHandler, enrolling again replaces unconfirmed ones
+func (h *mfaHandler) EnrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+	user, err := h.user.ReadUserByID(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	secret := helpers.GenerateTOTPSecret()
+	encrypted, err := helpers.Encrypt(mfaKey(), secret)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := helpers.GenerateCode(recoveryCodeBytes)
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(code))
+	}
+
+	mfa := &models.MFA{UserID: user.ID, Secret: encrypted, CreatedAt: time.Now()}
+	if err = h.mfa.CreateMFA(r.Context(), mfa, hashes); err != nil {
+		if errors.Is(err, models.ErrorMFAEnabled) {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditEnrollMFA, user.ID, nil))
+
+	writeJSON(w, http.StatusOK, &models.ResponseMFAEnrollment{
+		Secret:        secret,
+		URI:           helpers.TOTPURI(config.GetConfig().MFAIssuer, user.Login, secret),
+		RecoveryCodes: codes,
+	})
+}
+
+// ConfirmMFAHandler enables second factor of current user by its first code.
+// Users enrolling in the middle of login are logged in by it
+func (h *mfaHandler) ConfirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	var bodyJSON struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&bodyJSON); err != nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	mfa, err := h.mfa.ReadMFA(r.Context(), userID)
+	if errors.Is(err, models.ErrorNotFound) {
+		http.Error(w, "Not enrolled", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if mfa.EnabledAt != nil {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	step, ok, err := checkTOTP(mfa, bodyJSON.Code)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Wrong code", http.StatusUnprocessableEntity)
+		return
+	}
+	if err = h.mfa.EnableMFA(r.Context(), userID, step); err != nil {
+		if errors.Is(err, models.ErrorNotFound) {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditEnableMFA, userID, nil))
+
+	if enrolling, _ := r.Context().Value(models.MFAEnrollment).(bool); !enrolling {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	user, err := h.user.ReadUserByID(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.completeLogin(w, r, user, "totp")
+}
+
+// VerifyMFAHandler completes login waiting for second factor, the code is either TOTP or recovery code.
+// Wrong codes count as failed logins, see guard.LoginGuard
+func (h *mfaHandler) VerifyMFAHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var bodyJSON struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&bodyJSON); err != nil || bodyJSON.Code == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	userID, ok := middlwares.ParseMFAToken(bodyJSON.MFAToken, middlwares.MFAPurposeVerify)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.user.ReadUserByID(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.LockedAt != nil {
+		http.Error(w, "Account locked", http.StatusLocked)
+		return
+	}
+	if retryAfter, err := h.logins.Check(r.Context(), user.Login, helpers.ClientIP(r)); err != nil {
+		writeThrottled(w, retryAfter, err)
+		return
+	}
+
+	method, err := h.useCode(r.Context(), user.ID, bodyJSON.Code)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if method == "" {
+		h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditMFAFailed, user.ID, map[string]any{
+			"login": user.Login,
+		}))
+		countFailedLogin(r, h.logins, h.audit, user.Login, user.ID)
+		http.Error(w, "Wrong code", http.StatusUnauthorized)
+		return
+	}
+	h.completeLogin(w, r, user, method)
+}
+
+// useCode spends TOTP or recovery code of user and tells which one it was, wrong or used code gives empty method
+func (h *mfaHandler) useCode(ctx context.Context, userID, code string) (string, error) {
+	mfa, err := h.mfa.ReadMFA(ctx, userID)
+	if errors.Is(err, models.ErrorNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if mfa.EnabledAt == nil {
+		return "", nil
+	}
+
+	step, ok, err := checkTOTP(mfa, code)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		err = h.mfa.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, models.ErrorNotFound) {
+			return "", nil
+		}
+		return "totp", err
+	}
+
+	recovery := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	err = h.mfa.UseRecoveryCode(ctx, userID, hashToken(recovery))
+	if errors.Is(err, models.ErrorNotFound) {
+		return "", nil
+	}
+	return "recovery_code", err
+}
+
+// completeLogin logs in user who has given second factor by method
+func (h *mfaHandler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, method string) {
+	if err := h.logins.Succeed(r.Context(), user.Login); err != nil {
+		log.Error().Err(err).Msgf("Failed to forget failed logins to %s", user.Login)
+	}
+	tokens, err := logIn(w, r, h.tokens, h.sessions, user)
+	if err != nil {
+		log.Debug().Msgf("Internal server error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	event := audit.FromRequest(r, models.AuditLogin, user.ID, map[string]any{"login": user.Login, "mfa": method})
+	event.Actor = user.ID
+	h.audit.Record(r.Context(), event)
+	writeLoggedIn(w, tokens, "Logged in!")
+}
+
+// mfaPurpose tells what login of user needs after the password: verification of enabled second factor,
+// enrollment of operators who must have one but don't, or nothing
+func mfaPurpose(ctx context.Context, mfas pgadapter.MFAAdapter, user *models.User) (string, error) {
+	mfa, err := mfas.ReadMFA(ctx, user.ID)
+	if err != nil && !errors.Is(err, models.ErrorNotFound) {
+		return "", err
+	}
+	if err == nil && mfa.EnabledAt != nil {
+		return middlwares.MFAPurposeVerify, nil
+	}
+	if user.Role == models.RoleAdmin || user.Role == models.RoleSupport {
+		return middlwares.MFAPurposeEnroll, nil
+	}
+	return "", nil
+}
+
+// writeMFARequired tells client that login waits for second factor given with returned token
+func writeMFARequired(w http.ResponseWriter, user *models.User, purpose string) {
+	token, err := middlwares.GenerateMFAToken(user.ID, purpose)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, &models.ResponseMFARequired{
+		MFAToken:           token,
+		EnrollmentRequired: purpose == middlwares.MFAPurposeEnroll,
+		ExpiresIn:          int(config.GetConfig().MFATokenTTL.Seconds()),
+	})
+}
+
+// checkTOTP decrypts secret of second factor and checks code against it
+func checkTOTP(mfa *models.MFA, code string) (int64, bool, error) {
+	secret, err := helpers.Decrypt(mfaKey(), mfa.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+	step, ok := helpers.CheckTOTP(secret, code, time.Now())
+	return step, ok, nil
+}
+
+// mfaKey encrypts TOTP secrets
+func mfaKey() string {
+	return config.GetConfig().MFAKey
+}
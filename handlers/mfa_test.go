@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/guard"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestMFA returns enabled second factor with its plain secret
+func newTestMFA(t *testing.T) (*models.MFA, string) {
+	secret := helpers.GenerateTOTPSecret()
+	encrypted, err := helpers.Encrypt(mfaKey(), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabledAt := time.Now()
+	return &models.MFA{UserID: "user_id", Secret: encrypted, EnabledAt: &enabledAt}, secret
+}
+
+func Test_handler_LoginHandler_MFA(t *testing.T) {
+	hash, _ := helpers.HashPassword("test")
+	enabled, _ := newTestMFA(t)
+	tests := []struct {
+		name           string
+		role           string
+		mfa            *models.MFA
+		wantStatusCode int
+		wantPurpose    string
+	}{
+		{
+			name:           "Second factor enabled",
+			role:           models.RoleCustomer,
+			mfa:            enabled,
+			wantStatusCode: http.StatusAccepted,
+			wantPurpose:    middlwares.MFAPurposeVerify,
+		},
+		{
+			name:           "Admin must enroll",
+			role:           models.RoleAdmin,
+			wantStatusCode: http.StatusAccepted,
+			wantPurpose:    middlwares.MFAPurposeEnroll,
+		},
+		{
+			name:           "Unconfirmed enrollment of admin",
+			role:           models.RoleSupport,
+			mfa:            &models.MFA{UserID: "user_id"},
+			wantStatusCode: http.StatusAccepted,
+			wantPurpose:    middlwares.MFAPurposeEnroll,
+		},
+		{
+			name:           "Customer without second factor",
+			role:           models.RoleCustomer,
+			wantStatusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{
+				audit:  mockRecorder{},
+				tokens: mockTokenAdapter{},
+				user:   mockUserAdapter{user: &models.User{ID: "user_id", Login: "test", Password: hash, Role: tt.role}},
+				mfa:    mockMFAAdapter{mfa: tt.mfa},
+				logins: guard.NewLoginGuard(guard.NewMemoryAttempts(), guard.Policy{MaxAttempts: 5, Window: time.Minute}),
+			}
+			w := httptest.NewRecorder()
+			h.LoginHandler(w, httptest.NewRequest("POST", "/login", strings.NewReader(`{"login":"test","password":"test"}`)))
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("handler.LoginHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if tt.wantPurpose == "" {
+				return
+			}
+			if w.Header().Get("Authorization") != "" {
+				t.Errorf("handler.LoginHandler() issued access token before second factor")
+			}
+			var got models.ResponseMFARequired
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("handler.LoginHandler() body = %v", w.Body.String())
+			}
+			if userID, ok := middlwares.ParseMFAToken(got.MFAToken, tt.wantPurpose); !ok || userID != "user_id" {
+				t.Errorf("handler.LoginHandler() mfa token isn't for %s", tt.wantPurpose)
+			}
+			if got.EnrollmentRequired != (tt.wantPurpose == middlwares.MFAPurposeEnroll) {
+				t.Errorf("handler.LoginHandler() enrollment required = %v", got.EnrollmentRequired)
+			}
+		})
+	}
+}
+
+func Test_mfaHandler_EnrollMFAHandler(t *testing.T) {
+	var created []string
+	h := &mfaHandler{
+		user:  mockUserAdapter{},
+		mfa:   mockMFAAdapter{created: &created},
+		audit: mockRecorder{},
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/mfa/enroll", nil).
+		WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+	h.EnrollMFAHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("mfaHandler.EnrollMFAHandler() error = %v, wantErr %v", w.Code, http.StatusOK)
+	}
+	var got models.ResponseMFAEnrollment
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("mfaHandler.EnrollMFAHandler() body = %v", w.Body.String())
+	}
+	if !strings.HasPrefix(got.URI, "otpauth://totp/") || !strings.Contains(got.URI, "secret="+got.Secret) {
+		t.Errorf("mfaHandler.EnrollMFAHandler() uri = %v", got.URI)
+	}
+	if len(got.RecoveryCodes) != recoveryCodeCount || len(created) != recoveryCodeCount+1 {
+		t.Fatalf("mfaHandler.EnrollMFAHandler() codes = %v, stored %v", got.RecoveryCodes, created)
+	}
+	if stored, err := helpers.Decrypt(mfaKey(), created[0]); err != nil || stored != got.Secret || created[0] == got.Secret {
+		t.Errorf("mfaHandler.EnrollMFAHandler() stored secret %v isn't encrypted secret", created[0])
+	}
+	if created[1] != hashToken(got.RecoveryCodes[0]) {
+		t.Errorf("mfaHandler.EnrollMFAHandler() stored recovery code %v, want its hash", created[1])
+	}
+}
+
+func Test_mfaHandler_ConfirmMFAHandler(t *testing.T) {
+	pending, secret := newTestMFA(t)
+	pending.EnabledAt = nil
+	code, _ := helpers.TOTPCode(secret, helpers.TOTPStep(time.Now()))
+	tests := []struct {
+		name           string
+		code           string
+		enrolling      bool
+		wantStatusCode int
+	}{
+		{
+			name:           "Enabled",
+			code:           code,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Enabled in the middle of login",
+			code:           code,
+			enrolling:      true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Wrong code",
+			code:           "000000",
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.code == "000000" && code == "000000" {
+				t.Skip("current code happens to be 000000")
+			}
+			var events []*models.AuditEvent
+			h := &mfaHandler{
+				user:   mockUserAdapter{},
+				mfa:    mockMFAAdapter{mfa: pending},
+				tokens: mockTokenAdapter{},
+				logins: guard.NewLoginGuard(guard.NewMemoryAttempts(), guard.Policy{}),
+				audit:  mockRecorder{events: &events},
+			}
+			ctx := context.WithValue(context.Background(), models.UserID, "user_id")
+			if tt.enrolling {
+				ctx = context.WithValue(ctx, models.MFAEnrollment, true)
+			}
+			w := httptest.NewRecorder()
+			h.ConfirmMFAHandler(w, httptest.NewRequest("POST", "/mfa/confirm", strings.NewReader(`{"code":"`+tt.code+`"}`)).WithContext(ctx))
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("mfaHandler.ConfirmMFAHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code == http.StatusOK && w.Header().Get("Authorization") == "" {
+				t.Errorf("mfaHandler.ConfirmMFAHandler() didn't complete login")
+			}
+			if w.Code != http.StatusUnprocessableEntity && events[0].Action != models.AuditEnableMFA {
+				t.Errorf("mfaHandler.ConfirmMFAHandler() audited %v, want %v", events[0].Action, models.AuditEnableMFA)
+			}
+		})
+	}
+}
+
+func Test_mfaHandler_VerifyMFAHandler(t *testing.T) {
+	enabled, secret := newTestMFA(t)
+	code, _ := helpers.TOTPCode(secret, helpers.TOTPStep(time.Now()))
+	verifyToken, _ := middlwares.GenerateMFAToken("user_id", middlwares.MFAPurposeVerify)
+	enrollToken, _ := middlwares.GenerateMFAToken("user_id", middlwares.MFAPurposeEnroll)
+	accessToken, _ := middlwares.GenerateJWT("user_id", models.RoleCustomer, "family_id")
+	tests := []struct {
+		name           string
+		token          string
+		code           string
+		mfa            mockMFAAdapter
+		wantStatusCode int
+		wantAudit      string
+		wantMethod     string
+	}{
+		{
+			name:           "TOTP code",
+			token:          verifyToken,
+			code:           code,
+			mfa:            mockMFAAdapter{mfa: enabled},
+			wantStatusCode: http.StatusOK,
+			wantAudit:      models.AuditLogin,
+			wantMethod:     "totp",
+		},
+		{
+			name:           "Recovery code",
+			token:          verifyToken,
+			code:           "abcd-efgh",
+			mfa:            mockMFAAdapter{mfa: enabled, codeHashes: []string{hashToken("ABCDEFGH")}},
+			wantStatusCode: http.StatusOK,
+			wantAudit:      models.AuditLogin,
+			wantMethod:     "recovery_code",
+		},
+		{
+			name:           "Replayed code",
+			token:          verifyToken,
+			code:           code,
+			mfa:            mockMFAAdapter{mfa: enabled, stepErr: models.ErrorNotFound},
+			wantStatusCode: http.StatusUnauthorized,
+			wantAudit:      models.AuditMFAFailed,
+		},
+		{
+			name:           "Wrong code",
+			token:          verifyToken,
+			code:           "ABCDEFGH",
+			mfa:            mockMFAAdapter{mfa: enabled},
+			wantStatusCode: http.StatusUnauthorized,
+			wantAudit:      models.AuditMFAFailed,
+		},
+		{
+			name:           "Enrollment token",
+			token:          enrollToken,
+			code:           code,
+			mfa:            mockMFAAdapter{mfa: enabled},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Access token",
+			token:          accessToken,
+			code:           code,
+			mfa:            mockMFAAdapter{mfa: enabled},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &mfaHandler{
+				user:   mockUserAdapter{},
+				mfa:    tt.mfa,
+				tokens: mockTokenAdapter{},
+				logins: guard.NewLoginGuard(guard.NewMemoryAttempts(), guard.Policy{MaxAttempts: 5, Window: time.Minute}),
+				audit:  mockRecorder{events: &events},
+			}
+			body := `{"mfa_token":"` + tt.token + `","code":"` + tt.code + `"}`
+			w := httptest.NewRecorder()
+			h.VerifyMFAHandler(w, httptest.NewRequest("POST", "/mfa/verify", strings.NewReader(body)))
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("mfaHandler.VerifyMFAHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if tt.wantAudit == "" {
+				if len(events) != 0 {
+					t.Errorf("mfaHandler.VerifyMFAHandler() audited %v, want nothing", events)
+				}
+				return
+			}
+			if len(events) == 0 || events[0].Action != tt.wantAudit {
+				t.Fatalf("mfaHandler.VerifyMFAHandler() audited %v, want %v", events, tt.wantAudit)
+			}
+			if tt.wantMethod != "" && !strings.Contains(string(events[0].Payload), tt.wantMethod) {
+				t.Errorf("mfaHandler.VerifyMFAHandler() payload = %s, want method %s", events[0].Payload, tt.wantMethod)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/oidc"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// oidcCookie keeps state of login at identity provider, it is sent only to OIDC endpoints
+	oidcCookie     = "OIDC"
+	oidcCookiePath = "/api/user/oidc"
+	// oidcLoginTTL is how long user has to log in at identity provider
+	oidcLoginTTL = 10 * time.Minute
+	// oidcStateBytes is entropy of state and nonce
+	oidcStateBytes = 16
+	// oidcPasswordBytes is entropy of password users created by OIDC login get, nobody knows it
+	// until user sets own one with password reset
+	oidcPasswordBytes = 32
+)
+
+type OIDCHandler interface {
+	OIDCLoginHandler(w http.ResponseWriter, r *http.Request)
+	OIDCCallbackHandler(w http.ResponseWriter, r *http.Request)
+}
+type oidcHandler struct {
+	providers  map[string]*oidc.Provider
+	identities pgadapter.IdentityAdapter
+	user       pgadapter.UserAdapter
+	mfa        pgadapter.MFAAdapter
+	tokens     pgadapter.TokenAdapter
+	sessions   *middlwares.Sessions
+	audit      audit.Recorder
+}
+
+func NewOIDCHandler(providers []*oidc.Provider,
+	identities pgadapter.IdentityAdapter,
+	user pgadapter.UserAdapter,
+	mfa pgadapter.MFAAdapter,
+	tokens pgadapter.TokenAdapter,
+	sessions *middlwares.Sessions,
+	auditor audit.Recorder) OIDCHandler {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name] = provider
+	}
+	return &oidcHandler{
+		providers:  byName,
+		identities: identities,
+		user:       user,
+		mfa:        mfa,
+		tokens:     tokens,
+		sessions:   sessions,
+		audit:      auditor,
+	}
+}
+
+// oidcLogin is what callback needs to finish login started in the same browser
+type oidcLogin struct {
+	Provider  string    `json:"provider"`
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OIDCLoginHandler sends user to log in at identity provider, state of the login is kept in encrypted cookie
+func (h *oidcHandler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+	login := &oidcLogin{
+		Provider:  provider.Name,
+		State:     helpers.GenerateCode(oidcStateBytes),
+		Nonce:     helpers.GenerateCode(oidcStateBytes),
+		Verifier:  oidc.NewVerifier(),
+		ExpiresAt: time.Now().Add(oidcLoginTTL),
+	}
+	redirect, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		log.Error().Err(err).Msgf("Identity provider %s is unavailable", provider.Name)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	plain, err := json.Marshal(login)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	sealed, err := helpers.Encrypt(config.GetConfig().Key, string(plain))
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Strict cookie wouldn't come back with top-level redirect from identity provider, Lax does
+	cookie := middlwares.NewCookie(oidcCookie, sealed)
+	cookie.Path = oidcCookiePath
+	cookie.MaxAge = int(oidcLoginTTL.Seconds())
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// OIDCCallbackHandler finishes login at identity provider. External identity is linked to user on first login,
+// user and balance are created then. Second factor is required the same way as in password login
+func (h *oidcHandler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+	login, ok := readOIDCLogin(r, provider.Name)
+	expired := middlwares.ExpiredCookie(oidcCookie)
+	expired.Path = oidcCookiePath
+	http.SetCookie(w, expired)
+	if !ok {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		log.Debug().Msgf("Identity provider %s refused login: %s", provider.Name, query.Get("error"))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 || query.Get("code") == "" {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), login.Verifier, login.Nonce)
+	if errors.Is(err, models.ErrorInvalidIDToken) {
+		log.Debug().Msgf("OIDC login with %s failed: %v", provider.Name, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Identity provider %s is unavailable", provider.Name)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	user, err := h.linkedUser(r, provider, claims)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.LockedAt != nil {
+		h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditLoginFailed, user.ID, map[string]any{
+			"login":  user.Login,
+			"oidc":   provider.Name,
+			"reason": "locked",
+		}))
+		http.Error(w, "Account locked", http.StatusLocked)
+		return
+	}
+	if purpose, err := mfaPurpose(r.Context(), h.mfa, user); err != nil || purpose != "" {
+		if err != nil {
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeMFARequired(w, user, purpose)
+		return
+	}
+
+	tokens, err := logIn(w, r, h.tokens, h.sessions, user)
+	if err != nil {
+		log.Debug().Msgf("Internal server error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	event := audit.FromRequest(r, models.AuditLogin, user.ID, map[string]any{"login": user.Login, "oidc": provider.Name})
+	event.Actor = user.ID
+	h.audit.Record(r.Context(), event)
+	writeLoggedIn(w, tokens, "Logged in!")
+}
+
+// linkedUser returns user linked to external identity, creating one on first login.
+// Existing users are never linked by login or email, so nobody takes over account by naming their external one after it
+func (h *oidcHandler) linkedUser(r *http.Request, provider *oidc.Provider, claims *oidc.Claims) (*models.User, error) {
+	identity, err := h.identities.ReadIdentity(r.Context(), claims.Issuer, claims.Subject)
+	if err == nil {
+		return h.user.ReadUserByID(r.Context(), identity.UserID)
+	}
+	if !errors.Is(err, models.ErrorNotFound) {
+		return nil, err
+	}
+
+	identity = &models.Identity{
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		CreatedAt: time.Now(),
+	}
+	for _, login := range oidcLogins(provider.Name, claims) {
+		user := &models.User{
+			ID:       helpers.GenerateUUID(),
+			Login:    login,
+			Password: helpers.GenerateCode(oidcPasswordBytes),
+		}
+		err = h.identities.CreateUserWithIdentity(r.Context(), user, identity)
+		switch {
+		case errors.Is(err, models.ErrorUserAlreadyExists):
+			continue
+		case errors.Is(err, models.ErrorIdentityLinked):
+			// The same identity logged in concurrently and was linked first
+			if identity, err = h.identities.ReadIdentity(r.Context(), claims.Issuer, claims.Subject); err != nil {
+				return nil, err
+			}
+			return h.user.ReadUserByID(r.Context(), identity.UserID)
+		case err != nil:
+			return nil, err
+		}
+		event := audit.FromRequest(r, models.AuditLinkIdentity, user.ID, map[string]any{
+			"login":   user.Login,
+			"oidc":    provider.Name,
+			"issuer":  identity.Issuer,
+			"subject": identity.Subject,
+		})
+		event.Actor = user.ID
+		h.audit.Record(r.Context(), event)
+		return user, nil
+	}
+	return nil, models.ErrorUserAlreadyExists
+}
+
+// oidcLogins are logins user created by OIDC login may get, first free one is taken.
+// They are prefixed by provider, so they never match logins of password users
+func oidcLogins(provider string, claims *oidc.Claims) []string {
+	var logins []string
+	if claims.PreferredUsername != "" {
+		logins = append(logins, provider+models.LoginSeparator+claims.PreferredUsername)
+	}
+	if claims.Email != "" && claims.EmailVerified {
+		logins = append(logins, provider+models.LoginSeparator+claims.Email)
+	}
+	return append(logins, provider+models.LoginSeparator+claims.Subject)
+}
+
+// readOIDCLogin opens login state from cookie, it must be unexpired and for the same provider
+func readOIDCLogin(r *http.Request, provider string) (*oidcLogin, bool) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, false
+	}
+	plain, err := helpers.Decrypt(config.GetConfig().Key, cookie.Value)
+	if err != nil {
+		return nil, false
+	}
+	var login oidcLogin
+	if err = json.Unmarshal([]byte(plain), &login); err != nil {
+		return nil, false
+	}
+	if login.Provider != provider || time.Now().After(login.ExpiresAt) {
+		return nil, false
+	}
+	return &login, true
+}
+
+// OIDCRedirectURL is where identity provider sends user back, it must be registered at the provider
+func OIDCRedirectURL(baseURL, provider string) string {
+	return strings.TrimSuffix(baseURL, "/") + oidcCookiePath + "/" + provider + "/callback"
+}
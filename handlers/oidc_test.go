@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/oidc"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeClientID     = "gophermart"
+	fakeClientSecret = "secret"
+	fakeRedirectURL  = "http://localhost:8080/api/user/oidc/corp/callback"
+)
+
+// fakeIdP is identity provider serving discovery, keys and token endpoints,
+// users log in by grant which returns code for authorization request
+type fakeIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// audience and nonce replace those of issued ID tokens if set
+	audience string
+	nonce    string
+
+	mu     sync.Mutex
+	grants map[string]url.Values
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, grants: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// grant logs user in for authorization request and returns code to give to callback
+func (idp *fakeIdP) grant(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("client_id") != fakeClientID || query.Get("code_challenge_method") != "S256" ||
+		query.Get("redirect_uri") != fakeRedirectURL {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	code := "code" + query.Get("state")
+	idp.grants[code] = query
+	return code
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostFormValue("code")]
+	delete(idp.grants, r.PostFormValue("code"))
+	idp.mu.Unlock()
+
+	clientID, secret, _ := r.BasicAuth()
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || clientID != fakeClientID || secret != fakeClientSecret ||
+		r.PostFormValue("redirect_uri") != grant.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != grant.Get("code_challenge") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	audience, nonce := fakeClientID, grant.Get("nonce")
+	if idp.audience != "" {
+		audience = idp.audience
+	}
+	if idp.nonce != "" {
+		nonce = idp.nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"sub":                "248289761001",
+		"aud":                []string{audience},
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func Test_oidcHandler_Login(t *testing.T) {
+	lockedAt := time.Now()
+	tests := []struct {
+		name           string
+		user           mockUserAdapter
+		identities     mockIdentityAdapter
+		audience       string
+		nonce          string
+		state          string
+		verifier       string
+		noCookie       bool
+		wantStatusCode int
+		wantLogin      string
+	}{
+		{
+			name:           "First login creates user",
+			wantStatusCode: http.StatusOK,
+			wantLogin:      "corp:alice",
+		},
+		{
+			name:           "Taken login falls back to subject",
+			identities:     mockIdentityAdapter{taken: []string{"corp:alice"}},
+			wantStatusCode: http.StatusOK,
+			wantLogin:      "corp:248289761001",
+		},
+		{
+			name:           "Linked identity",
+			identities:     mockIdentityAdapter{identity: &models.Identity{UserID: "user_id"}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Locked user",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", LockedAt: &lockedAt}},
+			identities:     mockIdentityAdapter{identity: &models.Identity{UserID: "user_id"}},
+			wantStatusCode: http.StatusLocked,
+		},
+		{
+			name:           "State mismatch",
+			state:          "forged",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "No login cookie",
+			noCookie:       true,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Token for other client",
+			audience:       "other",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Nonce mismatch",
+			nonce:          "replayed",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t)
+			idp.audience, idp.nonce = tt.audience, tt.nonce
+			var created []*models.User
+			tt.identities.created = &created
+			h := NewOIDCHandler([]*oidc.Provider{oidc.NewProvider(oidc.ProviderConfig{
+				Name:         "corp",
+				Issuer:       idp.server.URL,
+				ClientID:     fakeClientID,
+				ClientSecret: fakeClientSecret,
+			}, fakeRedirectURL)}, tt.identities, tt.user, mockMFAAdapter{}, mockTokenAdapter{}, nil, mockRecorder{})
+
+			w := httptest.NewRecorder()
+			h.OIDCLoginHandler(w, withURLParam(httptest.NewRequest("GET", "/api/user/oidc/corp/login", nil), "provider", "corp"))
+			if w.Code != http.StatusFound {
+				t.Fatalf("oidcHandler.OIDCLoginHandler() error = %v, want %v", w.Code, http.StatusFound)
+			}
+			location := w.Header().Get("Location")
+			code := idp.grant(t, location)
+			state := tt.state
+			if state == "" {
+				u, _ := url.Parse(location)
+				state = u.Query().Get("state")
+			}
+
+			r := httptest.NewRequest("GET", "/api/user/oidc/corp/callback?"+url.Values{
+				"code":  {code},
+				"state": {state},
+			}.Encode(), nil)
+			if !tt.noCookie {
+				for _, cookie := range w.Result().Cookies() {
+					r.AddCookie(cookie)
+				}
+			}
+			w = httptest.NewRecorder()
+			h.OIDCCallbackHandler(w, withURLParam(r, "provider", "corp"))
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("oidcHandler.OIDCCallbackHandler() error = %v, want %v: %s", w.Code, tt.wantStatusCode, w.Body)
+			}
+			if w.Code == http.StatusOK {
+				var tokens models.ResponseTokens
+				if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil || tokens.AccessToken == "" {
+					t.Errorf("response tokens = %+v, %v, want access token", tokens, err)
+				}
+			}
+			if tt.wantLogin == "" {
+				if len(created) != 0 {
+					t.Errorf("created users = %v, want none", created)
+				}
+				return
+			}
+			if len(created) != 1 || created[0].Login != tt.wantLogin || created[0].Password == "" {
+				t.Errorf("created users = %v, want %s", created, tt.wantLogin)
+			}
+		})
+	}
+}
+
+func Test_oidcHandler_UnknownProvider(t *testing.T) {
+	h := NewOIDCHandler(nil, mockIdentityAdapter{}, mockUserAdapter{}, mockMFAAdapter{}, mockTokenAdapter{}, nil, mockRecorder{})
+	w := httptest.NewRecorder()
+	h.OIDCLoginHandler(w, withURLParam(httptest.NewRequest("GET", "/api/user/oidc/corp/login", nil), "provider", "corp"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("oidcHandler.OIDCLoginHandler() error = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
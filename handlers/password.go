@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/guard"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/notify"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+// resetTokenBytes is entropy of password reset tokens
+const resetTokenBytes = 32
+
+type PasswordHandler interface {
+	ChangePasswordHandler(w http.ResponseWriter, r *http.Request)
+	RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request)
+	ResetPasswordHandler(w http.ResponseWriter, r *http.Request)
+}
+type passwordHandler struct {
+	user      pgadapter.UserAdapter
+	resets    pgadapter.PasswordResetAdapter
+	tokens    pgadapter.TokenAdapter
+	sessions  *middlwares.Sessions
+	passwords *guard.PasswordPolicy
+	logins    *guard.LoginGuard
+	notifier  notify.Notifier
+	audit     audit.Recorder
+}
+
+func NewPasswordHandler(user pgadapter.UserAdapter,
+	resets pgadapter.PasswordResetAdapter,
+	tokens pgadapter.TokenAdapter,
+	sessions *middlwares.Sessions,
+	passwords *guard.PasswordPolicy,
+	logins *guard.LoginGuard,
+	notifier notify.Notifier,
+	auditor audit.Recorder) PasswordHandler {
+	return &passwordHandler{
+		user:      user,
+		resets:    resets,
+		tokens:    tokens,
+		sessions:  sessions,
+		passwords: passwords,
+		logins:    logins,
+		notifier:  notifier,
+		audit:     auditor,
+	}
+}
+
+// ChangePasswordHandler sets new password of current user who knows the current one.
+// Other sessions of user are logged out, the current one stays.
+// Wrong current passwords count as failed logins, so stolen session can't be used to guess it
+func (h *passwordHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+	sessionID, _ := r.Context().Value(models.SessionID).(string)
+	defer r.Body.Close()
+
+	var request models.RequestChangePassword
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.CurrentPassword == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	user, err := h.user.ReadUserByID(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter, err := h.logins.Check(r.Context(), user.Login, helpers.ClientIP(r)); err != nil {
+		writeThrottled(w, retryAfter, err)
+		return
+	}
+	if !helpers.CheckPasswordHash(request.CurrentPassword, user.Password) {
+		countFailedLogin(r, h.logins, h.audit, user.Login, user.ID)
+		http.Error(w, "Wrong password", http.StatusForbidden)
+		return
+	}
+	if err = h.passwords.Validate(user.Login, request.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = h.user.SetPassword(r.Context(), user.ID, request.NewPassword); err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = logOutEverywhere(r.Context(), h.tokens, h.sessions, user.ID, sessionID); err != nil {
+		log.Error().Err(err).Msgf("Failed to log out other sessions of %s", user.ID)
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditChangePassword, user.ID, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestPasswordResetHandler sends password reset token to user given by login through notifier.
+// Response is the same whether such user exists or not, so it can't be used to find logins.
+// Without notifier tokens can't reach users, so reset is unavailable
+func (h *passwordHandler) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if h.notifier == nil {
+		log.Debug().Msg("Password reset requested, but no notifier is configured")
+		http.Error(w, "Password reset is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request models.RequestPasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Login == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditRequestReset, "", map[string]any{
+		"login": request.Login,
+	}))
+
+	user, err := h.user.ReadUser(r.Context(), request.Login)
+	if err != nil || user.LockedAt != nil {
+		log.Debug().Msgf("No password reset for %s: %v", request.Login, err)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token := helpers.GenerateCode(resetTokenBytes)
+	now := time.Now()
+	reset := &models.PasswordReset{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.GetConfig().PasswordResetTTL),
+	}
+	if err = h.resets.CreatePasswordReset(r.Context(), reset); err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = h.notifier.PasswordReset(r.Context(), user, token, reset.ExpiresAt); err != nil {
+		log.Error().Err(err).Msgf("Failed to send password reset to %s", user.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPasswordHandler sets new password with token from RequestPasswordResetHandler. The token works once,
+// all sessions of user are logged out and failed logins are forgotten, second factor is still required to log in
+func (h *passwordHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request models.RequestResetPassword
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Token is spent only together with the new password, so password failing policy doesn't waste it
+	hash := hashToken(request.Token)
+	userID, err := h.resets.ReadPasswordReset(r.Context(), hash)
+	if errors.Is(err, models.ErrorNotFound) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, err := h.user.ReadUserByID(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = h.passwords.Validate(user.Login, request.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.resets.ResetPassword(r.Context(), hash, user.ID, request.NewPassword)
+	if errors.Is(err, models.ErrorNotFound) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = logOutEverywhere(r.Context(), h.tokens, h.sessions, user.ID, ""); err != nil {
+		log.Error().Err(err).Msgf("Failed to log out sessions of %s", user.ID)
+	}
+	if err = h.logins.Succeed(r.Context(), user.Login); err != nil {
+		log.Error().Err(err).Msgf("Failed to forget failed logins to %s", user.Login)
+	}
+	event := audit.FromRequest(r, models.AuditResetPassword, user.ID, nil)
+	event.Actor = user.ID
+	h.audit.Record(r.Context(), event)
+	w.WriteHeader(http.StatusNoContent)
+}
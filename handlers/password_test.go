@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/gynshu-one/gophermart-loyalty-system/guard"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestPasswordPolicy returns policy of 8 characters which rejects "password123" given in plain
+// and "letmein2024" given as SHA-1 with breach count
+func newTestPasswordPolicy(t *testing.T) *guard.PasswordPolicy {
+	sum := sha1.Sum([]byte("letmein2024"))
+	file := filepath.Join(t.TempDir(), "breached.txt")
+	content := "password123\n" + strings.ToUpper(hex.EncodeToString(sum[:])) + ":42\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	passwords, err := guard.NewPasswordPolicy(8, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return passwords
+}
+
+func Test_passwordHandler_ChangePasswordHandler(t *testing.T) {
+	hash, _ := helpers.HashPassword("current password")
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+		wantRevoked    bool
+	}{
+		{
+			name:           "Password changed",
+			body:           `{"current_password":"current password","new_password":"new password"}`,
+			wantStatusCode: http.StatusNoContent,
+			wantRevoked:    true,
+		},
+		{
+			name:           "Wrong current password",
+			body:           `{"current_password":"wrong","new_password":"new password"}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Too short",
+			body:           `{"current_password":"current password","new_password":"short"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Breached in plain",
+			body:           `{"current_password":"current password","new_password":"password123"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Breached as SHA-1",
+			body:           `{"current_password":"current password","new_password":"letmein2024"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "No current password",
+			body:           `{"new_password":"new password"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	passwords := newTestPasswordPolicy(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked []string
+			h := &passwordHandler{
+				user:      mockUserAdapter{user: &models.User{ID: "user_id", Login: "test", Password: hash}},
+				tokens:    mockTokenAdapter{revokedUsers: &revoked},
+				passwords: passwords,
+				logins:    guard.NewLoginGuard(guard.NewMemoryAttempts(), guard.Policy{MaxAttempts: 5, Window: time.Minute}),
+				audit:     mockRecorder{},
+			}
+			r := httptest.NewRequest("POST", "/api/user/password", strings.NewReader(tt.body))
+			ctx := context.WithValue(r.Context(), models.UserID, "user_id")
+			ctx = context.WithValue(ctx, models.SessionID, "family_id")
+			w := httptest.NewRecorder()
+			h.ChangePasswordHandler(w, r.WithContext(ctx))
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("passwordHandler.ChangePasswordHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if !tt.wantRevoked {
+				if len(revoked) != 0 {
+					t.Errorf("sessions revoked = %v, want none", revoked)
+				}
+				return
+			}
+			if len(revoked) != 2 || revoked[0] != "user_id" || revoked[1] != "family_id" {
+				t.Errorf("sessions revoked = %v, want all of user_id but family_id", revoked)
+			}
+		})
+	}
+}
+
+func Test_passwordHandler_ChangePasswordHandler_Throttling(t *testing.T) {
+	hash, _ := helpers.HashPassword("current password")
+	h := &passwordHandler{
+		user:      mockUserAdapter{user: &models.User{ID: "user_id", Login: "test", Password: hash}},
+		tokens:    mockTokenAdapter{},
+		passwords: newTestPasswordPolicy(t),
+		logins:    guard.NewLoginGuard(guard.NewMemoryAttempts(), guard.Policy{MaxAttempts: 2, Window: time.Minute, Lockout: time.Minute}),
+		audit:     mockRecorder{},
+	}
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("POST", "/api/user/password",
+			strings.NewReader(`{"current_password":"wrong","new_password":"new password"}`))
+		w := httptest.NewRecorder()
+		h.ChangePasswordHandler(w, r.WithContext(context.WithValue(r.Context(), models.UserID, "user_id")))
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusForbidden || codes[2] != http.StatusLocked {
+		t.Errorf("passwordHandler.ChangePasswordHandler() codes = %v, want guessing locked out", codes)
+	}
+}
+
+func Test_passwordHandler_RequestPasswordResetHandler(t *testing.T) {
+	lockedAt := time.Now()
+	tests := []struct {
+		name           string
+		user           mockUserAdapter
+		body           string
+		noNotifier     bool
+		wantStatusCode int
+		wantSent       bool
+	}{
+		{
+			name:           "Token sent",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Login: "test"}},
+			body:           `{"login":"test"}`,
+			wantStatusCode: http.StatusAccepted,
+			wantSent:       true,
+		},
+		{
+			name:           "Unknown login looks the same",
+			user:           mockUserAdapter{err: models.ErrorNotFound},
+			body:           `{"login":"nobody"}`,
+			wantStatusCode: http.StatusAccepted,
+		},
+		{
+			name:           "Locked account",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Login: "test", LockedAt: &lockedAt}},
+			body:           `{"login":"test"}`,
+			wantStatusCode: http.StatusAccepted,
+		},
+		{
+			name:           "No login",
+			user:           mockUserAdapter{},
+			body:           `{}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "No notifier",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Login: "test"}},
+			body:           `{"login":"test"}`,
+			noNotifier:     true,
+			wantStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []*models.PasswordReset
+			var sent []string
+			h := &passwordHandler{
+				user:     tt.user,
+				resets:   mockPasswordResetAdapter{created: &created},
+				notifier: mockNotifier{tokens: &sent},
+				audit:    mockRecorder{},
+			}
+			if tt.noNotifier {
+				h.notifier = nil
+			}
+			w := httptest.NewRecorder()
+			h.RequestPasswordResetHandler(w, httptest.NewRequest("POST", "/api/user/password/reset", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("passwordHandler.RequestPasswordResetHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if !tt.wantSent {
+				if len(sent) != 0 || len(created) != 0 {
+					t.Errorf("token sent = %v, want none", sent)
+				}
+				return
+			}
+			if len(sent) != 1 || len(created) != 1 {
+				t.Fatalf("tokens sent = %v, stored = %d, want one", sent, len(created))
+			}
+			if created[0].Hash != hashToken(sent[0]) || created[0].UserID != "user_id" {
+				t.Errorf("stored reset = %+v, want hash of sent token", created[0])
+			}
+		})
+	}
+}
+
+func Test_passwordHandler_ResetPasswordHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		resets         mockPasswordResetAdapter
+		body           string
+		wantStatusCode int
+		wantRevoked    bool
+	}{
+		{
+			name:           "Password reset",
+			resets:         mockPasswordResetAdapter{userID: "user_id"},
+			body:           `{"token":"token","new_password":"new password"}`,
+			wantStatusCode: http.StatusNoContent,
+			wantRevoked:    true,
+		},
+		{
+			name:           "Token spent meanwhile",
+			resets:         mockPasswordResetAdapter{userID: "user_id", err: models.ErrorNotFound},
+			body:           `{"token":"token","new_password":"new password"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid or used token",
+			resets:         mockPasswordResetAdapter{},
+			body:           `{"token":"token","new_password":"new password"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Breached password",
+			resets:         mockPasswordResetAdapter{userID: "user_id"},
+			body:           `{"token":"token","new_password":"password123"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Password same as login",
+			resets:         mockPasswordResetAdapter{userID: "user_id"},
+			body:           `{"token":"token","new_password":"LongLogin"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "No token",
+			resets:         mockPasswordResetAdapter{userID: "user_id"},
+			body:           `{"new_password":"new password"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	passwords := newTestPasswordPolicy(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked, used []string
+			tt.resets.used = &used
+			h := &passwordHandler{
+				user:      mockUserAdapter{user: &models.User{ID: "user_id", Login: "longlogin"}},
+				resets:    tt.resets,
+				tokens:    mockTokenAdapter{revokedUsers: &revoked},
+				passwords: passwords,
+				logins:    guard.NewLoginGuard(guard.NewMemoryAttempts(), guard.Policy{MaxAttempts: 5, Window: time.Minute}),
+				audit:     mockRecorder{},
+			}
+			w := httptest.NewRecorder()
+			h.ResetPasswordHandler(w, httptest.NewRequest("POST", "/api/user/password/reset/confirm", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("passwordHandler.ResetPasswordHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if tt.wantRevoked && (len(revoked) != 2 || revoked[0] != "user_id" || revoked[1] != "") {
+				t.Errorf("sessions revoked = %v, want all of user_id", revoked)
+			}
+			// Token is spent only by successful reset
+			if wantUsed := tt.wantStatusCode == http.StatusNoContent; wantUsed != (len(used) == 1) {
+				t.Errorf("tokens spent = %v, want spent %v", used, wantUsed)
+			}
+		})
+	}
+}
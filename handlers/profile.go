@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/loyalty"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+type ProfileHandler interface {
+	GetProfileHandler(w http.ResponseWriter, r *http.Request)
+}
+type profileHandler struct {
+	user  pgadapter.UserAdapter
+	tiers loyalty.Tiers
+}
+
+func NewProfileHandler(user pgadapter.UserAdapter, tiers loyalty.Tiers) ProfileHandler {
+	return &profileHandler{
+		user:  user,
+		tiers: tiers,
+	}
+}
+
+func (h *profileHandler) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	// Find user by id
+	user, err := h.user.ReadUserByID(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Tier is re-evaluated as old accruals leave the rolling window
+	tier, err := h.tiers.Evaluate(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	profile := models.ResponseProfile{
+		Login:      user.Login,
+		Tier:       tier.Tier.Name,
+		TierSince:  tier.Since,
+		Multiplier: tier.Tier.Multiplier,
+		Accrued:    tier.Accrued,
+	}
+	if tier.Next != nil {
+		profile.NextTier = tier.Next.Name
+		profile.ToNextTier = tier.Next.Threshold - tier.Accrued
+	}
+
+	// Pack
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(profileJSON)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/loyalty"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_profileHandler_GetProfileHandler(t *testing.T) {
+	type fields struct {
+		user  pgadapter.UserAdapter
+		tiers loyalty.Tiers
+	}
+	type args struct {
+		w *httptest.ResponseRecorder
+		r *http.Request
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		args           args
+		wantStatusCode int
+	}{
+		{
+			name: "Show profile",
+			fields: fields{
+				user: mockUserAdapter{},
+				tiers: mockTiers{
+					tier: &models.UserTier{
+						Tier:    models.Tier{Name: "Silver", Multiplier: 1},
+						Next:    &models.Tier{Name: "Gold", Threshold: 1000, Multiplier: 1.05},
+						Accrued: 100,
+						Since:   time.Now(),
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/profile", nil).
+					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
+			},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "User read error",
+			fields: fields{
+				user:  mockUserAdapter{err: errors.New("error")},
+				tiers: mockTiers{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/profile", nil).
+					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
+			},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name: "Tier evaluation error",
+			fields: fields{
+				user:  mockUserAdapter{},
+				tiers: mockTiers{err: errors.New("error")},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/profile", nil).
+					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
+			},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &profileHandler{
+				user:  tt.fields.user,
+				tiers: tt.fields.tiers,
+			}
+			h.GetProfileHandler(tt.args.w, tt.args.r)
+			if tt.args.w.Code != tt.wantStatusCode {
+				t.Errorf("profileHandler.GetProfileHandler() error = %v, wantErr %v", tt.args.w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
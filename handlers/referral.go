@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+type ReferralHandler interface {
+	GetReferralsHandler(w http.ResponseWriter, r *http.Request)
+}
+type referralHandler struct {
+	user     pgadapter.UserAdapter
+	referral pgadapter.ReferralAdapter
+}
+
+func NewReferralHandler(user pgadapter.UserAdapter, referral pgadapter.ReferralAdapter) ReferralHandler {
+	return &referralHandler{
+		user:     user,
+		referral: referral,
+	}
+}
+
+func (h *referralHandler) GetReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	// Find user to show his referral code
+	user, err := h.user.ReadUserByID(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Find referrals invited by user
+	referrals, err := h.referral.ReadReferrals(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := models.ResponseReferrals{
+		Code:      user.ReferralCode,
+		Referrals: make([]*models.Referral, 0, len(referrals)),
+	}
+	for _, referral := range referrals {
+		// Hide logins of other users
+		referral.ReferredLogin = maskLogin(referral.ReferredLogin)
+		response.Earned += referral.Bonus
+		response.Referrals = append(response.Referrals, referral)
+	}
+
+	// Pack
+	referralsJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(referralsJSON)
+}
+
+func maskLogin(login string) string {
+	if len(login) < 2 {
+		return "***"
+	}
+	return login[:1] + "***" + login[len(login)-1:]
+}
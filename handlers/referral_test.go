@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_referralHandler_GetReferralsHandler(t *testing.T) {
+	type fields struct {
+		user     pgadapter.UserAdapter
+		referral pgadapter.ReferralAdapter
+	}
+	type args struct {
+		w *httptest.ResponseRecorder
+		r *http.Request
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		args           args
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name: "Show referrals",
+			fields: fields{
+				user: mockUserAdapter{},
+				referral: mockReferralAdapter{
+					referrals: []*models.Referral{
+						{
+							ReferredLogin: "friend",
+							Status:        models.ReferralStatusRewarded,
+							Bonus:         100,
+							CreatedAt:     time.Now(),
+						},
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/referrals", nil).
+					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"login":"f***d"`,
+		},
+		{
+			name: "Internal error",
+			fields: fields{
+				user: mockUserAdapter{},
+				referral: mockReferralAdapter{
+					err: errors.New("error"),
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/referrals", nil).
+					WithContext(context.WithValue(context.Background(), models.UserID, "user_id")),
+			},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &referralHandler{
+				user:     tt.fields.user,
+				referral: tt.fields.referral,
+			}
+			h.GetReferralsHandler(tt.args.w, tt.args.r)
+			if tt.args.w.Code != tt.wantStatusCode {
+				t.Errorf("referralHandler.GetReferralsHandler() error = %v, wantErr %v", tt.args.w.Code, tt.wantStatusCode)
+			}
+			if !strings.Contains(tt.args.w.Body.String(), tt.wantBody) {
+				t.Errorf("referralHandler.GetReferralsHandler() body = %v, want %v", tt.args.w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/rs/zerolog/log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// writeJSON packs v and sends it with given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// setRetryAfter tells client in whole seconds when to try again
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
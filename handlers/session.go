@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+type SessionHandler interface {
+	GetSessionsHandler(w http.ResponseWriter, r *http.Request)
+	RevokeSessionHandler(w http.ResponseWriter, r *http.Request)
+}
+type sessionHandler struct {
+	sessions pgadapter.SessionAdapter
+	audit    audit.Recorder
+}
+
+func NewSessionHandler(sessions pgadapter.SessionAdapter, auditor audit.Recorder) SessionHandler {
+	return &sessionHandler{
+		sessions: sessions,
+		audit:    auditor,
+	}
+}
+
+// GetSessionsHandler shows active sessions of current user, the one of request is marked current
+func (h *sessionHandler) GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+	sessionID, _ := r.Context().Value(models.SessionID).(string)
+
+	sessions, err := h.sessions.ReadSessions(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == sessionID
+	}
+	if sessions == nil {
+		sessions = []*models.Session{}
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSessionHandler ends any session of current user, e.g. on a lost device
+func (h *sessionHandler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	id := chi.URLParam(r, "id")
+	if err := h.sessions.DeleteSession(r.Context(), userID, id); err != nil {
+		if errors.Is(err, models.ErrorNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditRevokeSession, userID, map[string]any{
+		"session_id": id,
+	}))
+	w.WriteHeader(http.StatusNoContent)
+}
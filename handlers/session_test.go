@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_sessionHandler_GetSessionsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		sessions       []*models.Session
+		wantStatusCode int
+		wantCurrent    []bool
+	}{
+		{
+			name:           "Current session marked",
+			sessions:       []*models.Session{{ID: "other"}, {ID: "current"}},
+			wantStatusCode: http.StatusOK,
+			wantCurrent:    []bool{false, true},
+		},
+		{
+			name:           "No sessions",
+			wantStatusCode: http.StatusOK,
+			wantCurrent:    []bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &sessionHandler{sessions: mockSessionAdapter{sessions: tt.sessions}, audit: mockRecorder{}}
+			w := httptest.NewRecorder()
+			ctx := context.WithValue(context.Background(), models.UserID, "user_id")
+			ctx = context.WithValue(ctx, models.SessionID, "current")
+			h.GetSessionsHandler(w, httptest.NewRequest("GET", "/sessions", nil).WithContext(ctx))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("sessionHandler.GetSessionsHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			var got []*models.Session
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("sessionHandler.GetSessionsHandler() body = %v", w.Body.String())
+			}
+			if len(got) != len(tt.wantCurrent) {
+				t.Fatalf("sessionHandler.GetSessionsHandler() got %d sessions, want %d", len(got), len(tt.wantCurrent))
+			}
+			for i, session := range got {
+				if session.Current != tt.wantCurrent[i] {
+					t.Errorf("sessionHandler.GetSessionsHandler() session %s current = %v, want %v", session.ID, session.Current, tt.wantCurrent[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_sessionHandler_RevokeSessionHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatusCode int
+	}{
+		{
+			name:           "Revoked",
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Someone else's or unknown session",
+			err:            models.ErrorNotFound,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &sessionHandler{sessions: mockSessionAdapter{err: tt.err}, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("DELETE", "/sessions/session_id", nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.RevokeSessionHandler(w, withURLParam(r, "id", "session_id"))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("sessionHandler.RevokeSessionHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code == http.StatusNoContent && (len(events) != 1 || events[0].Action != models.AuditRevokeSession) {
+				t.Errorf("sessionHandler.RevokeSessionHandler() audited %v, want %v", events, models.AuditRevokeSession)
+			}
+		})
+	}
+}
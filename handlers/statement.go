@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultStatementLimit = 100
+	maxStatementLimit     = 1000
+)
+
+type StatementHandler interface {
+	GetStatementHandler(w http.ResponseWriter, r *http.Request)
+	ExportStatementHandler(w http.ResponseWriter, r *http.Request)
+	GetMonthlyStatementHandler(w http.ResponseWriter, r *http.Request)
+}
+type statementHandler struct {
+	statement  pgadapter.StatementAdapter
+	order      pgadapter.OrderAdapter
+	withdrawal pgadapter.WithdrawalAdapter
+}
+
+func NewStatementHandler(statement pgadapter.StatementAdapter, order pgadapter.OrderAdapter, withdrawal pgadapter.WithdrawalAdapter) StatementHandler {
+	return &statementHandler{
+		statement:  statement,
+		order:      order,
+		withdrawal: withdrawal,
+	}
+}
+
+// GetStatementHandler shows balance-affecting events with running balance.
+// Accepts from and to (date or RFC3339), limit and offset query parameters
+func (h *statementHandler) GetStatementHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	filter, err := parseStatementFilter(r)
+	if err != nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.statement.ReadStatement(r.Context(), userID, filter)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		log.Debug().Msgf("No statement entries for user %s", userID)
+		http.Error(w, "No entries", http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// ExportStatementHandler streams orders, accruals and withdrawals as a downloadable file.
+// Accepts format (csv, json or ndjson) and the same from and to as GetStatementHandler.
+// Export always covers the whole period, so limit and offset are rejected instead of being ignored.
+// Records are sent as they are read, so once streaming started errors can only cut the file short
+func (h *statementHandler) ExportStatementHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	format, ok := exportFormatOf(r)
+	if !ok {
+		log.Debug().Msgf("Unknown export format %s", r.URL.Query().Get("format"))
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Has("limit") || r.URL.Query().Has("offset") {
+		log.Debug().Msg("Export doesn't support limit and offset")
+		http.Error(w, "Export doesn't support limit and offset", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseStatementFilter(r)
+	if err != nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	records := startExport(w, format, statementColumns, exportFilename(filter))
+
+	err = h.order.StreamOrders(r.Context(), userID, filter.From, filter.To, func(order *models.Order) error {
+		err := records.Write(&models.ExportRecord{
+			Type:   models.ExportOrder,
+			Number: order.ID,
+			Status: order.Status,
+			Date:   order.UploadedAt,
+		})
+		if err != nil || order.Status != models.OrderStatusProcessed || order.Accrual <= 0 {
+			return err
+		}
+		return records.Write(&models.ExportRecord{
+			Type:   models.ExportAccrual,
+			Number: order.ID,
+			Amount: order.Accrual,
+			Date:   order.UpdatedAt,
+		})
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to export orders of user %s", userID)
+		return
+	}
+	err = h.withdrawal.StreamWithdrawals(r.Context(), userID, filter.From, filter.To, func(withdrawal *models.Withdrawal) error {
+		return records.Write(&models.ExportRecord{
+			Type:   models.ExportWithdrawal,
+			Number: withdrawal.OrderID,
+			Amount: withdrawal.Sum,
+			Date:   withdrawal.ProcessedAt,
+		})
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to export withdrawals of user %s", userID)
+		return
+	}
+	if err = records.Close(); err != nil {
+		log.Error().Err(err).Msgf("Failed to finish export of user %s", userID)
+	}
+}
+
+// GetMonthlyStatementHandler shows statement of month given in form yyyy-mm
+func (h *statementHandler) GetMonthlyStatementHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	month := chi.URLParam(r, "month")
+	if _, err := time.Parse(pgadapter.MonthLayout, month); err != nil {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	statement, err := h.statement.ReadMonthlyStatement(r.Context(), userID, month)
+	if err != nil {
+		if errors.Is(err, models.ErrorNotFound) {
+			http.Error(w, "Statement not found", http.StatusNotFound)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, statement)
+}
+
+func parseStatementFilter(r *http.Request) (models.StatementFilter, error) {
+	query := r.URL.Query()
+	filter := models.StatementFilter{Limit: defaultStatementLimit}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = parseStatementTime(from, false); err != nil {
+			return filter, err
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = parseStatementTime(to, true); err != nil {
+			return filter, err
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		return filter, errors.New("to must be after from")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxStatementLimit {
+			return filter, errors.New("invalid limit")
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		filter.Offset, err = strconv.Atoi(offset)
+		if err != nil || filter.Offset < 0 {
+			return filter, errors.New("invalid offset")
+		}
+	}
+	return filter, nil
+}
+
+// exportFilename names file after exported period, e.g. statement_2026-01-01_2026-01-31
+func exportFilename(filter models.StatementFilter) string {
+	name := "statement"
+	if !filter.From.IsZero() {
+		name += "_" + filter.From.Format("2006-01-02")
+	}
+	if !filter.To.IsZero() {
+		// Upper bound is exclusive
+		name += "_" + filter.To.Add(-time.Nanosecond).Format("2006-01-02")
+	}
+	return name
+}
+
+// parseStatementTime accepts RFC3339 or plain date, plain date as upper bound includes the whole day
+func parseStatementTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
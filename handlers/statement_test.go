@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_statementHandler_GetStatementHandler(t *testing.T) {
+	entries := []*models.StatementEntry{
+		{Kind: models.StatementAccrual, Reference: "2377225624", Amount: 100, Balance: 100, OccurredAt: time.Now()},
+		{Kind: models.StatementWithdrawal, Reference: "12345678903", Amount: -40, Balance: 60, OccurredAt: time.Now()},
+	}
+	tests := []struct {
+		name           string
+		query          string
+		statement      mockStatementAdapter
+		wantStatusCode int
+		wantFilter     models.StatementFilter
+	}{
+		{
+			name:           "Default page",
+			query:          "",
+			statement:      mockStatementAdapter{entries: entries},
+			wantStatusCode: http.StatusOK,
+			wantFilter:     models.StatementFilter{Limit: defaultStatementLimit},
+		},
+		{
+			name:           "Date range and page",
+			query:          "?from=2026-01-01&to=2026-01-31&limit=10&offset=20",
+			statement:      mockStatementAdapter{entries: entries},
+			wantStatusCode: http.StatusOK,
+			wantFilter: models.StatementFilter{
+				From:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:     time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				Limit:  10,
+				Offset: 20,
+			},
+		},
+		{
+			name:           "Empty statement",
+			statement:      mockStatementAdapter{},
+			wantStatusCode: http.StatusNoContent,
+			wantFilter:     models.StatementFilter{Limit: defaultStatementLimit},
+		},
+		{
+			name:           "Bad date",
+			query:          "?from=yesterday",
+			statement:      mockStatementAdapter{entries: entries},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Reversed range",
+			query:          "?from=2026-02-01&to=2026-01-01",
+			statement:      mockStatementAdapter{entries: entries},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Limit too big",
+			query:          "?limit=100000",
+			statement:      mockStatementAdapter{entries: entries},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Internal error",
+			statement:      mockStatementAdapter{err: errors.New("error")},
+			wantStatusCode: http.StatusInternalServerError,
+			wantFilter:     models.StatementFilter{Limit: defaultStatementLimit},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter models.StatementFilter
+			tt.statement.filter = &filter
+			h := &statementHandler{statement: tt.statement}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/statement"+tt.query, nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.GetStatementHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("statementHandler.GetStatementHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if filter != tt.wantFilter {
+				t.Errorf("statementHandler.GetStatementHandler() filter = %+v, want %+v", filter, tt.wantFilter)
+			}
+		})
+	}
+}
+
+func Test_statementHandler_ExportStatementHandler(t *testing.T) {
+	date := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	order := mockOrderAdapter{order: &models.Order{
+		ID: "2377225624", Status: models.OrderStatusProcessed, Accrual: 500, UploadedAt: date, UpdatedAt: date,
+	}}
+	withdrawal := mockWithdrawalAdapter{withdrawal: []*models.Withdrawal{
+		{OrderID: "12345678903", Sum: 40, ProcessedAt: date},
+	}}
+	tests := []struct {
+		name            string
+		query           string
+		order           pgadapter.OrderAdapter
+		withdrawal      pgadapter.WithdrawalAdapter
+		wantStatusCode  int
+		wantDisposition string
+		wantBody        string
+	}{
+		{
+			name:            "CSV by default",
+			order:           order,
+			withdrawal:      withdrawal,
+			wantStatusCode:  http.StatusOK,
+			wantDisposition: `attachment; filename=statement.csv`,
+			wantBody: "type,number,status,amount,date\n" +
+				"ORDER,2377225624,PROCESSED,0,2026-01-10T00:00:00Z\n" +
+				"ACCRUAL,2377225624,,500,2026-01-10T00:00:00Z\n" +
+				"WITHDRAWAL,12345678903,,40,2026-01-10T00:00:00Z\n",
+		},
+		{
+			name:            "JSON for period",
+			query:           "?format=json&from=2026-01-01&to=2026-01-31",
+			order:           order,
+			withdrawal:      withdrawal,
+			wantStatusCode:  http.StatusOK,
+			wantDisposition: `attachment; filename=statement_2026-01-01_2026-01-31.json`,
+			wantBody: `[{"type":"ORDER","number":"2377225624","status":"PROCESSED","amount":0,"date":"2026-01-10T00:00:00Z"},` +
+				`{"type":"ACCRUAL","number":"2377225624","amount":500,"date":"2026-01-10T00:00:00Z"},` +
+				`{"type":"WITHDRAWAL","number":"12345678903","amount":40,"date":"2026-01-10T00:00:00Z"}]`,
+		},
+		{
+			name:            "Empty JSON",
+			query:           "?format=json",
+			order:           mockOrderAdapter{},
+			withdrawal:      mockWithdrawalAdapter{},
+			wantStatusCode:  http.StatusOK,
+			wantDisposition: `attachment; filename=statement.json`,
+			wantBody:        `[]`,
+		},
+		{
+			name:            "NDJSON",
+			query:           "?format=ndjson",
+			order:           mockOrderAdapter{},
+			withdrawal:      withdrawal,
+			wantStatusCode:  http.StatusOK,
+			wantDisposition: `attachment; filename=statement.ndjson`,
+			wantBody:        `{"type":"WITHDRAWAL","number":"12345678903","amount":40,"date":"2026-01-10T00:00:00Z"}` + "\n",
+		},
+		{
+			name:           "Unknown format",
+			query:          "?format=pdf",
+			order:          order,
+			withdrawal:     withdrawal,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Paginated",
+			query:          "?format=csv&limit=10&offset=20",
+			order:          order,
+			withdrawal:     withdrawal,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &statementHandler{order: tt.order, withdrawal: tt.withdrawal}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/statement/export"+tt.query, nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.ExportStatementHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("statementHandler.ExportStatementHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("statementHandler.ExportStatementHandler() disposition = %v, want %v", got, tt.wantDisposition)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("statementHandler.ExportStatementHandler() body = %v, want %v", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_statementHandler_GetMonthlyStatementHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		month          string
+		statement      pgadapter.StatementAdapter
+		wantStatusCode int
+	}{
+		{
+			name:  "Generated",
+			month: "2026-01",
+			statement: mockStatementAdapter{statement: &models.MonthlyStatement{
+				Month: "2026-01", Opening: 100, Accrued: 50, Withdrawn: 20, Expired: 10, Closing: 120,
+			}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Not generated",
+			month:          "2026-02",
+			statement:      mockStatementAdapter{err: models.ErrorNotFound},
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Bad month",
+			month:          "2026-13",
+			statement:      mockStatementAdapter{},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Internal error",
+			month:          "2026-01",
+			statement:      mockStatementAdapter{err: errors.New("error")},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &statementHandler{statement: tt.statement}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/statements/"+tt.month, nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.GetMonthlyStatementHandler(w, withURLParam(r, "month", tt.month))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("statementHandler.GetMonthlyStatementHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
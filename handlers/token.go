@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/middlwares"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+const (
+	// refreshCookie is sent only to token endpoints
+	refreshCookie     = "Refresh"
+	refreshCookiePath = "/api/user/token"
+	// refreshTokenBytes is entropy of refresh tokens
+	refreshTokenBytes = 32
+)
+
+type TokenHandler interface {
+	RefreshTokenHandler(w http.ResponseWriter, r *http.Request)
+	LogoutHandler(w http.ResponseWriter, r *http.Request)
+}
+type tokenHandler struct {
+	user     pgadapter.UserAdapter
+	tokens   pgadapter.TokenAdapter
+	sessions *middlwares.Sessions
+	mfa      pgadapter.MFAAdapter
+	audit    audit.Recorder
+}
+
+func NewTokenHandler(user pgadapter.UserAdapter,
+	tokens pgadapter.TokenAdapter,
+	sessions *middlwares.Sessions,
+	mfa pgadapter.MFAAdapter,
+	auditor audit.Recorder) TokenHandler {
+	return &tokenHandler{
+		user:     user,
+		tokens:   tokens,
+		sessions: sessions,
+		mfa:      mfa,
+		audit:    auditor,
+	}
+}
+
+// RefreshTokenHandler exchanges refresh token from Refresh cookie or refresh_token body field for a new pair.
+// New access token has current role of user, so role changes take effect.
+// Staff without second factor can't refresh, session started before promotion is revoked
+// and user has to enroll second factor like at login.
+// Reused refresh token revokes its whole session
+func (h *tokenHandler) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	refresh := ""
+	if cookie, err := r.Cookie(refreshCookie); err == nil {
+		refresh = cookie.Value
+	} else {
+		var bodyJSON struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&bodyJSON)
+		refresh = bodyJSON.RefreshToken
+	}
+	if refresh == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	next, token := newRefreshToken()
+	err := h.tokens.RotateRefreshToken(r.Context(), hashToken(refresh), next)
+	switch {
+	case errors.Is(err, models.ErrorTokenReused):
+		log.Warn().Msgf("Refresh token of session %s reused, session revoked", next.FamilyID)
+		h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditTokenReuse, next.UserID, map[string]any{
+			"session_id": next.FamilyID,
+		}))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case errors.Is(err, models.ErrorNotFound), errors.Is(err, models.ErrorSessionRevoked):
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.user.ReadUserByID(r.Context(), next.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.LockedAt != nil {
+		if err = h.tokens.RevokeFamily(r.Context(), next.FamilyID); err != nil {
+			log.Error().Err(err).Msgf("Failed to revoke session %s", next.FamilyID)
+		}
+		http.Error(w, "Account locked", http.StatusLocked)
+		return
+	}
+	// Sessions of users with second factor passed it at login, so only missing enrollment is checked
+	purpose, err := mfaPurpose(r.Context(), h.mfa, user)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if purpose == middlwares.MFAPurposeEnroll {
+		if err = h.tokens.RevokeFamily(r.Context(), next.FamilyID); err != nil {
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeMFARequired(w, user, purpose)
+		return
+	}
+
+	jwt, err := middlwares.GenerateJWT(user.ID, user.Role, next.FamilyID)
+	if err != nil {
+		log.Debug().Msgf("Internal server error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	tokens := newResponseTokens(jwt, token)
+	setAuthCookies(w, tokens, next.FamilyID)
+	writeLoggedIn(w, tokens, "")
+}
+
+// LogoutHandler revokes current session and clears auth cookies
+func (h *tokenHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+	sessionID, _ := r.Context().Value(models.SessionID).(string)
+
+	var err error
+	if config.GetConfig().AuthMode == middlwares.AuthModeSession {
+		err = h.sessions.End(r.Context())
+	} else {
+		err = h.tokens.RevokeFamily(r.Context(), sessionID)
+	}
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditLogout, userID, map[string]any{
+		"session_id": sessionID,
+	}))
+	clearAuthCookies(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// logIn starts session of user in configured auth mode, in jwt mode it returns issued tokens.
+// Browser gets CSRF token of the new session either way
+func logIn(w http.ResponseWriter, r *http.Request, tokens pgadapter.TokenAdapter, sessions *middlwares.Sessions, user *models.User) (*models.ResponseTokens, error) {
+	if config.GetConfig().AuthMode == middlwares.AuthModeSession {
+		sessionID, err := sessions.Start(r.Context(), r, user)
+		if err != nil {
+			return nil, err
+		}
+		middlwares.SetCSRFCookie(w, sessionID)
+		return nil, nil
+	}
+	pair, sessionID, err := startSession(r.Context(), tokens, user)
+	if err != nil {
+		return nil, err
+	}
+	setAuthCookies(w, pair, sessionID)
+	return pair, nil
+}
+
+// logOutEverywhere ends all sessions of user in configured auth mode but exceptID, which may be empty
+func logOutEverywhere(ctx context.Context, tokens pgadapter.TokenAdapter, sessions *middlwares.Sessions, userID, exceptID string) error {
+	if config.GetConfig().AuthMode == middlwares.AuthModeSession {
+		return sessions.EndUserSessions(ctx, userID, exceptID)
+	}
+	return tokens.RevokeUserFamilies(ctx, userID, exceptID)
+}
+
+// writeLoggedIn sends issued tokens in Authorization header and body, so clients without cookies can use them.
+// Session logins have no tokens and get plain text message
+func writeLoggedIn(w http.ResponseWriter, tokens *models.ResponseTokens, message string) {
+	if tokens == nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(message))
+		return
+	}
+	w.Header().Set("Authorization", tokens.TokenType+" "+tokens.AccessToken)
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// startSession creates session of user and issues its first token pair, it returns id of the session too
+func startSession(ctx context.Context, tokens pgadapter.TokenAdapter, user *models.User) (*models.ResponseTokens, string, error) {
+	family := &models.TokenFamily{
+		ID:        helpers.GenerateUUID(),
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+	}
+	refresh, token := newRefreshToken()
+	if err := tokens.CreateFamily(ctx, family, refresh); err != nil {
+		return nil, "", err
+	}
+	jwt, err := middlwares.GenerateJWT(user.ID, user.Role, family.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return newResponseTokens(jwt, token), family.ID, nil
+}
+
+// newRefreshToken returns token to store and its plain value to give to client
+func newRefreshToken() (*models.RefreshToken, string) {
+	token := helpers.GenerateCode(refreshTokenBytes)
+	now := time.Now()
+	return &models.RefreshToken{
+		Hash:      hashToken(token),
+		ExpiresAt: now.Add(config.GetConfig().RefreshTokenTTL),
+		CreatedAt: now,
+	}, token
+}
+
+func hashToken(token string) string {
+	return helpers.HashToken(token)
+}
+
+func newResponseTokens(jwt, refresh string) *models.ResponseTokens {
+	return &models.ResponseTokens{
+		AccessToken:  jwt,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(config.GetConfig().AccessTokenTTL.Seconds()),
+	}
+}
+
+// setAuthCookies sends tokens and CSRF token of their session to browser, refresh token goes only to token endpoints
+// and lives as long as it is valid
+func setAuthCookies(w http.ResponseWriter, tokens *models.ResponseTokens, sessionID string) {
+	http.SetCookie(w, middlwares.NewCookie(middlwares.AuthCookie, tokens.AccessToken))
+	refresh := middlwares.NewCookie(refreshCookie, tokens.RefreshToken)
+	refresh.Path = refreshCookiePath
+	refresh.MaxAge = int(config.GetConfig().RefreshTokenTTL.Seconds())
+	http.SetCookie(w, refresh)
+	middlwares.SetCSRFCookie(w, sessionID)
+}
+
+func clearAuthCookies(w http.ResponseWriter) {
+	http.SetCookie(w, middlwares.ExpiredCookie(middlwares.AuthCookie))
+	refresh := middlwares.ExpiredCookie(refreshCookie)
+	refresh.Path = refreshCookiePath
+	http.SetCookie(w, refresh)
+	csrf := middlwares.ExpiredCookie(middlwares.CSRFCookie)
+	csrf.HttpOnly = false
+	http.SetCookie(w, csrf)
+}
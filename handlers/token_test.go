@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockLockedUserAdapter struct {
+	mockUserAdapter
+}
+
+func (m mockLockedUserAdapter) ReadUserByID(ctx context.Context, id string) (*models.User, error) {
+	lockedAt := time.Now()
+	return &models.User{ID: id, LockedAt: &lockedAt}, nil
+}
+
+func Test_tokenHandler_RefreshTokenHandler(t *testing.T) {
+	enabledAt := time.Now()
+	tests := []struct {
+		name           string
+		cookie         string
+		body           string
+		user           pgadapter.UserAdapter
+		mfa            mockMFAAdapter
+		tokensErr      error
+		wantStatusCode int
+		wantCookies    bool
+		wantRevoked    bool
+		wantAudit      string
+	}{
+		{
+			name:           "Refreshed by cookie",
+			cookie:         "refresh",
+			user:           mockUserAdapter{},
+			wantStatusCode: http.StatusOK,
+			wantCookies:    true,
+		},
+		{
+			name:           "Refreshed by body",
+			body:           `{"refresh_token": "refresh"}`,
+			user:           mockUserAdapter{},
+			wantStatusCode: http.StatusOK,
+			wantCookies:    true,
+		},
+		{
+			name:           "No refresh token",
+			user:           mockUserAdapter{},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Unknown or expired token",
+			cookie:         "refresh",
+			user:           mockUserAdapter{},
+			tokensErr:      models.ErrorNotFound,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Revoked session",
+			cookie:         "refresh",
+			user:           mockUserAdapter{},
+			tokensErr:      models.ErrorSessionRevoked,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Reused token",
+			cookie:         "refresh",
+			user:           mockUserAdapter{},
+			tokensErr:      models.ErrorTokenReused,
+			wantStatusCode: http.StatusUnauthorized,
+			wantAudit:      models.AuditTokenReuse,
+		},
+		{
+			name:           "Deleted user",
+			cookie:         "refresh",
+			user:           mockUserAdapter{err: sql.ErrNoRows},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Locked user",
+			cookie:         "refresh",
+			user:           mockLockedUserAdapter{},
+			wantStatusCode: http.StatusLocked,
+			wantRevoked:    true,
+		},
+		{
+			name:           "Promoted without second factor",
+			cookie:         "refresh",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Role: models.RoleAdmin}},
+			wantStatusCode: http.StatusAccepted,
+			wantRevoked:    true,
+		},
+		{
+			name:           "Promoted with second factor",
+			cookie:         "refresh",
+			user:           mockUserAdapter{user: &models.User{ID: "user_id", Role: models.RoleSupport}},
+			mfa:            mockMFAAdapter{mfa: &models.MFA{UserID: "user_id", EnabledAt: &enabledAt}},
+			wantStatusCode: http.StatusOK,
+			wantCookies:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked []string
+			var events []*models.AuditEvent
+			h := &tokenHandler{
+				user:   tt.user,
+				tokens: mockTokenAdapter{revoked: &revoked, err: tt.tokensErr},
+				mfa:    tt.mfa,
+				audit:  mockRecorder{events: &events},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/token/refresh", strings.NewReader(tt.body))
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: refreshCookie, Value: tt.cookie})
+			}
+			h.RefreshTokenHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("tokenHandler.RefreshTokenHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if gotCookies := len(w.Result().Cookies()) == 3; gotCookies != tt.wantCookies {
+				t.Errorf("tokenHandler.RefreshTokenHandler() cookies = %v, want %v", gotCookies, tt.wantCookies)
+			}
+			if gotRevoked := len(revoked) > 0; gotRevoked != tt.wantRevoked {
+				t.Errorf("tokenHandler.RefreshTokenHandler() revoked = %v, want %v", gotRevoked, tt.wantRevoked)
+			}
+			if tt.wantAudit != "" && (len(events) != 1 || events[0].Action != tt.wantAudit) {
+				t.Errorf("tokenHandler.RefreshTokenHandler() audited %v, want %v", events, tt.wantAudit)
+			}
+		})
+	}
+}
+
+func Test_tokenHandler_LogoutHandler(t *testing.T) {
+	var revoked []string
+	var events []*models.AuditEvent
+	h := &tokenHandler{tokens: mockTokenAdapter{revoked: &revoked}, audit: mockRecorder{events: &events}}
+	w := httptest.NewRecorder()
+	ctx := context.WithValue(context.Background(), models.UserID, "user_id")
+	ctx = context.WithValue(ctx, models.SessionID, "family_id")
+	h.LogoutHandler(w, httptest.NewRequest("POST", "/logout", nil).WithContext(ctx))
+	if w.Code != http.StatusOK {
+		t.Errorf("tokenHandler.LogoutHandler() error = %v, wantErr %v", w.Code, http.StatusOK)
+	}
+	if len(revoked) != 1 || revoked[0] != "family_id" {
+		t.Errorf("tokenHandler.LogoutHandler() revoked = %v, want [family_id]", revoked)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			t.Errorf("tokenHandler.LogoutHandler() cookie %s is not cleared", cookie.Name)
+		}
+	}
+	if len(events) != 1 || events[0].Action != models.AuditLogout {
+		t.Errorf("tokenHandler.LogoutHandler() audited %v, want %v", events, models.AuditLogout)
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+type TransferHandler interface {
+	TransferBalanceHandler(w http.ResponseWriter, r *http.Request)
+	GetTransfersHandler(w http.ResponseWriter, r *http.Request)
+}
+type transferHandler struct {
+	user       pgadapter.UserAdapter
+	transfer   pgadapter.TransferAdapter
+	audit      audit.Recorder
+	dailyLimit float64
+}
+
+func NewTransferHandler(user pgadapter.UserAdapter, transfer pgadapter.TransferAdapter, auditor audit.Recorder, dailyLimit float64) TransferHandler {
+	return &transferHandler{
+		user:       user,
+		transfer:   transfer,
+		audit:      auditor,
+		dailyLimit: dailyLimit,
+	}
+}
+
+func (h *transferHandler) TransferBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	// Parse JSON request body
+	var bodyJSON struct {
+		Login string  `json:"login"`
+		Sum   float64 `json:"sum"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Login == "" || bodyJSON.Sum <= 0 {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Find receiver
+	receiver, err := h.user.ReadUser(r.Context(), bodyJSON.Login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Debug().Msgf("Receiver %s not found", bodyJSON.Login)
+			http.Error(w, "Receiver not found", http.StatusNotFound)
+			return
+		}
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if receiver.ID == userID {
+		log.Debug().Msg("Transfer to self")
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Debit sender and credit receiver at once
+	transfer := &models.Transfer{
+		ID:         helpers.GenerateUUID(),
+		SenderID:   userID,
+		ReceiverID: receiver.ID,
+		Amount:     bodyJSON.Sum,
+		CreatedAt:  time.Now(),
+	}
+	err = h.transfer.CreateTransfer(r.Context(), transfer, h.dailyLimit)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrorInsufficientFunds):
+			log.Debug().Msg("Insufficient funds")
+			http.Error(w, "Insufficient funds", http.StatusPaymentRequired)
+		case errors.Is(err, models.ErrorTransferLimit):
+			log.Debug().Msg("Daily transfer limit exceeded")
+			http.Error(w, "Daily transfer limit exceeded", http.StatusForbidden)
+		default:
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditTransfer, userID, map[string]any{
+		"transfer_id": transfer.ID,
+		"receiver_id": transfer.ReceiverID,
+		"amount":      transfer.Amount,
+	}))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *transferHandler) GetTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	// Find transfers sent and received by user
+	transfers, err := h.transfer.ReadTransfers(r.Context(), userID)
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if transfers == nil {
+		log.Debug().Msgf("No transfers for user %s", userID)
+		http.Error(w, "No transfers", http.StatusNoContent)
+		return
+	}
+
+	// Show only counterparty of each transfer
+	response := make([]models.ResponseTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		t := models.ResponseTransfer{
+			Direction: models.TransferIn,
+			Login:     transfer.SenderLogin,
+			Sum:       transfer.Amount,
+			CreatedAt: transfer.CreatedAt,
+		}
+		if transfer.SenderID == userID {
+			t.Direction = models.TransferOut
+			t.Login = transfer.ReceiverLogin
+		}
+		response = append(response, t)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
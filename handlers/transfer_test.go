@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_transferHandler_TransferBalanceHandler(t *testing.T) {
+	type fields struct {
+		user     pgadapter.UserAdapter
+		transfer pgadapter.TransferAdapter
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name: "Transferred",
+			fields: fields{
+				user:     mockUserAdapter{user: &models.User{ID: "receiver_id"}},
+				transfer: mockTransferAdapter{},
+			},
+			body:           `{"login": "receiver", "sum": 100}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "Negative sum",
+			fields: fields{
+				user:     mockUserAdapter{user: &models.User{ID: "receiver_id"}},
+				transfer: mockTransferAdapter{},
+			},
+			body:           `{"login": "receiver", "sum": -100}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Transfer to self",
+			fields: fields{
+				user:     mockUserAdapter{user: &models.User{ID: "user_id"}},
+				transfer: mockTransferAdapter{},
+			},
+			body:           `{"login": "me", "sum": 100}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Unknown receiver",
+			fields: fields{
+				user:     mockUserAdapter{err: sql.ErrNoRows},
+				transfer: mockTransferAdapter{},
+			},
+			body:           `{"login": "nobody", "sum": 100}`,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name: "Insufficient funds",
+			fields: fields{
+				user:     mockUserAdapter{user: &models.User{ID: "receiver_id"}},
+				transfer: mockTransferAdapter{err: models.ErrorInsufficientFunds},
+			},
+			body:           `{"login": "receiver", "sum": 100}`,
+			wantStatusCode: http.StatusPaymentRequired,
+		},
+		{
+			name: "Daily limit exceeded",
+			fields: fields{
+				user:     mockUserAdapter{user: &models.User{ID: "receiver_id"}},
+				transfer: mockTransferAdapter{err: models.ErrorTransferLimit},
+			},
+			body:           `{"login": "receiver", "sum": 100}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &transferHandler{
+				user:     tt.fields.user,
+				transfer: tt.fields.transfer,
+				audit:    mockRecorder{events: &events},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/balance/transfer", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.TransferBalanceHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("transferHandler.TransferBalanceHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if wantAudited := w.Code == http.StatusOK; wantAudited != (len(events) == 1) {
+				t.Errorf("transferHandler.TransferBalanceHandler() audited %d events, want audited %v", len(events), wantAudited)
+			}
+		})
+	}
+}
+
+func Test_transferHandler_GetTransfersHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		transfer       pgadapter.TransferAdapter
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name: "Show both directions",
+			transfer: mockTransferAdapter{
+				transfers: []*models.Transfer{
+					{SenderID: "user_id", ReceiverLogin: "sister", Amount: 10, CreatedAt: time.Now()},
+					{SenderID: "brother_id", SenderLogin: "brother", ReceiverID: "user_id", Amount: 20, CreatedAt: time.Now()},
+				},
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"direction":"out","login":"sister"`,
+		},
+		{
+			name:           "Nothing to show",
+			transfer:       mockTransferAdapter{},
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Internal error",
+			transfer:       mockTransferAdapter{err: errors.New("error")},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &transferHandler{transfer: tt.transfer}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/transfers", nil).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.GetTransfersHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("transferHandler.GetTransfersHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("transferHandler.GetTransfersHandler() body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxMintedVouchers limits the number of codes minted by one request
+const maxMintedVouchers = 1000
+
+type VoucherHandler interface {
+	MintVouchersHandler(w http.ResponseWriter, r *http.Request)
+	GetVouchersHandler(w http.ResponseWriter, r *http.Request)
+	RedeemVoucherHandler(w http.ResponseWriter, r *http.Request)
+}
+type voucherHandler struct {
+	voucher  pgadapter.VoucherAdapter
+	attempts *helpers.Limiter
+	audit    audit.Recorder
+}
+
+func NewVoucherHandler(voucher pgadapter.VoucherAdapter, attempts *helpers.Limiter, auditor audit.Recorder) VoucherHandler {
+	return &voucherHandler{
+		voucher:  voucher,
+		attempts: attempts,
+		audit:    auditor,
+	}
+}
+
+// MintVouchersHandler creates vouchers and returns their codes, codes can't be retrieved later
+func (h *voucherHandler) MintVouchersHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var bodyJSON struct {
+		Points         float64    `json:"points"`
+		MaxRedemptions int        `json:"max_redemptions"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+		Count          int        `json:"count"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || bodyJSON.Points <= 0 || bodyJSON.MaxRedemptions < 0 ||
+		bodyJSON.Count < 0 || bodyJSON.Count > maxMintedVouchers ||
+		(bodyJSON.ExpiresAt != nil && bodyJSON.ExpiresAt.Before(time.Now())) {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Single-use single voucher by default
+	if bodyJSON.MaxRedemptions == 0 {
+		bodyJSON.MaxRedemptions = 1
+	}
+	if bodyJSON.Count == 0 {
+		bodyJSON.Count = 1
+	}
+	vouchers := make([]*models.Voucher, 0, bodyJSON.Count)
+	for i := 0; i < bodyJSON.Count; i++ {
+		code := helpers.GenerateCode(10)
+		vouchers = append(vouchers, &models.Voucher{
+			ID:             helpers.GenerateUUID(),
+			Code:           code,
+			CodeHash:       hashVoucherCode(code),
+			Points:         bodyJSON.Points,
+			MaxRedemptions: bodyJSON.MaxRedemptions,
+			ExpiresAt:      bodyJSON.ExpiresAt,
+			CreatedAt:      time.Now(),
+		})
+	}
+	if err = h.voucher.CreateVouchers(r.Context(), vouchers...); err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Codes are secret, only ids of minted vouchers are recorded
+	ids := make([]string, 0, len(vouchers))
+	for _, voucher := range vouchers {
+		ids = append(ids, voucher.ID)
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditMintVouchers, "", map[string]any{
+		"voucher_ids":     ids,
+		"points":          bodyJSON.Points,
+		"max_redemptions": bodyJSON.MaxRedemptions,
+		"expires_at":      bodyJSON.ExpiresAt,
+	}))
+	writeJSON(w, http.StatusCreated, vouchers)
+}
+
+func (h *voucherHandler) GetVouchersHandler(w http.ResponseWriter, r *http.Request) {
+	vouchers, err := h.voucher.ReadVouchers(r.Context())
+	if err != nil {
+		log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+		http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if vouchers == nil {
+		vouchers = []*models.Voucher{}
+	}
+	writeJSON(w, http.StatusOK, vouchers)
+}
+
+func (h *voucherHandler) RedeemVoucherHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, _ := r.Context().Value(models.UserID).(string)
+
+	// Every attempt counts, so codes can't be guessed
+	if ok, retryAfter := h.attempts.Allow(userID); !ok {
+		log.Debug().Msgf("Too many voucher attempts of user %s", userID)
+		setRetryAfter(w, retryAfter)
+		http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	var bodyJSON struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&bodyJSON)
+	if err != nil || strings.TrimSpace(bodyJSON.Code) == "" {
+		log.Debug().Msgf("Bad request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	redemption, err := h.voucher.RedeemVoucher(r.Context(), hashVoucherCode(bodyJSON.Code), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrorNotFound):
+			http.Error(w, "Unknown voucher", http.StatusNotFound)
+		case errors.Is(err, models.ErrorVoucherExpired), errors.Is(err, models.ErrorVoucherExhausted):
+			http.Error(w, err.Error(), http.StatusGone)
+		default:
+			log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+			http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	h.audit.Record(r.Context(), audit.FromRequest(r, models.AuditRedeemVoucher, userID, map[string]any{
+		"redemption_id": redemption.ID,
+		"voucher_id":    redemption.VoucherID,
+		"points":        redemption.Points,
+	}))
+	writeJSON(w, http.StatusOK, redemption)
+}
+
+// hashVoucherCode normalizes code the way users may type it and hashes it
+func hashVoucherCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return helpers.HashCode(config.GetConfig().VoucherPepper, code)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_voucherHandler_MintVouchersHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		voucher        pgadapter.VoucherAdapter
+		wantStatusCode int
+	}{
+		{
+			name:           "Mint single-use vouchers",
+			body:           `{"points": 100, "count": 3}`,
+			voucher:        mockVoucherAdapter{},
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name:           "No points",
+			body:           `{"count": 3}`,
+			voucher:        mockVoucherAdapter{},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Already expired",
+			body:           `{"points": 100, "expires_at": "2020-01-01T00:00:00Z"}`,
+			voucher:        mockVoucherAdapter{},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Internal error",
+			body:           `{"points": 100}`,
+			voucher:        mockVoucherAdapter{err: errors.New("error")},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &voucherHandler{voucher: tt.voucher, audit: mockRecorder{events: &events}}
+			w := httptest.NewRecorder()
+			h.MintVouchersHandler(w, httptest.NewRequest("POST", "/vouchers", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("voucherHandler.MintVouchersHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code == http.StatusCreated && (len(events) != 1 || events[0].Action != models.AuditMintVouchers) {
+				t.Errorf("voucherHandler.MintVouchersHandler() audited %v, want %v", events, models.AuditMintVouchers)
+			}
+			if len(events) == 1 && strings.Contains(string(events[0].Payload), `"code"`) {
+				t.Errorf("voucherHandler.MintVouchersHandler() audited voucher codes: %s", events[0].Payload)
+			}
+		})
+	}
+}
+
+func Test_voucherHandler_RedeemVoucherHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		voucher        pgadapter.VoucherAdapter
+		attempts       *helpers.Limiter
+		wantStatusCode int
+	}{
+		{
+			name:           "Redeemed",
+			body:           `{"code": "ABCD-EFGH"}`,
+			voucher:        mockVoucherAdapter{redemption: &models.VoucherRedemption{Points: 100, RedeemedAt: time.Now()}},
+			attempts:       helpers.NewLimiter(5, time.Hour),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Empty code",
+			body:           `{"code": ""}`,
+			voucher:        mockVoucherAdapter{},
+			attempts:       helpers.NewLimiter(5, time.Hour),
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown code",
+			body:           `{"code": "WRONG"}`,
+			voucher:        mockVoucherAdapter{err: models.ErrorNotFound},
+			attempts:       helpers.NewLimiter(5, time.Hour),
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Exhausted",
+			body:           `{"code": "USED"}`,
+			voucher:        mockVoucherAdapter{err: models.ErrorVoucherExhausted},
+			attempts:       helpers.NewLimiter(5, time.Hour),
+			wantStatusCode: http.StatusGone,
+		},
+		{
+			name:           "Too many attempts",
+			body:           `{"code": "ABCD"}`,
+			voucher:        mockVoucherAdapter{},
+			attempts:       helpers.NewLimiter(0, time.Hour),
+			wantStatusCode: http.StatusTooManyRequests,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*models.AuditEvent
+			h := &voucherHandler{
+				voucher:  tt.voucher,
+				attempts: tt.attempts,
+				audit:    mockRecorder{events: &events},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/vouchers/redeem", strings.NewReader(tt.body)).
+				WithContext(context.WithValue(context.Background(), models.UserID, "user_id"))
+			h.RedeemVoucherHandler(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("voucherHandler.RedeemVoucherHandler() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code == http.StatusOK && (len(events) != 1 || events[0].Action != models.AuditRedeemVoucher) {
+				t.Errorf("voucherHandler.RedeemVoucherHandler() audited %v, want %v", events, models.AuditRedeemVoucher)
+			}
+		})
+	}
+}
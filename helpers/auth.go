@@ -1,6 +1,11 @@
 package helpers
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -16,3 +21,23 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// GenerateCode returns random human friendly code of n bytes of entropy
+func GenerateCode(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+}
+
+// HashCode returns hex HMAC-SHA256 of code, so codes can be looked up without being stored
+func HashCode(secret, code string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashToken returns hex SHA-256 of random token, token has enough entropy to need no secret
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,70 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows at most max hits per key within fixed window
+type Limiter struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	windows map[string]*limiterWindow
+	swept   time.Time
+}
+type limiterWindow struct {
+	start time.Time
+	hits  int
+}
+
+func NewLimiter(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:     max,
+		window:  window,
+		windows: make(map[string]*limiterWindow),
+	}
+}
+
+// Allow registers hit of key and reports whether it is within the limit.
+// If it is not, returns time left until the window resets
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	ok, _, reset := l.Take(key)
+	if ok {
+		return true, 0
+	}
+	return false, reset
+}
+
+// Take registers hit of key like Allow and also tells how many hits are left and when the window resets
+func (l *Limiter) Take(key string) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		if now.Sub(l.swept) >= l.window {
+			l.sweep(now)
+		}
+		w = &limiterWindow{start: now}
+		l.windows[key] = w
+	}
+	reset := w.start.Add(l.window).Sub(now)
+	if w.hits >= l.max {
+		return false, 0, reset
+	}
+	w.hits++
+	return true, l.max - w.hits, reset
+}
+
+// sweep drops windows which have already expired, so the map doesn't grow forever.
+// It runs at most once per window, so expired windows live no longer than two windows
+func (l *Limiter) sweep(now time.Time) {
+	l.swept = now
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
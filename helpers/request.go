@@ -0,0 +1,15 @@
+package helpers
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP is address of client without port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
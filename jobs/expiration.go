@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// StartExpiration periodically writes off points which outlived configured lifetime
+func StartExpiration(ctx context.Context, balances pgadapter.BalanceAdapter, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			expire(ctx, balances)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// expire runs batches until there is nothing left to expire
+func expire(ctx context.Context, balances pgadapter.BalanceAdapter) {
+	now := time.Now()
+	for {
+		n, err := balances.ExpireLots(ctx, now)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to expire points")
+			return
+		}
+		if n == 0 {
+			return
+		}
+		log.Info().Msgf("Expired %d point lots", n)
+	}
+}
@@ -0,0 +1,29 @@
+package jobs
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// StartLoginAttemptCleanup periodically forgets failed logins older than window which no longer lock anything out
+func StartLoginAttemptCleanup(ctx context.Context, attempts pgadapter.LoginAttemptAdapter, window, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, err := attempts.DeleteStaleLoginAttempts(ctx, time.Now().Add(-window))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to delete stale login attempts")
+			} else if n > 0 {
+				log.Info().Msgf("Deleted %d stale login attempts", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
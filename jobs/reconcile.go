@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/audit"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"math"
+	"time"
+)
+
+const (
+	// ReconcileActor is recorded as author of adjustments written by reconciliation
+	ReconcileActor  = "reconcile"
+	reconcileReason = "reconciliation: balance changed without ledger entry"
+	// withdrawnTolerance hides rounding errors of float sums, as drift check does
+	withdrawnTolerance = 0.005
+)
+
+type ReconcileReport struct {
+	Drifts    []*models.BalanceDrift
+	Corrected []*models.Adjustment
+}
+
+// Unresolved tells whether drifts are left after reconciliation, with fix every drift is corrected or it fails
+func (r *ReconcileReport) Unresolved(fix bool) bool {
+	return !fix && len(r.Drifts) > 0
+}
+
+// StartReconciliation periodically checks all balances against the ledger and reports drifts,
+// with fix drifts are corrected by adjustment entries
+func StartReconciliation(ctx context.Context, adjustments pgadapter.AdjustmentAdapter, auditor audit.Recorder, interval time.Duration, fix bool) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := Reconcile(ctx, adjustments, auditor, fix); err != nil {
+				log.Error().Err(err).Msg("Failed to reconcile balances")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Reconcile checks every balance once and logs each drift found, corrections are audited.
+// Withdrawn totals are synced with withdrawals, balances below the ledger get missing points back
+func Reconcile(ctx context.Context, adjustments pgadapter.AdjustmentAdapter, auditor audit.Recorder, fix bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{}
+	after := ""
+	for {
+		drifts, next, err := adjustments.CheckBalances(ctx, after)
+		if err != nil {
+			return report, err
+		}
+		for _, drift := range drifts {
+			log.Warn().
+				Str("user_id", drift.UserID).
+				Float64("actual", drift.Actual).
+				Float64("expected", drift.Expected).
+				Float64("actual_withdrawn", drift.ActualWithdrawn).
+				Float64("expected_withdrawn", drift.ExpectedWithdrawn).
+				Msg("Balance drift")
+			report.Drifts = append(report.Drifts, drift)
+			if !fix {
+				continue
+			}
+			adjustment, err := adjustments.CorrectDrift(ctx, drift.UserID, reconcileReason, ReconcileActor)
+			if err != nil {
+				return report, err
+			}
+			if math.Abs(drift.ActualWithdrawn-drift.ExpectedWithdrawn) > withdrawnTolerance {
+				auditor.Record(ctx, audit.Event(ReconcileActor, models.AuditCorrectDrift, drift.UserID, map[string]any{
+					"withdrawn":          drift.ActualWithdrawn,
+					"expected_withdrawn": drift.ExpectedWithdrawn,
+					"reason":             reconcileReason,
+				}))
+			}
+			if adjustment != nil {
+				log.Info().Str("user_id", drift.UserID).Float64("amount", adjustment.Amount).Msg("Balance drift corrected")
+				auditor.Record(ctx, audit.Event(ReconcileActor, models.AuditCorrectDrift, drift.UserID, map[string]any{
+					"adjustment_id": adjustment.ID,
+					"amount":        adjustment.Amount,
+					"restores":      adjustment.Restores,
+					"reason":        adjustment.Reason,
+				}))
+				report.Corrected = append(report.Corrected, adjustment)
+			}
+		}
+		if next == "" {
+			return report, nil
+		}
+		after = next
+	}
+}
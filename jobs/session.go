@@ -0,0 +1,29 @@
+package jobs
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// StartSessionCleanup periodically removes expired server-side sessions
+func StartSessionCleanup(ctx context.Context, sessions pgadapter.SessionAdapter, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, err := sessions.DeleteExpiredSessions(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to delete expired sessions")
+			} else if n > 0 {
+				log.Info().Msgf("Deleted %d expired sessions", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// StartMonthlyStatements periodically generates statements of the last closed month.
+// Generation is idempotent, so checking often only costs a query once the month is done
+func StartMonthlyStatements(ctx context.Context, statements pgadapter.StatementAdapter, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			generateStatements(ctx, statements, lastClosedMonth(time.Now()))
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// generateStatements runs batches until every user has statement of the month
+func generateStatements(ctx context.Context, statements pgadapter.StatementAdapter, month time.Time) {
+	for {
+		n, err := statements.GenerateMonthlyStatements(ctx, month)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to generate statements of %s", month.Format(pgadapter.MonthLayout))
+			return
+		}
+		if n == 0 {
+			return
+		}
+		log.Info().Msgf("Generated %d statements of %s", n, month.Format(pgadapter.MonthLayout))
+	}
+}
+
+// lastClosedMonth returns start of the month before the current one in UTC
+func lastClosedMonth(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+}
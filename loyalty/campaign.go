@@ -0,0 +1,61 @@
+package loyalty
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+type Campaigns interface {
+	Apply(ctx context.Context, order *models.Order, accrual float64, householdID string) float64
+}
+
+// campaignService grants extra points of active campaigns to processed orders
+type campaignService struct {
+	campaigns pgadapter.CampaignAdapter
+}
+
+func NewCampaignService(campaigns pgadapter.CampaignAdapter) *campaignService {
+	return &campaignService{campaigns: campaigns}
+}
+
+// Apply credits contributions of all campaigns active now to household pool, if householdID isn't empty,
+// or to personal balance. Returns extra points credited on top of accrual.
+// Failing campaign is skipped, so order is still credited with what other campaigns granted
+func (s *campaignService) Apply(ctx context.Context, order *models.Order, accrual float64, householdID string) float64 {
+	now := time.Now()
+	active, err := s.campaigns.ReadActiveCampaigns(ctx, now)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read active campaigns")
+		return 0
+	}
+
+	var extra float64
+	for _, campaign := range active {
+		amount := campaign.Bonus
+		if campaign.Multiplier > 1 {
+			amount += accrual * (campaign.Multiplier - 1)
+		}
+		if amount <= 0 {
+			continue
+		}
+		granted, err := s.campaigns.Contribute(ctx, &models.CampaignContribution{
+			ID:          helpers.GenerateUUID(),
+			CampaignID:  campaign.ID,
+			UserID:      order.UserID,
+			OrderID:     order.ID,
+			Amount:      amount,
+			CreatedAt:   now,
+			HouseholdID: householdID,
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to apply campaign %s", campaign.ID)
+			continue
+		}
+		extra += granted
+	}
+	return extra
+}
@@ -0,0 +1,41 @@
+package loyalty
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+)
+
+type Referrals interface {
+	OrderProcessed(ctx context.Context, order *models.Order)
+}
+
+// referralService rewards referrals once referred user's first qualifying order is processed
+type referralService struct {
+	bonus      float64
+	limit      int
+	minAccrual float64
+	referrals  pgadapter.ReferralAdapter
+}
+
+func NewReferralService(bonus float64, limit int, minAccrual float64, referrals pgadapter.ReferralAdapter) *referralService {
+	return &referralService{
+		bonus:      bonus,
+		limit:      limit,
+		minAccrual: minAccrual,
+		referrals:  referrals,
+	}
+}
+
+// OrderProcessed credits bonus to both accounts if order owner was referred and hasn't been rewarded yet.
+// Orders with accrual below minimum do not qualify, so referral stays pending.
+// Claim and credits are committed together, so failed claim stays pending for the next order
+func (s *referralService) OrderProcessed(ctx context.Context, order *models.Order) {
+	if order.Accrual < s.minAccrual {
+		return
+	}
+	if _, err := s.referrals.ClaimReferral(ctx, order.UserID, s.bonus, s.limit); err != nil {
+		log.Error().Err(err).Msg("Failed to claim referral")
+	}
+}
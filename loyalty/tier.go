@@ -0,0 +1,103 @@
+package loyalty
+
+import (
+	"context"
+	"fmt"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Tiers interface {
+	Evaluate(ctx context.Context, userID string) (*models.UserTier, error)
+}
+
+// tierService computes tiers from accruals of processed orders over rolling window
+type tierService struct {
+	rules  []models.Tier
+	window time.Duration
+	orders pgadapter.OrderAdapter
+	tiers  pgadapter.TierAdapter
+}
+
+func NewTierService(rules []models.Tier, window time.Duration, orders pgadapter.OrderAdapter, tiers pgadapter.TierAdapter) *tierService {
+	return &tierService{
+		rules:  rules,
+		window: window,
+		orders: orders,
+		tiers:  tiers,
+	}
+}
+
+// ParseTiers parses rules in form "Silver:0:1,Gold:1000:1.05".
+// Rules are sorted by threshold, the lowest one must start from 0 so every user has a tier.
+// Multiplier below 1 would take from accrual of accrual system, so tiers may only add bonus
+func ParseTiers(spec string) ([]models.Tier, error) {
+	var rules []models.Tier
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 3 || fields[0] == "" {
+			return nil, fmt.Errorf("%w: %q", models.ErrorInvalidTiers, part)
+		}
+		threshold, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("%w: threshold %q", models.ErrorInvalidTiers, fields[1])
+		}
+		multiplier, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || multiplier < 1 {
+			return nil, fmt.Errorf("%w: multiplier %q", models.ErrorInvalidTiers, fields[2])
+		}
+		rules = append(rules, models.Tier{Name: fields[0], Threshold: threshold, Multiplier: multiplier})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Threshold < rules[j].Threshold
+	})
+	if rules[0].Threshold != 0 {
+		return nil, fmt.Errorf("%w: lowest tier must start from 0", models.ErrorInvalidTiers)
+	}
+	return rules, nil
+}
+
+// Evaluate computes current tier of user and persists it if tier has changed
+func (t *tierService) Evaluate(ctx context.Context, userID string) (*models.UserTier, error) {
+	accrued, err := t.orders.SumAccrual(ctx, userID, time.Now().Add(-t.window))
+	if err != nil {
+		return nil, err
+	}
+
+	current := &models.UserTier{Accrued: accrued}
+	for i, rule := range t.rules {
+		if accrued < rule.Threshold {
+			current.Next = &t.rules[i]
+			break
+		}
+		current.Tier = rule
+	}
+
+	last, err := t.tiers.ReadLastTierChange(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil && last.Tier == current.Tier.Name {
+		current.Since = last.ChangedAt
+		return current, nil
+	}
+
+	// First evaluation or tier has changed, concurrent evaluation may have recorded it already
+	last, err = t.tiers.CreateTierChange(ctx, &models.TierChange{
+		ID:        helpers.GenerateUUID(),
+		UserID:    userID,
+		Tier:      current.Tier.Name,
+		Accrued:   accrued,
+		ChangedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	current.Since = last.ChangedAt
+	return current, nil
+}
@@ -2,33 +2,41 @@ package middlwares
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt"
 	"github.com/gynshu-one/gophermart-loyalty-system/config"
 	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
 	"net/http"
+	"strings"
 	"time"
 )
 
-var jwtKey = []byte(config.GetConfig().Key)
-
 type Claims struct {
 	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	// SessionID is the token family the access token was issued for
+	SessionID string `json:"sid"`
 	jwt.StandardClaims
 }
 
-func GenerateJWT(userID string) (string, error) {
-	expirationTime := time.Now().Add(24 * 30 * time.Hour)
+// GenerateJWT issues short-lived access token of user with given role within session,
+// role is read again only when token is refreshed
+func GenerateJWT(userID, role, sessionID string) (string, error) {
+	expirationTime := time.Now().Add(config.GetConfig().AccessTokenTTL)
 
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := tokenKeys.Sign(claims)
 
 	if err != nil {
 		fmt.Printf("Something Went Wrong: %s", err.Error())
@@ -38,25 +46,81 @@ func GenerateJWT(userID string) (string, error) {
 	return tokenString, nil
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenStr, err := r.Cookie("Authorization")
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// errUnauthorized is returned by authorization of requests without valid credentials
+var errUnauthorized = errors.New("unauthorized")
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr.Value, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
+// AuthMiddleware authorizes requests by access token, see accessToken, or,
+// when AuthMode is session, by server-side session.
+// Tokens of revoked sessions are rejected, so logout takes effect immediately
+func AuthMiddleware(tokens pgadapter.TokenAdapter, sessions *Sessions) func(http.Handler) http.Handler {
+	authorize := func(r *http.Request) (context.Context, error) {
+		return authorizeToken(r, tokens)
+	}
+	if config.GetConfig().AuthMode == AuthModeSession {
+		authorize = sessions.authorize
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := authorize(r)
+			if errors.Is(err, errUnauthorized) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+				http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+// accessToken reads token from "Authorization: Bearer <token>" header or, if there is no such header,
+// from Authorization cookie, fromCookie tells which. Header always takes precedence: request with malformed header
+// isn't authorized even with valid cookie, so clients can't be confused about whose token was used
+func accessToken(r *http.Request) (token string, fromCookie bool, ok bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		token = strings.TrimSpace(token)
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", false, false
 		}
+		return token, false, true
+	}
+	cookie, err := r.Cookie(AuthCookie)
+	if err != nil || cookie.Value == "" {
+		return "", false, false
+	}
+	return cookie.Value, true, true
+}
+
+// authorizeToken puts user of valid access token into context
+func authorizeToken(r *http.Request, tokens pgadapter.TokenAdapter) (context.Context, error) {
+	tokenStr, fromCookie, ok := accessToken(r)
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	claims := &Claims{}
+	token, err := tokenKeys.Parse(tokenStr, claims)
+
+	// Tokens issued before sessions can't be revoked, so they aren't accepted anymore
+	if err != nil || !token.Valid || claims.SessionID == "" {
+		return nil, errUnauthorized
+	}
+
+	family, err := tokens.ReadFamily(r.Context(), claims.SessionID)
+	if errors.Is(err, models.ErrorNotFound) || (err == nil && family.RevokedAt != nil) {
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		ctx := context.WithValue(r.Context(), models.UserID, claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	ctx := context.WithValue(r.Context(), models.UserID, claims.UserID)
+	ctx = context.WithValue(ctx, models.Role, claims.Role)
+	ctx = context.WithValue(ctx, models.SessionID, claims.SessionID)
+	ctx = context.WithValue(ctx, models.CookieAuth, fromCookie)
+	return ctx, nil
 }
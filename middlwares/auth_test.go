@@ -0,0 +1,134 @@
+package middlwares
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockTokenAdapter struct {
+	family *models.TokenFamily
+	err    error
+}
+
+func (m mockTokenAdapter) CreateFamily(ctx context.Context, family *models.TokenFamily, token *models.RefreshToken) error {
+	return m.err
+}
+func (m mockTokenAdapter) RotateRefreshToken(ctx context.Context, hash string, next *models.RefreshToken) error {
+	return m.err
+}
+func (m mockTokenAdapter) RevokeFamily(ctx context.Context, familyID string) error {
+	return m.err
+}
+func (m mockTokenAdapter) RevokeUserFamilies(ctx context.Context, userID, exceptID string) error {
+	return m.err
+}
+func (m mockTokenAdapter) ReadFamily(ctx context.Context, familyID string) (*models.TokenFamily, error) {
+	return m.family, m.err
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	valid, err := GenerateJWT("user_id", models.RoleCustomer, "family_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedAt := time.Now()
+	active := mockTokenAdapter{family: &models.TokenFamily{ID: "family_id", UserID: "user_id"}}
+
+	tests := []struct {
+		name           string
+		header         string
+		cookie         string
+		tokens         mockTokenAdapter
+		wantStatusCode int
+	}{
+		{
+			name:           "Cookie",
+			cookie:         valid,
+			tokens:         active,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Bearer header",
+			header:         "Bearer " + valid,
+			tokens:         active,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Scheme is case insensitive",
+			header:         "bearer " + valid,
+			tokens:         active,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Header takes precedence over invalid cookie",
+			header:         "Bearer " + valid,
+			cookie:         "invalid",
+			tokens:         active,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Invalid header is not rescued by valid cookie",
+			header:         "Bearer invalid",
+			cookie:         valid,
+			tokens:         active,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Other scheme",
+			header:         "Basic dXNlcjpwYXNz",
+			cookie:         valid,
+			tokens:         active,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Empty bearer",
+			header:         "Bearer ",
+			tokens:         active,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "No credentials",
+			tokens:         active,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Revoked session by header",
+			header:         "Bearer " + valid,
+			tokens:         mockTokenAdapter{family: &models.TokenFamily{ID: "family_id", RevokedAt: &revokedAt}},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Revoked session by cookie",
+			cookie:         valid,
+			tokens:         mockTokenAdapter{err: models.ErrorNotFound},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUserID, _ = r.Context().Value(models.UserID).(string)
+			})
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "Authorization", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			AuthMiddleware(tt.tokens, nil)(next).ServeHTTP(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("AuthMiddleware() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code == http.StatusOK && gotUserID != "user_id" {
+				t.Errorf("AuthMiddleware() user = %v, want user_id", gotUserID)
+			}
+		})
+	}
+}
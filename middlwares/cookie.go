@@ -0,0 +1,72 @@
+package middlwares
+
+import (
+	"fmt"
+	"github.com/alexedwards/scs/v2"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"net/http"
+	"strings"
+)
+
+// AuthCookie carries access token to browsers in jwt auth mode
+const AuthCookie = "Authorization"
+
+// NewCookie makes HttpOnly cookie with Secure, SameSite, Path and Max-Age configured for all cookies of the server.
+// Cookies bound to own endpoints or lifetime override Path and MaxAge
+func NewCookie(name, value string) *http.Cookie {
+	sameSite, err := ParseSameSite(config.GetConfig().CookieSameSite)
+	if err != nil {
+		sameSite = http.SameSiteLaxMode
+	}
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     config.GetConfig().CookiePath,
+		HttpOnly: true,
+		Secure:   config.GetConfig().CookieSecure,
+		SameSite: sameSite,
+	}
+	if config.GetConfig().CookieMaxAge > 0 {
+		cookie.MaxAge = int(config.GetConfig().CookieMaxAge.Seconds())
+	}
+	return cookie
+}
+
+// ExpiredCookie removes cookie made by NewCookie, Path must be overridden the same way it was when cookie was set
+func ExpiredCookie(name string) *http.Cookie {
+	cookie := NewCookie(name, "")
+	cookie.MaxAge = -1
+	return cookie
+}
+
+// ConfigureSessionCookie checks cookie attributes of config and applies them to session cookie.
+// Session cookie lives as long as session if CookieMaxAge is set, scs has no separate cookie lifetime
+func ConfigureSessionCookie(cookie *scs.SessionCookie) error {
+	sameSite, err := ParseSameSite(config.GetConfig().CookieSameSite)
+	if err != nil {
+		return err
+	}
+	if sameSite == http.SameSiteNoneMode && !config.GetConfig().CookieSecure {
+		return fmt.Errorf("cookies with SameSite none must be secure")
+	}
+	cookie.HttpOnly = true
+	cookie.Path = config.GetConfig().CookiePath
+	cookie.Persist = config.GetConfig().CookieMaxAge > 0
+	cookie.SameSite = sameSite
+	cookie.Secure = config.GetConfig().CookieSecure
+	return nil
+}
+
+// ParseSameSite reads SameSite attribute given as lax, strict or none
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown SameSite %q, want lax, strict or none", value)
+	}
+}
@@ -0,0 +1,56 @@
+package middlwares
+
+import (
+	"crypto/subtle"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+)
+
+const (
+	// CSRFCookie gives scripts of the site CSRF token of session, it isn't HttpOnly so they can read it
+	CSRFCookie = "XSRF-TOKEN"
+	// CSRFHeader is where scripts send CSRF token back, other sites can't set it on cross-site requests
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRFToken is CSRF token of session, HMAC of its id, so it needs no storage and changes with every login
+func CSRFToken(sessionID string) string {
+	return helpers.HashCode(config.GetConfig().Key, "csrf:"+sessionID)
+}
+
+// SetCSRFCookie sends CSRF token of session to browser
+func SetCSRFCookie(w http.ResponseWriter, sessionID string) {
+	cookie := NewCookie(CSRFCookie, CSRFToken(sessionID))
+	cookie.HttpOnly = false
+	http.SetCookie(w, cookie)
+}
+
+// CSRFMiddleware checks double-submitted CSRF token of requests authorized by cookie, it must go after authentication.
+// Unsafe methods must have token of the session in X-CSRF-Token header, safe ones get the cookie back if it is missing.
+// Requests with bearer token or API key are left alone as browsers never add those on their own
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookieAuth, _ := r.Context().Value(models.CookieAuth).(bool)
+		sessionID, _ := r.Context().Value(models.SessionID).(string)
+		if !cookieAuth || sessionID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := CSRFToken(sessionID)
+		if cookie, err := r.Cookie(CSRFCookie); err != nil || cookie.Value != token {
+			SetCSRFCookie(w, sessionID)
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(CSRFHeader)), []byte(token)) != 1 {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package middlwares
+
+import (
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	valid, err := GenerateJWT("user_id", models.RoleCustomer, "family_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := mockTokenAdapter{family: &models.TokenFamily{ID: "family_id", UserID: "user_id"}}
+
+	tests := []struct {
+		name           string
+		method         string
+		bearer         bool
+		csrfCookie     string
+		csrfHeader     string
+		wantStatusCode int
+		wantCookie     bool
+	}{
+		{
+			name:           "Cookie with token",
+			method:         "POST",
+			csrfCookie:     CSRFToken("family_id"),
+			csrfHeader:     CSRFToken("family_id"),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Cookie without token",
+			method:         "POST",
+			csrfCookie:     CSRFToken("family_id"),
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Token of other session",
+			method:         "DELETE",
+			csrfCookie:     CSRFToken("other_id"),
+			csrfHeader:     CSRFToken("other_id"),
+			wantStatusCode: http.StatusForbidden,
+			wantCookie:     true,
+		},
+		{
+			name:           "Safe method gets token",
+			method:         "GET",
+			wantStatusCode: http.StatusOK,
+			wantCookie:     true,
+		},
+		{
+			name:           "Bearer needs no token",
+			method:         "POST",
+			bearer:         true,
+			wantStatusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := AuthMiddleware(active, nil)(CSRFMiddleware(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			r := httptest.NewRequest(tt.method, "/api/user/orders", nil)
+			if tt.bearer {
+				r.Header.Set("Authorization", "Bearer "+valid)
+			} else {
+				r.AddCookie(&http.Cookie{Name: AuthCookie, Value: valid})
+			}
+			if tt.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				r.Header.Set(CSRFHeader, tt.csrfHeader)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("CSRFMiddleware() error = %v, want %v", w.Code, tt.wantStatusCode)
+			}
+			var gotCookie *http.Cookie
+			for _, cookie := range w.Result().Cookies() {
+				if cookie.Name == CSRFCookie {
+					gotCookie = cookie
+				}
+			}
+			if (gotCookie != nil) != tt.wantCookie {
+				t.Fatalf("CSRFMiddleware() set cookie = %v, want %v", gotCookie, tt.wantCookie)
+			}
+			if gotCookie != nil && (gotCookie.Value != CSRFToken("family_id") || gotCookie.HttpOnly) {
+				t.Errorf("CSRFMiddleware() cookie = %+v, want readable token of family_id", gotCookie)
+			}
+		})
+	}
+}
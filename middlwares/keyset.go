@@ -0,0 +1,211 @@
+package middlwares
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// activeKeyFile in keys directory holds id of the key new tokens are signed with
+	activeKeyFile = "active"
+	// minRSABits is the shortest RSA key accepted
+	minRSABits = 2048
+)
+
+// tokenKeys signs and verifies access and MFA tokens, see UseKeySet
+var tokenKeys = NewSecretKeySet(config.GetConfig().Key)
+
+// UseKeySet makes tokens signed and verified with given keys, call it before serving requests
+func UseKeySet(keys *KeySet) {
+	tokenKeys = keys
+}
+
+// KeySet signs tokens with its active key and verifies them with any of its keys, found by kid header.
+// Keys are rotated without logging users out: new key is added to every instance first, made active
+// after that, and the old one is removed once tokens it signed have expired
+type KeySet struct {
+	dir string
+
+	mu     sync.RWMutex
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// signingKey is key of the set, verification-only keys have no private part
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private any
+	public  any
+}
+
+// NewSecretKeySet is HS256 set of single secret without key id. The secret can't be published,
+// so the set has no JWKS and only this server can verify its tokens
+func NewSecretKeySet(secret string) *KeySet {
+	key := &signingKey{method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+	return &KeySet{active: key, keys: map[string]*signingKey{"": key}}
+}
+
+// LoadKeySet reads keys from PEM files of dir, name of file without .pem extension is key id.
+// Private keys, RSA for RS256 or Ed25519 for EdDSA, sign and verify, public keys only verify.
+// File named active holds id of signing key, it may be left out if there is only one private key
+func LoadKeySet(dir string) (*KeySet, error) {
+	set := &KeySet{dir: dir}
+	if err := set.Reload(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Reload reads keys from directory again, on error the keys in use are kept
+func (k *KeySet) Reload() error {
+	if k.dir == "" {
+		return errors.New("key set has no directory")
+	}
+	files, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*signingKey, len(files))
+	var private []string
+	for _, file := range files {
+		key, err := readKeyFile(file)
+		if err != nil {
+			return fmt.Errorf("read key %s: %w", file, err)
+		}
+		keys[key.id] = key
+		if key.private != nil {
+			private = append(private, key.id)
+		}
+	}
+
+	activeID := ""
+	content, err := os.ReadFile(filepath.Join(k.dir, activeKeyFile))
+	switch {
+	case err == nil:
+		activeID = strings.TrimSpace(string(content))
+	case errors.Is(err, os.ErrNotExist) && len(private) == 1:
+		activeID = private[0]
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%d private keys in %s, name the one to sign with in %s file", len(private), k.dir, activeKeyFile)
+	default:
+		return err
+	}
+	active, ok := keys[activeID]
+	if !ok || active.private == nil {
+		return fmt.Errorf("active key %q has no private key in %s", activeID, k.dir)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active, k.keys = active, keys
+	return nil
+}
+
+// Sign issues token of claims signed by active key
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key := k.active
+	k.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.id != "" {
+		token.Header["kid"] = key.id
+	}
+	return token.SignedString(key.private)
+}
+
+// Parse verifies token by key of its kid into claims. Key only verifies tokens of its own algorithm,
+// so public key can't be passed off as HMAC secret
+func (k *KeySet) Parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		k.mu.RLock()
+		key, ok := k.keys[kid]
+		k.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("key %q doesn't sign %s", kid, token.Method.Alg())
+		}
+		return key.public, nil
+	})
+}
+
+// JWKS publishes public keys of the set, sorted by id, so others can verify its tokens
+func (k *KeySet) JWKS() helpers.JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := helpers.JWKSet{Keys: []helpers.JWK{}}
+	for _, key := range k.keys {
+		if key.id == "" {
+			continue
+		}
+		jwk, err := helpers.NewJWK(key.id, key.method.Alg(), key.public)
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// readKeyFile parses PEM private key in PKCS#8 or PKCS#1 form, or public key in PKIX form
+func readKeyFile(file string) (*signingKey, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	key := &signingKey{id: strings.TrimSuffix(filepath.Base(file), ".pem")}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, parsed, &parsed.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, parsed
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, parsed, parsed.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, parsed
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	if public, ok := key.public.(*rsa.PublicKey); ok && public.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key shorter than %d bits", minRSABits)
+	}
+	return key, nil
+}
@@ -0,0 +1,220 @@
+package middlwares
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKey writes PKCS#8 private key, or PKIX public key if public, to dir as <kid>.pem
+func writeKey(t *testing.T, dir, kid string, key any, public bool) {
+	var block *pem.Block
+	if public {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeActive(t *testing.T, dir, kid string) {
+	if err := os.WriteFile(filepath.Join(dir, activeKeyFile), []byte(kid+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClaims() *Claims {
+	return &Claims{
+		UserID:         "user_id",
+		SessionID:      "family_id",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()},
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "k1", rsaKey, false)
+	writeKey(t, dir, "k2", edKey, false)
+	writeKey(t, dir, "k3", &otherKey.PublicKey, true)
+
+	if _, err = LoadKeySet(dir); err == nil {
+		t.Fatal("LoadKeySet() of two private keys without active one succeeded")
+	}
+	writeActive(t, dir, "k1")
+	keys, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := keys.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := keys.Parse(first, &Claims{})
+	if err != nil || token.Header["kid"] != "k1" || token.Method.Alg() != "RS256" {
+		t.Fatalf("KeySet.Parse() = %v, %v, want RS256 token of k1", token, err)
+	}
+	if set := keys.JWKS(); len(set.Keys) != 3 || set.Keys[0].KeyID != "k1" || set.Keys[1].KeyType != "OKP" || set.Keys[2].KeyID != "k3" {
+		t.Errorf("KeySet.JWKS() = %+v, want k1, k2 and k3", set)
+	}
+
+	// Switch to k2, tokens of k1 are still good
+	writeActive(t, dir, "k2")
+	if err = keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := keys.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err = keys.Parse(second, &Claims{}); err != nil || token.Header["kid"] != "k2" || token.Method.Alg() != "EdDSA" {
+		t.Fatalf("KeySet.Parse() = %v, %v, want EdDSA token of k2", token, err)
+	}
+	if _, err = keys.Parse(first, &Claims{}); err != nil {
+		t.Errorf("KeySet.Parse() of token of previous key = %v", err)
+	}
+
+	// Retire k1
+	if err = os.Remove(filepath.Join(dir, "k1.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err = keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = keys.Parse(first, &Claims{}); err == nil {
+		t.Error("KeySet.Parse() of token of removed key succeeded")
+	}
+
+	// Broken reload keeps keys in use
+	writeActive(t, dir, "k3")
+	if err = keys.Reload(); err == nil {
+		t.Error("KeySet.Reload() to verification-only key succeeded")
+	}
+	if _, err = keys.Parse(second, &Claims{}); err != nil {
+		t.Errorf("KeySet.Parse() after failed reload = %v", err)
+	}
+}
+
+func TestKeySet_Parse(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "k1", rsaKey, false)
+	keys, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key any) string {
+		token := jwt.NewWithClaims(method, testClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "Valid", token: sign(jwt.SigningMethodRS256, "k1", rsaKey), want: true},
+		{name: "Public key as HMAC secret", token: sign(jwt.SigningMethodHS256, "k1", publicDER)},
+		{name: "Unknown key", token: sign(jwt.SigningMethodRS256, "k9", rsaKey)},
+		{name: "No key id", token: sign(jwt.SigningMethodRS256, "", rsaKey)},
+		{name: "Signed by other key", token: sign(jwt.SigningMethodRS256, "k1", otherKey)},
+		{name: "Secret of legacy key", token: sign(jwt.SigningMethodHS256, "", []byte("secret"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := keys.Parse(tt.token, &Claims{})
+			if got := err == nil && token.Valid; got != tt.want {
+				t.Errorf("KeySet.Parse() valid = %v, want %v: %v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestUseKeySet(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "k1", edKey, false)
+	keys, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := GenerateJWT("user_id", models.RoleCustomer, "family_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := tokenKeys
+	UseKeySet(keys)
+	t.Cleanup(func() { UseKeySet(previous) })
+
+	valid, err := GenerateJWT("user_id", models.RoleCustomer, "family_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := AuthMiddleware(mockTokenAdapter{family: &models.TokenFamily{ID: "family_id", UserID: "user_id"}}, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for token, want := range map[string]int{valid: http.StatusOK, legacy: http.StatusUnauthorized} {
+		r := httptest.NewRequest("GET", "/api/user/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("AuthMiddleware() error = %v, want %v", w.Code, want)
+		}
+	}
+	if _, ok := ParseMFAToken(legacy, MFAPurposeVerify); ok {
+		t.Error("ParseMFAToken() accepted token of replaced key")
+	}
+}
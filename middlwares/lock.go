@@ -0,0 +1,39 @@
+package middlwares
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// LockMiddleware rejects requests of locked accounts, it goes after AuthMiddleware or AdminMiddleware,
+// so tokens issued before the lock stop working immediately. Admin key has no account and is let through
+func LockMiddleware(users pgadapter.UserAdapter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value(models.UserID).(string)
+			if userID == AdminKeyActor {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, err := users.ReadUserByID(r.Context(), userID)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+				http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+				return
+			}
+			if user.LockedAt != nil {
+				http.Error(w, "Account locked", http.StatusLocked)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package middlwares
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// MerchantKeyMiddleware authorizes merchant backends by API key given in X-API-Key header,
+// the key must have given scope. Id of the key is put into context and its last use is tracked
+func MerchantKeyMiddleware(keys pgadapter.MerchantKeyAdapter, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := r.Header.Get("X-API-Key")
+			if given == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			key, err := keys.ReadMerchantKeyByHash(r.Context(), HashMerchantKey(given))
+			if errors.Is(err, models.ErrorNotFound) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Debug().Msgf("%s: %v", models.ErrorInternal.Error(), err)
+				http.Error(w, models.ErrorInternal.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !key.HasScope(scope) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if err = keys.TouchMerchantKey(r.Context(), key.ID); err != nil {
+				log.Error().Err(err).Msgf("Failed to track use of merchant key %s", key.ID)
+			}
+			ctx := context.WithValue(r.Context(), models.MerchantKeyID, key.ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// HashMerchantKey returns hash merchant key is stored and looked up by
+func HashMerchantKey(key string) string {
+	return helpers.HashToken(key)
+}
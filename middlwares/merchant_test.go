@@ -0,0 +1,94 @@
+package middlwares
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mockMerchantKeyAdapter struct {
+	key     *models.MerchantKey
+	touched *[]string
+}
+
+func (m mockMerchantKeyAdapter) CreateMerchantKey(ctx context.Context, key *models.MerchantKey) error {
+	return nil
+}
+func (m mockMerchantKeyAdapter) ReadMerchantKeys(ctx context.Context) ([]*models.MerchantKey, error) {
+	return nil, nil
+}
+func (m mockMerchantKeyAdapter) ReadMerchantKeyByHash(ctx context.Context, hash string) (*models.MerchantKey, error) {
+	if m.key == nil || m.key.Hash != hash {
+		return nil, models.ErrorNotFound
+	}
+	return m.key, nil
+}
+func (m mockMerchantKeyAdapter) RevokeMerchantKey(ctx context.Context, id string) error {
+	return nil
+}
+func (m mockMerchantKeyAdapter) TouchMerchantKey(ctx context.Context, id string) error {
+	*m.touched = append(*m.touched, id)
+	return nil
+}
+
+func TestMerchantKeyMiddleware(t *testing.T) {
+	key := &models.MerchantKey{ID: "key_id", Hash: HashMerchantKey("gm_key"), Scopes: []string{models.ScopeOrdersWrite}}
+	unscoped := &models.MerchantKey{ID: "key_id", Hash: HashMerchantKey("gm_key")}
+
+	tests := []struct {
+		name           string
+		header         string
+		key            *models.MerchantKey
+		wantStatusCode int
+	}{
+		{
+			name:           "Valid key",
+			header:         "gm_key",
+			key:            key,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "No key",
+			key:            key,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Unknown or revoked key",
+			header:         "gm_other",
+			key:            key,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Key without scope",
+			header:         "gm_key",
+			key:            unscoped,
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var touched []string
+			var gotKeyID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotKeyID, _ = r.Context().Value(models.MerchantKeyID).(string)
+			})
+			r := httptest.NewRequest("POST", "/orders", nil)
+			if tt.header != "" {
+				r.Header.Set("X-API-Key", tt.header)
+			}
+			w := httptest.NewRecorder()
+			MerchantKeyMiddleware(mockMerchantKeyAdapter{key: tt.key, touched: &touched}, models.ScopeOrdersWrite)(next).ServeHTTP(w, r)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("MerchantKeyMiddleware() error = %v, wantErr %v", w.Code, tt.wantStatusCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if gotKeyID != "key_id" || len(touched) != 1 {
+				t.Errorf("MerchantKeyMiddleware() key = %v, touched %v", gotKeyID, touched)
+			}
+		})
+	}
+}
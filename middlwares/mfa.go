@@ -0,0 +1,72 @@
+package middlwares
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"net/http"
+	"time"
+)
+
+// Purposes of tokens given at login that waits for second factor
+const (
+	MFAPurposeVerify = "mfa_verify"
+	MFAPurposeEnroll = "mfa_enroll"
+)
+
+// MFAClaims are claims of token proving that user has passed the password step of login.
+// It has no session, so it is never accepted as access token
+type MFAClaims struct {
+	UserID  string `json:"user_id"`
+	Purpose string `json:"purpose"`
+	jwt.StandardClaims
+}
+
+// GenerateMFAToken issues token of login waiting for second factor, it lives for MFATokenTTL
+func GenerateMFAToken(userID, purpose string) (string, error) {
+	claims := &MFAClaims{
+		UserID:  userID,
+		Purpose: purpose,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(config.GetConfig().MFATokenTTL).Unix(),
+		},
+	}
+	return tokenKeys.Sign(claims)
+}
+
+// ParseMFAToken returns user of valid token issued for given purpose
+func ParseMFAToken(tokenStr, purpose string) (string, bool) {
+	claims := &MFAClaims{}
+	token, err := tokenKeys.Parse(tokenStr, claims)
+	if err != nil || !token.Valid || claims.Purpose != purpose || claims.UserID == "" {
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+// MFAEnrollMiddleware lets users who must have second factor enroll in the middle of login,
+// authorizing them by enrollment token given in X-MFA-Token header. Requests without the header
+// are authorized by AuthMiddleware
+func MFAEnrollMiddleware(tokens pgadapter.TokenAdapter, sessions *Sessions) func(http.Handler) http.Handler {
+	auth := AuthMiddleware(tokens, sessions)
+	return func(next http.Handler) http.Handler {
+		authorized := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := r.Header.Get("X-MFA-Token")
+			if given == "" {
+				authorized.ServeHTTP(w, r)
+				return
+			}
+			userID, ok := ParseMFAToken(given, MFAPurposeEnroll)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), models.UserID, userID)
+			ctx = context.WithValue(ctx, models.MFAEnrollment, true)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package middlwares
+
+import (
+	"fmt"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route groups with separate rate limits
+const (
+	RateLimitAuth     = "auth"
+	RateLimitUser     = "user"
+	RateLimitOrders   = "orders"
+	RateLimitMerchant = "merchant"
+	RateLimitAdmin    = "admin"
+)
+
+// RateLimit allows Limit requests within Window, zero Limit means unlimited
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRateLimits parses limits in form "user:120/1m,orders:10/1m".
+// Unknown groups are rejected, so misspelled ones don't silently stay unlimited
+func ParseRateLimits(spec string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	if strings.TrimSpace(spec) == "" {
+		return limits, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		group, rule, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || !knownRateLimitGroup(group) {
+			return nil, fmt.Errorf("%w: %q", models.ErrorInvalidRateLimits, part)
+		}
+		count, period, ok := strings.Cut(rule, "/")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", models.ErrorInvalidRateLimits, part)
+		}
+		limit, err := strconv.Atoi(count)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("%w: limit %q", models.ErrorInvalidRateLimits, count)
+		}
+		window, err := time.ParseDuration(period)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("%w: window %q", models.ErrorInvalidRateLimits, period)
+		}
+		limits[group] = RateLimit{Limit: limit, Window: window}
+	}
+	return limits, nil
+}
+
+// RateLimitMiddleware lets each client make at most limit requests within window and tells it
+// how much is left in RateLimit-* headers. Client is user authorized by preceding middleware,
+// merchant key or, for anonymous requests, IP. Each call counts on its own, so route groups don't share limits,
+// and counters are kept in memory of each instance
+func RateLimitMiddleware(limit RateLimit) func(http.Handler) http.Handler {
+	if limit.Limit <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	limiter := helpers.NewLimiter(limit.Limit, limit.Window)
+	policy := fmt.Sprintf("%d;w=%d", limit.Limit, int(limit.Window.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, remaining, reset := limiter.Take(rateLimitClient(r))
+			resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+			w.Header().Set("RateLimit-Policy", policy)
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", resetSeconds)
+			if !ok {
+				w.Header().Set("Retry-After", resetSeconds)
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitClient is who request is counted for
+func rateLimitClient(r *http.Request) string {
+	if userID, _ := r.Context().Value(models.UserID).(string); userID != "" {
+		return "user:" + userID
+	}
+	if keyID, _ := r.Context().Value(models.MerchantKeyID).(string); keyID != "" {
+		return "merchant:" + keyID
+	}
+	return "ip:" + helpers.ClientIP(r)
+}
+
+func knownRateLimitGroup(group string) bool {
+	switch group {
+	case RateLimitAuth, RateLimitUser, RateLimitOrders, RateLimitMerchant, RateLimitAdmin:
+		return true
+	}
+	return false
+}
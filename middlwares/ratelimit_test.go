@@ -0,0 +1,141 @@
+package middlwares
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]RateLimit
+		wantErr bool
+	}{
+		{
+			name: "Groups",
+			spec: "user:120/1m, orders:10/30s",
+			want: map[string]RateLimit{
+				RateLimitUser:   {Limit: 120, Window: time.Minute},
+				RateLimitOrders: {Limit: 10, Window: 30 * time.Second},
+			},
+		},
+		{
+			name: "Empty",
+			spec: "",
+			want: map[string]RateLimit{},
+		},
+		{
+			name:    "Unknown group",
+			spec:    "users:120/1m",
+			wantErr: true,
+		},
+		{
+			name:    "No window",
+			spec:    "user:120",
+			wantErr: true,
+		},
+		{
+			name:    "Bad window",
+			spec:    "user:120/0s",
+			wantErr: true,
+		},
+		{
+			name:    "Negative limit",
+			spec:    "user:-1/1m",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRateLimits(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRateLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, models.ErrorInvalidRateLimits) {
+					t.Errorf("ParseRateLimits() error = %v, want %v", err, models.ErrorInvalidRateLimits)
+				}
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseRateLimits() = %v, want %v", got, tt.want)
+			}
+			for group, limit := range tt.want {
+				if got[group] != limit {
+					t.Errorf("ParseRateLimits() %s = %v, want %v", group, got[group], limit)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	type request struct {
+		userID         string
+		remoteAddr     string
+		wantStatusCode int
+		wantRemaining  string
+	}
+	tests := []struct {
+		name     string
+		limit    RateLimit
+		requests []request
+	}{
+		{
+			name:  "Limited per user",
+			limit: RateLimit{Limit: 2, Window: time.Minute},
+			requests: []request{
+				{userID: "first", remoteAddr: "10.0.0.1:1", wantStatusCode: http.StatusOK, wantRemaining: "1"},
+				{userID: "first", remoteAddr: "10.0.0.2:1", wantStatusCode: http.StatusOK, wantRemaining: "0"},
+				{userID: "first", remoteAddr: "10.0.0.3:1", wantStatusCode: http.StatusTooManyRequests, wantRemaining: "0"},
+				{userID: "second", remoteAddr: "10.0.0.1:1", wantStatusCode: http.StatusOK, wantRemaining: "1"},
+			},
+		},
+		{
+			name:  "Anonymous limited per IP",
+			limit: RateLimit{Limit: 1, Window: time.Minute},
+			requests: []request{
+				{remoteAddr: "10.0.0.1:1", wantStatusCode: http.StatusOK, wantRemaining: "0"},
+				{remoteAddr: "10.0.0.1:2", wantStatusCode: http.StatusTooManyRequests, wantRemaining: "0"},
+				{remoteAddr: "10.0.0.2:1", wantStatusCode: http.StatusOK, wantRemaining: "0"},
+			},
+		},
+		{
+			name:  "Unlimited",
+			limit: RateLimit{},
+			requests: []request{
+				{remoteAddr: "10.0.0.1:1", wantStatusCode: http.StatusOK},
+				{remoteAddr: "10.0.0.1:1", wantStatusCode: http.StatusOK},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limited := RateLimitMiddleware(tt.limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			for i, req := range tt.requests {
+				r := httptest.NewRequest("POST", "/orders", nil)
+				r.RemoteAddr = req.remoteAddr
+				if req.userID != "" {
+					r = r.WithContext(context.WithValue(r.Context(), models.UserID, req.userID))
+				}
+				w := httptest.NewRecorder()
+				limited.ServeHTTP(w, r)
+				if w.Code != req.wantStatusCode {
+					t.Errorf("RateLimitMiddleware() request %d error = %v, wantErr %v", i, w.Code, req.wantStatusCode)
+				}
+				if got := w.Header().Get("RateLimit-Remaining"); got != req.wantRemaining {
+					t.Errorf("RateLimit-Remaining of request %d = %q, want %q", i, got, req.wantRemaining)
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Errorf("RateLimitMiddleware() request %d has no Retry-After", i)
+				}
+			}
+		})
+	}
+}
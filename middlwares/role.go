@@ -0,0 +1,22 @@
+package middlwares
+
+import (
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/http"
+)
+
+// RequireRole lets through only requests whose role is one of given, it goes after AuthMiddleware
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value(models.Role).(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
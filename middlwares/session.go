@@ -0,0 +1,94 @@
+package middlwares
+
+import (
+	"context"
+	"errors"
+	"github.com/alexedwards/scs/v2"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/gynshu-one/gophermart-loyalty-system/pgadapter"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+// Authentication modes, see config AuthMode
+const (
+	AuthModeJWT     = "jwt"
+	AuthModeSession = "session"
+)
+
+// Keys of session data
+const (
+	sessionUserID = "user_id"
+	sessionRole   = "role"
+	sessionID     = "session_id"
+)
+
+// Sessions keeps server-side sessions in scs manager backed by Postgres store.
+// Manager's LoadAndSave must be mounted before AuthMiddleware
+type Sessions struct {
+	manager *scs.SessionManager
+	store   pgadapter.SessionAdapter
+}
+
+func NewSessions(manager *scs.SessionManager, store pgadapter.SessionAdapter) *Sessions {
+	manager.Store = store
+	return &Sessions{manager: manager, store: store}
+}
+
+// Start logs user in within current request and returns id of the session, token is renewed so session can't be fixated
+func (s *Sessions) Start(ctx context.Context, r *http.Request, user *models.User) (string, error) {
+	if err := s.manager.RenewToken(ctx); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	session := &models.Session{
+		ID:         helpers.GenerateUUID(),
+		Token:      s.manager.Token(ctx),
+		UserID:     user.ID,
+		Device:     r.UserAgent(),
+		IP:         helpers.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	if err := s.store.CreateSession(ctx, session); err != nil {
+		return "", err
+	}
+	s.manager.Put(ctx, sessionUserID, user.ID)
+	s.manager.Put(ctx, sessionRole, user.Role)
+	s.manager.Put(ctx, sessionID, session.ID)
+	return session.ID, nil
+}
+
+// End logs out of current session
+func (s *Sessions) End(ctx context.Context) error {
+	userID := s.manager.GetString(ctx, sessionUserID)
+	id := s.manager.GetString(ctx, sessionID)
+	if err := s.store.DeleteSession(ctx, userID, id); err != nil && !errors.Is(err, models.ErrorNotFound) {
+		return err
+	}
+	return s.manager.Destroy(ctx)
+}
+
+// EndUserSessions logs user out of all sessions but exceptID, which may be empty
+func (s *Sessions) EndUserSessions(ctx context.Context, userID, exceptID string) error {
+	return s.store.DeleteUserSessions(ctx, userID, exceptID)
+}
+
+// authorize puts user of current session into context, revoked sessions have no data and are rejected
+func (s *Sessions) authorize(r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	userID := s.manager.GetString(ctx, sessionUserID)
+	if userID == "" {
+		return nil, errUnauthorized
+	}
+	if err := s.store.TouchSession(ctx, s.manager.Token(ctx)); err != nil {
+		log.Error().Err(err).Msg("Failed to update session last seen")
+	}
+	ctx = context.WithValue(ctx, models.UserID, userID)
+	ctx = context.WithValue(ctx, models.Role, s.manager.GetString(ctx, sessionRole))
+	ctx = context.WithValue(ctx, models.SessionID, s.manager.GetString(ctx, sessionID))
+	ctx = context.WithValue(ctx, models.CookieAuth, true)
+	return ctx, nil
+}
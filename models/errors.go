@@ -9,4 +9,24 @@ var (
 	ErrorRequestLimitExceeded = errors.New("request limit exceeded")
 	ErrorServiceInternalError = errors.New("loyalty service internal error")
 	ErrorInsufficientFunds    = errors.New("insufficient funds")
+	ErrorInvalidTiers         = errors.New("invalid loyalty tiers")
+	ErrorUnknownReferralCode  = errors.New("unknown referral code")
+	ErrorNotFound             = errors.New("not found")
+	ErrorVoucherExpired       = errors.New("voucher expired")
+	ErrorVoucherExhausted     = errors.New("voucher has no redemptions left")
+	ErrorTransferLimit        = errors.New("daily transfer limit exceeded")
+	ErrorAlreadyInHousehold   = errors.New("user already belongs to a household")
+	ErrorOrderProcessed       = errors.New("order is already processed")
+	ErrorSessionRevoked       = errors.New("session revoked")
+	ErrorTokenReused          = errors.New("refresh token reused")
+	ErrorInvalidRateLimits    = errors.New("invalid rate limits")
+	ErrorMFAEnabled           = errors.New("two-factor authentication is already enabled")
+	ErrorWeakPassword         = errors.New("password doesn't meet policy")
+	ErrorInvalidIDToken       = errors.New("invalid id token")
+	ErrorIdentityLinked       = errors.New("external identity is already linked")
+	ErrorLoginLocked          = errors.New("too many failed logins, account is temporarily locked")
+	ErrorOrderRegistered      = errors.New("order is already registered")
+	ErrorInvitationPending    = errors.New("user is already invited to the household")
+	ErrorHouseholdNotEmpty    = errors.New("household pool is not empty")
+	ErrorReservedLogin        = errors.New("login can't contain ':'")
 )
@@ -1,11 +1,18 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"github.com/lib/pq"
+	"time"
+)
 
 type User struct {
-	ID       string `json:"id" db:"id" `
-	Login    string `json:"login" db:"login" `
-	Password string `json:"password" db:"password" `
+	ID           string     `json:"id" db:"id" `
+	Login        string     `json:"login" db:"login" `
+	Password     string     `json:"password" db:"password" `
+	ReferralCode string     `json:"-" db:"referral_code"`
+	LockedAt     *time.Time `json:"-" db:"locked_at"`
+	Role         string     `json:"-" db:"role"`
 }
 type Balance struct {
 	ID        string  `json:"id" db:"id"`
@@ -13,6 +20,192 @@ type Balance struct {
 	Amount    float64 `json:"amount" db:"amount"`
 	Withdrawn float64 `json:"withdrawn" db:"withdrawn"`
 }
+type ResponseBalance struct {
+	Current      float64                   `json:"current"`
+	Withdrawn    float64                   `json:"withdrawn"`
+	ExpiringSoon float64                   `json:"expiring_soon"`
+	Expiring     []*ExpiringPoints         `json:"expiring,omitempty"`
+	Household    *ResponseHouseholdBalance `json:"household,omitempty"`
+}
+type ResponseHouseholdBalance struct {
+	Name      string  `json:"name"`
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+// PointLot is a portion of points credited at once, withdrawals consume lots FIFO
+type PointLot struct {
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	Source    string     `json:"source" db:"source"`
+	Reference string     `json:"reference" db:"reference"`
+	Amount    float64    `json:"amount" db:"amount"`
+	Remaining float64    `json:"remaining" db:"remaining"`
+	AccruedAt time.Time  `json:"accrued_at" db:"accrued_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	// HouseholdID is household pool lot belongs to, empty for lots of personal balance
+	HouseholdID string `json:"household_id,omitempty" db:"household_id"`
+}
+
+// ExpiringPoints is the amount of points expiring at the same day
+type ExpiringPoints struct {
+	Amount    float64   `json:"amount" db:"amount"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// Tier is a loyalty level reached when accrued points within rolling window exceed Threshold
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  float64 `json:"threshold"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// TierChange is persisted each time user moves to another tier
+type TierChange struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	Tier      string    `json:"tier" db:"tier"`
+	Accrued   float64   `json:"accrued" db:"accrued"`
+	ChangedAt time.Time `json:"changed_at" db:"changed_at"`
+}
+
+// UserTier is current tier of user with progress to the next one
+type UserTier struct {
+	Tier    Tier
+	Next    *Tier
+	Accrued float64
+	Since   time.Time
+}
+type ResponseProfile struct {
+	Login      string    `json:"login"`
+	Tier       string    `json:"tier"`
+	TierSince  time.Time `json:"tier_since"`
+	Multiplier float64   `json:"multiplier"`
+	Accrued    float64   `json:"accrued"`
+	NextTier   string    `json:"next_tier,omitempty"`
+	ToNextTier float64   `json:"to_next_tier,omitempty"`
+}
+type Referral struct {
+	ID            string     `json:"-" db:"id"`
+	ReferrerID    string     `json:"-" db:"referrer_id"`
+	ReferredID    string     `json:"-" db:"referred_id"`
+	ReferredLogin string     `json:"login" db:"referred_login"`
+	Status        string     `json:"status" db:"status"`
+	Bonus         float64    `json:"bonus" db:"bonus"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	RewardedAt    *time.Time `json:"rewarded_at,omitempty" db:"rewarded_at"`
+}
+type ResponseReferrals struct {
+	Code      string      `json:"code"`
+	Earned    float64     `json:"earned"`
+	Referrals []*Referral `json:"referrals"`
+}
+
+// Campaign grants extra points for orders processed within its time window
+// Extra is accrual*(Multiplier-1)+Bonus limited by PerUserCap and Budget, zero limit means unlimited
+type Campaign struct {
+	ID         string    `json:"id" db:"id"`
+	Name       string    `json:"name" db:"name"`
+	StartsAt   time.Time `json:"starts_at" db:"starts_at"`
+	EndsAt     time.Time `json:"ends_at" db:"ends_at"`
+	Multiplier float64   `json:"multiplier" db:"multiplier"`
+	Bonus      float64   `json:"bonus" db:"bonus"`
+	PerUserCap float64   `json:"per_user_cap" db:"per_user_cap"`
+	Budget     float64   `json:"budget" db:"budget"`
+	Spent      float64   `json:"spent" db:"spent"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// CampaignContribution records how many points campaign added to an order
+type CampaignContribution struct {
+	ID         string    `json:"id" db:"id"`
+	CampaignID string    `json:"campaign_id" db:"campaign_id"`
+	UserID     string    `json:"user_id" db:"user_id"`
+	OrderID    string    `json:"order_id" db:"order_id"`
+	Amount     float64   `json:"amount" db:"amount"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	// HouseholdID is pool contribution is credited to, personal balance is credited if it is empty
+	HouseholdID string `json:"-" db:"-"`
+}
+
+// Voucher is worth Points for each of up to MaxRedemptions users, only hash of its code is stored
+type Voucher struct {
+	ID             string     `json:"id" db:"id"`
+	Code           string     `json:"code,omitempty" db:"-"`
+	CodeHash       string     `json:"-" db:"code_hash"`
+	Points         float64    `json:"points" db:"points"`
+	MaxRedemptions int        `json:"max_redemptions" db:"max_redemptions"`
+	Redemptions    int        `json:"redemptions" db:"redemptions"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+type VoucherRedemption struct {
+	ID         string    `json:"-" db:"id"`
+	VoucherID  string    `json:"-" db:"voucher_id"`
+	UserID     string    `json:"-" db:"user_id"`
+	Points     float64   `json:"points" db:"points"`
+	RedeemedAt time.Time `json:"redeemed_at" db:"redeemed_at"`
+}
+type Transfer struct {
+	ID            string    `json:"id" db:"id"`
+	SenderID      string    `json:"-" db:"sender_id"`
+	SenderLogin   string    `json:"-" db:"sender_login"`
+	ReceiverID    string    `json:"-" db:"receiver_id"`
+	ReceiverLogin string    `json:"-" db:"receiver_login"`
+	Amount        float64   `json:"sum" db:"amount"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+type ResponseTransfer struct {
+	Direction string    `json:"direction"`
+	Login     string    `json:"login"`
+	Sum       float64   `json:"sum"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Household has a shared pool of points, members may accrue into and withdraw from it
+type Household struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	OwnerID   string    `json:"-" db:"owner_id"`
+	Amount    float64   `json:"current" db:"amount"`
+	Withdrawn float64   `json:"withdrawn" db:"withdrawn"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// HouseholdMember accrues into household pool instead of personal balance when Pooling is on
+type HouseholdMember struct {
+	HouseholdID string    `json:"-" db:"household_id"`
+	UserID      string    `json:"-" db:"user_id"`
+	Login       string    `json:"login" db:"login"`
+	Role        string    `json:"role" db:"role"`
+	Pooling     bool      `json:"pooling" db:"pooling"`
+	JoinedAt    time.Time `json:"joined_at" db:"joined_at"`
+}
+type HouseholdInvitation struct {
+	ID            string    `json:"id" db:"id"`
+	HouseholdID   string    `json:"-" db:"household_id"`
+	HouseholdName string    `json:"household" db:"household_name"`
+	UserID        string    `json:"-" db:"user_id"`
+	InvitedBy     string    `json:"-" db:"invited_by"`
+	Status        string    `json:"status" db:"status"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// HouseholdEntry is a movement of household pool points
+type HouseholdEntry struct {
+	ID          string    `json:"-" db:"id"`
+	HouseholdID string    `json:"-" db:"household_id"`
+	UserID      string    `json:"-" db:"user_id"`
+	Kind        string    `json:"kind" db:"kind"`
+	OrderID     string    `json:"order" db:"order_id"`
+	Amount      float64   `json:"sum" db:"amount"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+type ResponseHousehold struct {
+	*Household
+	Role    string             `json:"role"`
+	Members []*HouseholdMember `json:"members"`
+}
 type Order struct {
 	ID         string    `json:"id" db:"id"`
 	UserID     string    `json:"user_id" db:"user_id"`
@@ -33,11 +226,374 @@ type Withdrawal struct {
 	OrderID     string    `json:"order" db:"order_id"`
 	Sum         float64   `json:"sum" db:"sum"`
 	ProcessedAt time.Time `json:"processed_at" db:"processed_at"`
+	// HouseholdID is household whose pool paid the order, empty if it was paid from personal balance
+	HouseholdID string `json:"-" db:"household_id"`
+}
+
+// StatementEntry is a balance-affecting event, Balance is the running balance after it
+type StatementEntry struct {
+	Kind       string    `json:"type" db:"kind"`
+	Reference  string    `json:"reference" db:"reference"`
+	Amount     float64   `json:"amount" db:"amount"`
+	Balance    float64   `json:"balance" db:"balance"`
+	OccurredAt time.Time `json:"occurred_at" db:"occurred_at"`
+}
+
+// MonthlyStatement is an immutable summary of user's balance for a month in form yyyy-mm
+type MonthlyStatement struct {
+	UserID    string    `json:"-" db:"user_id"`
+	Month     string    `json:"month" db:"month"`
+	Opening   float64   `json:"opening" db:"opening"`
+	Accrued   float64   `json:"accrued" db:"accrued"`
+	Withdrawn float64   `json:"withdrawn" db:"withdrawn"`
+	Expired   float64   `json:"expired" db:"expired"`
+	Closing   float64   `json:"closing" db:"closing"`
+	CreatedAt time.Time `json:"generated_at" db:"created_at"`
+}
+
+// Adjustment is a ledger entry written by hand or by reconciliation, Reason is mandatory
+type Adjustment struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	Amount    float64   `json:"amount" db:"amount"`
+	Reason    string    `json:"reason" db:"reason"`
+	Actor     string    `json:"actor" db:"actor"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Restores is set for credits bringing balance back to the ledger, they aren't ledger events themselves
+	Restores bool `json:"restores" db:"restores"`
+}
+
+// BalanceDrift is a stored balance that differs from the one computed from the ledger
+type BalanceDrift struct {
+	UserID            string  `json:"user_id" db:"user_id"`
+	Actual            float64 `json:"actual" db:"actual"`
+	Expected          float64 `json:"expected" db:"expected"`
+	ActualWithdrawn   float64 `json:"actual_withdrawn" db:"actual_withdrawn"`
+	ExpectedWithdrawn float64 `json:"expected_withdrawn" db:"expected_withdrawn"`
+}
+
+// AuditEvent records who did what to whom, Payload holds action details
+type AuditEvent struct {
+	ID        string          `json:"id" db:"id"`
+	Actor     string          `json:"actor" db:"actor"`
+	Action    string          `json:"action" db:"action"`
+	Subject   string          `json:"subject" db:"subject"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	RequestID string          `json:"request_id" db:"request_id"`
+	IP        string          `json:"ip" db:"ip"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+// AuditFilter selects audit events, empty fields match everything, zero From and To are unbounded
+type AuditFilter struct {
+	Actor   string
+	Subject string
+	Action  string
+	From    time.Time
+	To      time.Time
+	Limit   int
+	Offset  int
+}
+
+// TokenFamily is a login session, refresh tokens rotated from one login belong to the same family
+type TokenFamily struct {
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// RefreshToken is stored by hash only, UsedAt is set once it is exchanged for a new one
+type RefreshToken struct {
+	Hash      string     `db:"token_hash"`
+	FamilyID  string     `db:"family_id"`
+	UserID    string     `db:"user_id"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// Session is server-side login session, Token is the scs session token and never shown
+type Session struct {
+	ID         string    `json:"id" db:"id"`
+	Token      string    `json:"-" db:"token"`
+	UserID     string    `json:"-" db:"user_id"`
+	Device     string    `json:"device" db:"device"`
+	IP         string    `json:"ip" db:"ip"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
+	Current    bool      `json:"current" db:"-"`
+}
+
+// LoginAttempts counts recent failed logins to an account or from an IP, Key tells which one
+type LoginAttempts struct {
+	Key           string     `db:"key"`
+	Failures      int        `db:"failures"`
+	LastFailureAt time.Time  `db:"last_failure_at"`
+	LockedUntil   *time.Time `db:"locked_until"`
+}
+
+// MFA is TOTP second factor of user, Secret is encrypted. It works once EnabledAt is set,
+// LastStep is the last accepted time step, so each code can be used once
+type MFA struct {
+	UserID    string     `db:"user_id"`
+	Secret    string     `db:"secret"`
+	CreatedAt time.Time  `db:"created_at"`
+	EnabledAt *time.Time `db:"enabled_at"`
+	LastStep  int64      `db:"last_step"`
+}
+
+// ResponseMFAEnrollment is shown once on enrollment, codes are stored by hash only
+type ResponseMFAEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ResponseMFARequired is login waiting for second factor, or for enrollment if EnrollmentRequired
+type ResponseMFARequired struct {
+	MFAToken           string `json:"mfa_token"`
+	EnrollmentRequired bool   `json:"enrollment_required"`
+	ExpiresIn          int    `json:"expires_in"`
+}
+
+// PasswordReset is single use token to set new password, stored by hash only
+type PasswordReset struct {
+	Hash      string     `db:"token_hash"`
+	UserID    string     `db:"user_id"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// RequestChangePassword changes password of logged in user
+type RequestChangePassword struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// RequestPasswordReset asks to send password reset token to user given by login
+type RequestPasswordReset struct {
+	Login string `json:"login"`
+}
+
+// RequestResetPassword sets new password with token sent on RequestPasswordReset
+type RequestResetPassword struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// Identity links user to account at external identity provider, Subject is id of the account at Issuer
+type Identity struct {
+	Issuer    string    `db:"issuer"`
+	Subject   string    `db:"subject"`
+	UserID    string    `db:"user_id"`
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// MerchantKey is API key of merchant backend, the key itself is shown once on creation and stored by hash only.
+// Prefix is the beginning of the key, so operators can tell keys apart
+type MerchantKey struct {
+	ID         string         `json:"id" db:"id"`
+	Name       string         `json:"name" db:"name"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	Hash       string         `json:"-" db:"key_hash"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	CreatedBy  string         `json:"created_by" db:"created_by"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time     `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// HasScope tells if key may be used for action of given scope
+func (k *MerchantKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestMerchantKey creates merchant key
+type RequestMerchantKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// ResponseMerchantKey is created merchant key, the only time Key is shown
+type ResponseMerchantKey struct {
+	*MerchantKey
+	Key string `json:"key"`
+}
+
+// RequestMerchantOrder is order submitted by merchant on behalf of customer with given login
+type RequestMerchantOrder struct {
+	Login  string `json:"login"`
+	Number string `json:"number"`
+}
+
+// ResponseTokens is returned by token refresh
+type ResponseTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// ResponseAdminUser is user as shown to operators
+type ResponseAdminUser struct {
+	ID       string           `json:"id"`
+	Login    string           `json:"login"`
+	Role     string           `json:"role"`
+	LockedAt *time.Time       `json:"locked_at,omitempty"`
+	Balance  *ResponseBalance `json:"balance,omitempty"`
+}
+
+// ExportRecord is a line of exported history, Type is one of Export* constants
+type ExportRecord struct {
+	Type   string    `json:"type"`
+	Number string    `json:"number"`
+	Status string    `json:"status,omitempty"`
+	Amount float64   `json:"amount"`
+	Date   time.Time `json:"date"`
+}
+
+// StatementFilter selects statement entries, zero From and To are unbounded
+type StatementFilter struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
 }
 
 const (
-	userID               = "userID"
-	OrderStatusNew       = "NEW"
-	OrderStatusInvalid   = "INVALID"
-	OrderStatusProcessed = "PROCESSED"
+	userID                = "userID"
+	OrderStatusNew        = "NEW"
+	OrderStatusProcessing = "PROCESSING"
+	OrderStatusInvalid    = "INVALID"
+	OrderStatusProcessed  = "PROCESSED"
+)
+
+// Sources of point lots
+const (
+	SourceOrder = "ORDER"
+	// SourceBonus is extra points of tier on top of accrual of order
+	SourceBonus      = "BONUS"
+	SourceCampaign   = "CAMPAIGN"
+	SourceReferral   = "REFERRAL"
+	SourceVoucher    = "VOUCHER"
+	SourceTransfer   = "TRANSFER"
+	SourceAdjustment = "ADJUSTMENT"
+)
+
+// LoginSeparator separates provider from name in logins of OIDC users, logins of password users can't contain it
+const LoginSeparator = ":"
+
+const (
+	HouseholdRoleOwner  = "OWNER"
+	HouseholdRoleMember = "MEMBER"
+)
+
+const (
+	InvitationStatusPending   = "PENDING"
+	InvitationStatusAccepted  = "ACCEPTED"
+	InvitationStatusDeclined  = "DECLINED"
+	InvitationStatusCancelled = "CANCELLED"
+)
+
+// Kinds of household entries
+const (
+	HouseholdAccrual    = "ACCRUAL"
+	HouseholdWithdrawal = "WITHDRAWAL"
+	HouseholdCampaign   = "CAMPAIGN"
+)
+
+// Kinds of statement entries
+const (
+	StatementAccrual     = "ACCRUAL"
+	StatementWithdrawal  = "WITHDRAWAL"
+	StatementReferral    = "REFERRAL"
+	StatementVoucher     = "VOUCHER"
+	StatementBonus       = "BONUS"
+	StatementCampaign    = "CAMPAIGN"
+	StatementTransferIn  = "TRANSFER_IN"
+	StatementTransferOut = "TRANSFER_OUT"
+	StatementExpiration  = "EXPIRATION"
+	StatementAdjustment  = "ADJUSTMENT"
+)
+
+// Types of exported records
+const (
+	ExportOrder      = "ORDER"
+	ExportAccrual    = "ACCRUAL"
+	ExportWithdrawal = "WITHDRAWAL"
+)
+
+// Roles of users
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+	RoleService  = "service"
+)
+
+// Scopes of merchant keys
+const (
+	ScopeOrdersWrite = "orders:write"
+)
+
+// Actions recorded in audit log
+const (
+	AuditSearchUsers       = "SEARCH_USERS"
+	AuditViewUser          = "VIEW_USER"
+	AuditViewOrders        = "VIEW_ORDERS"
+	AuditViewWithdrawals   = "VIEW_WITHDRAWALS"
+	AuditAdjustBalance     = "ADJUST_BALANCE"
+	AuditLockUser          = "LOCK_USER"
+	AuditUnlockUser        = "UNLOCK_USER"
+	AuditRepollOrder       = "REPOLL_ORDER"
+	AuditChangeRole        = "CHANGE_ROLE"
+	AuditViewAudit         = "VIEW_AUDIT"
+	AuditExportAudit       = "EXPORT_AUDIT"
+	AuditRegister          = "REGISTER"
+	AuditLogin             = "LOGIN"
+	AuditLoginFailed       = "LOGIN_FAILED"
+	AuditWithdraw          = "WITHDRAW"
+	AuditHouseholdWithdraw = "HOUSEHOLD_WITHDRAW"
+	AuditCorrectDrift      = "CORRECT_DRIFT"
+	AuditOrderStatus       = "ORDER_STATUS"
+	AuditLogout            = "LOGOUT"
+	AuditTokenReuse        = "TOKEN_REUSE"
+	AuditRevokeSession     = "REVOKE_SESSION"
+	AuditCreateMerchantKey = "CREATE_MERCHANT_KEY"
+	AuditRevokeMerchantKey = "REVOKE_MERCHANT_KEY"
+	AuditMerchantOrder     = "MERCHANT_ORDER"
+	AuditLoginLockout      = "LOGIN_LOCKOUT"
+	AuditEnrollMFA         = "ENROLL_MFA"
+	AuditEnableMFA         = "ENABLE_MFA"
+	AuditMFAFailed         = "MFA_FAILED"
+	AuditChangePassword    = "CHANGE_PASSWORD"
+	AuditRequestReset      = "REQUEST_PASSWORD_RESET"
+	AuditResetPassword     = "RESET_PASSWORD"
+	AuditLinkIdentity      = "LINK_IDENTITY"
+	AuditCreateCampaign    = "CREATE_CAMPAIGN"
+	AuditUpdateCampaign    = "UPDATE_CAMPAIGN"
+	AuditDeleteCampaign    = "DELETE_CAMPAIGN"
+	AuditMintVouchers      = "MINT_VOUCHERS"
+	AuditRedeemVoucher     = "REDEEM_VOUCHER"
+	AuditTransfer          = "TRANSFER"
+)
+
+// Directions of transfers
+const (
+	TransferIn  = "in"
+	TransferOut = "out"
+)
+
+const (
+	ReferralStatusPending  = "PENDING"
+	ReferralStatusRewarded = "REWARDED"
+	ReferralStatusRejected = "REJECTED"
 )
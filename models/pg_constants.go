@@ -3,7 +3,15 @@ package models
 import "github.com/gynshu-one/gophermart-loyalty-system/pgadapter/composer"
 
 const (
-	ID     = composer.Field("id")
-	UserID = composer.Field("user_id")
-	Status = composer.Field("status")
+	ID        = composer.Field("id")
+	UserID    = composer.Field("user_id")
+	Status    = composer.Field("status")
+	Role      = composer.Field("role")
+	SessionID = composer.Field("session_id")
+	// MerchantKeyID is the key merchant request is authorized by
+	MerchantKeyID = composer.Field("merchant_key_id")
+	// MFAEnrollment marks requests authorized by enrollment token of login that waits for second factor
+	MFAEnrollment = composer.Field("mfa_enrollment")
+	// CookieAuth marks requests authorized by cookie, browsers send those on their own, so they need CSRF token
+	CookieAuth = composer.Field("cookie_auth")
 )
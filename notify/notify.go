@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	resty "github.com/go-resty/resty/v2"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// webhookTimeout bounds delivery, so slow receiver doesn't hold requests
+const webhookTimeout = 10 * time.Second
+
+// Notifier delivers messages to users, users have no contacts here so delivery is up to implementation
+type Notifier interface {
+	// PasswordReset sends token which sets new password of user until expiresAt
+	PasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error
+}
+
+// NewNotifier posts notifications to webhook. Without webhook they are logged if logTokens is set,
+// otherwise it returns nil and notifications can't be delivered
+func NewNotifier(webhook string, logTokens bool) Notifier {
+	if webhook == "" {
+		if !logTokens {
+			log.Warn().Msg("No notify webhook, password reset is disabled")
+			return nil
+		}
+		log.Warn().Msg("No notify webhook, password reset tokens are logged")
+		return &logNotifier{}
+	}
+	return &webhookNotifier{url: webhook, client: resty.New().SetTimeout(webhookTimeout)}
+}
+
+// logNotifier writes notifications to log, meant for development only as tokens are secrets
+type logNotifier struct{}
+
+func (n *logNotifier) PasswordReset(_ context.Context, user *models.User, token string, expiresAt time.Time) error {
+	log.Info().Str("login", user.Login).Time("expires_at", expiresAt).Msgf("Password reset token: %s", token)
+	return nil
+}
+
+// webhookNotifier posts notifications as JSON, receiver finds the user by id or login and delivers them
+type webhookNotifier struct {
+	url    string
+	client *resty.Client
+}
+
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	UserID    string    `json:"user_id"`
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (n *webhookNotifier) PasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error {
+	resp, err := n.client.R().SetContext(ctx).SetBody(&webhookEvent{
+		Event:     "password_reset",
+		UserID:    user.ID,
+		Login:     user.Login,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}).Post(n.url)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("notify webhook responded %s", resp.Status())
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	resty "github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// requestTimeout bounds each call to identity provider
+	requestTimeout = 10 * time.Second
+	// keysRefreshInterval limits refetching keys of provider on unknown key id, so forged tokens can't flood it
+	keysRefreshInterval = time.Minute
+	// verifierBytes is entropy of PKCE code verifier, it makes 43 characters, the shortest allowed
+	verifierBytes = 32
+)
+
+// ProviderConfig is identity provider users may log in with, Name appears in login and callback URLs
+type ProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// LoadProviders reads JSON array of providers from file, empty path gives no providers
+func LoadProviders(file string) ([]ProviderConfig, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var providers []ProviderConfig
+	if err = json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("parse oidc providers: %w", err)
+	}
+	for _, p := range providers {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %q needs name, issuer and client_id", p.Name)
+		}
+	}
+	return providers, nil
+}
+
+// Claims is what ID token tells about the user
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Provider runs authorization code flow with PKCE against one issuer.
+// Its endpoints are discovered and keys fetched on first use, so provider being down doesn't stop the server
+type Provider struct {
+	ProviderConfig
+	redirectURL string
+	client      *resty.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]any
+	keysAt    time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewProvider(config ProviderConfig, redirectURL string) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		ProviderConfig: config,
+		redirectURL:    redirectURL,
+		client:         resty.New().SetTimeout(requestTimeout),
+	}
+}
+
+// NewVerifier returns PKCE code verifier, it is kept by client until code is exchanged
+func NewVerifier() string {
+	return helpers.GenerateCode(verifierBytes)
+}
+
+// AuthCodeURL is where user is sent to log in, state and nonce tie the answer to this login
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades authorization code for ID token and verifies it was issued for this login
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	request := p.client.R().SetContext(ctx).SetResult(&token).SetFormData(map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          code,
+		"redirect_uri":  p.redirectURL,
+		"client_id":     p.ClientID,
+		"code_verifier": verifier,
+	})
+	if p.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := request.Post(d.TokenEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("%w: token endpoint responded %s", models.ErrorInvalidIDToken, resp.Status())
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", models.ErrorInvalidIDToken)
+	}
+	return p.verify(ctx, token.IDToken, nonce)
+}
+
+// verify checks signature of ID token against keys of issuer and that it is fresh, ours and for this login
+func (p *Provider) verify(ctx context.Context, raw, nonce string) (*Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrorInvalidIDToken, err)
+	}
+
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	tokenNonce, _ := claims["nonce"].(string)
+	switch {
+	case issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", models.ErrorInvalidIDToken, issuer)
+	case !claims.VerifyAudience(p.ClientID, true):
+		return nil, fmt.Errorf("%w: not issued for us", models.ErrorInvalidIDToken)
+	case !claims.VerifyExpiresAt(time.Now().Unix(), true):
+		return nil, fmt.Errorf("%w: expired", models.ErrorInvalidIDToken)
+	case subject == "":
+		return nil, fmt.Errorf("%w: no subject", models.ErrorInvalidIDToken)
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", models.ErrorInvalidIDToken)
+	}
+
+	result := &Claims{Issuer: issuer, Subject: subject}
+	result.Email, _ = claims["email"].(string)
+	result.EmailVerified, _ = claims["email_verified"].(bool)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	return result, nil
+}
+
+// discover reads provider metadata once, failed attempts are retried on next login
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d discovery
+	resp, err := p.client.R().SetContext(ctx).SetResult(&d).
+		Get(strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("oidc discovery of %s responded %s", p.Issuer, resp.Status())
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery of %s is for issuer %s", p.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery of %s misses endpoints", p.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key finds verification key by id, keys are refetched when id is unknown as issuer may have rotated them.
+// Empty id is accepted only if issuer has single key
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set helpers.JWKSet
+	resp, err := p.client.R().SetContext(ctx).SetResult(&set).Get(d.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("oidc keys of %s responded %s", p.Issuer, resp.Status())
+	}
+	p.keys, p.keysAt = make(map[string]any), time.Now()
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.KeyID] = key
+	}
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (p *Provider) findKey(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
@@ -0,0 +1,194 @@
+package pgadapter
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"math"
+	"time"
+)
+
+const (
+	CreateAdjustmentSchema = `
+    CREATE TABLE IF NOT EXISTS balance_adjustments (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        amount FLOAT NOT NULL,
+        reason TEXT NOT NULL,
+        actor VARCHAR(255) NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS balance_adjustments_user_id_idx ON balance_adjustments (user_id);
+    ALTER TABLE balance_adjustments ADD COLUMN IF NOT EXISTS restores BOOLEAN NOT NULL DEFAULT FALSE;`
+	createAdjustment = `INSERT INTO balance_adjustments (id, user_id, amount, reason, actor, created_at, restores) VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	selectReconciled = `SELECT user_id FROM balances WHERE user_id > $1 ORDER BY user_id LIMIT $2;`
+	// selectDrift compares stored balance of user $1 with the one expected from the ledger
+	selectDrift = `
+    WITH events AS (` + statementEvents + `
+    )
+    SELECT b.user_id, b.amount AS actual, (SELECT COALESCE(SUM(amount), 0) FROM events) AS expected,
+        b.withdrawn AS actual_withdrawn,
+        (SELECT COALESCE(SUM(sum), 0) FROM withdrawals WHERE user_id = $1 AND household_id IS NULL) AS expected_withdrawn
+    FROM balances b WHERE b.user_id = $1;`
+	lockBalance   = `SELECT user_id FROM balances WHERE user_id = $1 FOR UPDATE;`
+	syncWithdrawn = `UPDATE balances SET withdrawn = $1 WHERE user_id = $2;`
+)
+
+// reconcileBatch limits the number of balances checked in one call
+const reconcileBatch = 500
+
+// driftTolerance hides rounding errors of float sums
+const driftTolerance = 0.005
+
+type AdjustmentAdapter interface {
+	CheckBalances(ctx context.Context, after string) ([]*models.BalanceDrift, string, error)
+	CorrectDrift(ctx context.Context, userID, reason, actor string) (*models.Adjustment, error)
+	AdjustBalance(ctx context.Context, adjustment *models.Adjustment) error
+}
+type adjustmentAdapter struct {
+	conn *sqlx.DB
+	AdjustmentAdapter
+}
+
+func NewAdjustmentAdapter(ctx context.Context, conn *sqlx.DB) *adjustmentAdapter {
+	a := &adjustmentAdapter{conn: conn}
+	err := a.createAdjustmentSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create adjustment schema")
+	}
+	return a
+}
+
+// CheckBalances compares next batch of balances of users with id greater than after with the ledger.
+// Returns drifted balances and id to continue from, which is empty when all balances are checked
+func (a *adjustmentAdapter) CheckBalances(ctx context.Context, after string) ([]*models.BalanceDrift, string, error) {
+	var users []string
+	if err := a.conn.SelectContext(ctx, &users, selectReconciled, after, reconcileBatch); err != nil {
+		return nil, "", err
+	}
+	var drifts []*models.BalanceDrift
+	for _, userID := range users {
+		drift := &models.BalanceDrift{}
+		if err := a.conn.GetContext(ctx, drift, selectDrift, userID); err != nil {
+			return nil, "", err
+		}
+		if drifted(drift) {
+			drifts = append(drifts, drift)
+		}
+	}
+	if len(users) < reconcileBatch {
+		return drifts, "", nil
+	}
+	return drifts, users[len(users)-1], nil
+}
+
+// CorrectDrift records adjustment which brings stored balance and the ledger together.
+// Balance above the ledger means it was credited without a ledger entry, so balance users have seen is kept
+// and the difference is documented with reason. Balance below the ledger means points were taken without
+// a ledger entry, e.g. withdrawal that wasn't recorded, so they are credited back by restoring adjustment.
+// Withdrawn total is only a sum of withdrawals, so it is set to the ledger's one.
+// Returns nil if balance doesn't drift anymore
+func (a *adjustmentAdapter) CorrectDrift(ctx context.Context, userID, reason, actor string) (*models.Adjustment, error) {
+	tx, err := a.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Recheck under lock, balance might have changed since it was checked
+	if _, err = tx.ExecContext(ctx, lockBalance, userID); err != nil {
+		return nil, err
+	}
+	drift := &models.BalanceDrift{}
+	if err = tx.GetContext(ctx, drift, selectDrift, userID); err != nil {
+		return nil, err
+	}
+	if math.Abs(drift.ActualWithdrawn-drift.ExpectedWithdrawn) > driftTolerance {
+		if _, err = tx.ExecContext(ctx, syncWithdrawn, drift.ExpectedWithdrawn, userID); err != nil {
+			return nil, err
+		}
+	}
+	if math.Abs(drift.Actual-drift.Expected) <= driftTolerance {
+		return nil, tx.Commit()
+	}
+	adjustment := &models.Adjustment{
+		ID:        helpers.GenerateUUID(),
+		UserID:    userID,
+		Amount:    drift.Actual - drift.Expected,
+		Reason:    reason,
+		Actor:     actor,
+		CreatedAt: time.Now(),
+	}
+	if drift.Actual < drift.Expected {
+		adjustment.Amount = drift.Expected - drift.Actual
+		adjustment.Restores = true
+		err = adjustBalanceTx(ctx, tx, adjustment)
+	} else {
+		err = createAdjustmentTx(ctx, tx, adjustment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return adjustment, tx.Commit()
+}
+
+// AdjustBalance changes balance by adjustment amount and records it in the ledger.
+// Positive adjustment is credited as a new lot, negative one is taken from the oldest lots
+// and fails with models.ErrorInsufficientFunds if balance is lower
+func (a *adjustmentAdapter) AdjustBalance(ctx context.Context, adjustment *models.Adjustment) error {
+	tx, err := a.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, lockBalance, adjustment.UserID)
+	if err = notFoundIfNoRows(result, err); err != nil {
+		return err
+	}
+	if err = adjustBalanceTx(ctx, tx, adjustment); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adjustBalanceTx changes locked balance by adjustment amount and records adjustment within given transaction
+func adjustBalanceTx(ctx context.Context, tx *sqlx.Tx, adjustment *models.Adjustment) error {
+	if adjustment.Amount > 0 {
+		err := creditLotTx(ctx, tx, newLot(adjustment.UserID, adjustment.Amount, models.SourceAdjustment, adjustment.ID))
+		if err != nil {
+			return err
+		}
+	} else {
+		result, err := tx.ExecContext(ctx, debitBalance, -adjustment.Amount, adjustment.UserID)
+		if err = notFoundIfNoRows(result, err); err != nil {
+			if errors.Is(err, models.ErrorNotFound) {
+				return models.ErrorInsufficientFunds
+			}
+			return err
+		}
+		if _, err = consumeLotsTx(ctx, tx, adjustment.UserID, -adjustment.Amount); err != nil {
+			return err
+		}
+	}
+	return createAdjustmentTx(ctx, tx, adjustment)
+}
+
+func createAdjustmentTx(ctx context.Context, tx *sqlx.Tx, adjustment *models.Adjustment) error {
+	_, err := tx.ExecContext(ctx, createAdjustment, adjustment.ID, adjustment.UserID, adjustment.Amount,
+		adjustment.Reason, adjustment.Actor, adjustment.CreatedAt, adjustment.Restores)
+	return err
+}
+
+func drifted(drift *models.BalanceDrift) bool {
+	return math.Abs(drift.Actual-drift.Expected) > driftTolerance ||
+		math.Abs(drift.ActualWithdrawn-drift.ExpectedWithdrawn) > driftTolerance
+}
+
+func (a *adjustmentAdapter) createAdjustmentSchema(ctx context.Context) error {
+	_, err := a.conn.ExecContext(ctx, CreateAdjustmentSchema)
+	return err
+}
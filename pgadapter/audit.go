@@ -0,0 +1,117 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// CreateAuditSchema makes audit_events append-only, updates, deletes and truncates are rejected by triggers
+	CreateAuditSchema = `
+    CREATE TABLE IF NOT EXISTS audit_events (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        actor VARCHAR(255) NOT NULL,
+        action VARCHAR(255) NOT NULL,
+        subject VARCHAR(255) NOT NULL,
+        payload JSONB NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS request_id VARCHAR(255) NOT NULL DEFAULT '';
+    ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS ip VARCHAR(255) NOT NULL DEFAULT '';
+    CREATE INDEX IF NOT EXISTS audit_events_subject_idx ON audit_events (subject, created_at);
+    CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor, created_at);
+    CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+    CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+    BEGIN
+        RAISE EXCEPTION 'audit_events is append-only';
+    END;
+    $$ LANGUAGE plpgsql;
+    DROP TRIGGER IF EXISTS audit_events_no_change ON audit_events;
+    CREATE TRIGGER audit_events_no_change BEFORE UPDATE OR DELETE ON audit_events
+        FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+    DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+    CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+        FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();`
+	createAuditEvent = `
+    INSERT INTO audit_events (id, actor, action, subject, payload, request_id, ip, created_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`
+	// auditEventsWhere filters by actor $1, subject $2 and action $3 when they are not empty, and by time in [$4, $5)
+	auditEventsWhere = `
+    FROM audit_events
+    WHERE ($1::TEXT = '' OR actor = $1) AND ($2::TEXT = '' OR subject = $2) AND ($3::TEXT = '' OR action = $3)
+        AND ($4::TIMESTAMPTZ IS NULL OR created_at >= $4) AND ($5::TIMESTAMPTZ IS NULL OR created_at < $5)
+    ORDER BY created_at, id`
+	readAuditEvents = `
+    SELECT id, actor, action, subject, payload, request_id, ip, created_at` + auditEventsWhere + `
+    LIMIT $6 OFFSET $7;`
+	streamAuditEvents = `
+    SELECT id, actor, action, subject, payload, request_id, ip, created_at` + auditEventsWhere + `;`
+)
+
+type AuditAdapter interface {
+	CreateEvent(ctx context.Context, event *models.AuditEvent) error
+	ReadEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error)
+	StreamEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error
+}
+type auditAdapter struct {
+	conn *sqlx.DB
+	AuditAdapter
+}
+
+func NewAuditAdapter(ctx context.Context, conn *sqlx.DB) *auditAdapter {
+	a := &auditAdapter{conn: conn}
+	err := a.createAuditSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create audit schema")
+	}
+	return a
+}
+
+func (a *auditAdapter) CreateEvent(ctx context.Context, event *models.AuditEvent) error {
+	if event.ID == "" {
+		event.ID = helpers.GenerateUUID()
+	}
+	payload := event.Payload
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	_, err := a.conn.ExecContext(ctx, createAuditEvent, event.ID, event.Actor, event.Action, event.Subject,
+		string(payload), event.RequestID, event.IP, event.CreatedAt)
+	return err
+}
+
+// ReadEvents returns one page of events matching filter, oldest first
+func (a *auditAdapter) ReadEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+	err := a.conn.SelectContext(ctx, &events, readAuditEvents, filter.Actor, filter.Subject, filter.Action,
+		nullTime(filter.From), nullTime(filter.To), filter.Limit, filter.Offset)
+	return events, err
+}
+
+// StreamEvents calls fn for every event matching filter one by one, Limit and Offset are ignored
+func (a *auditAdapter) StreamEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	rows, err := a.conn.QueryxContext(ctx, streamAuditEvents, filter.Actor, filter.Subject, filter.Action,
+		nullTime(filter.From), nullTime(filter.To))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		event := &models.AuditEvent{}
+		if err = rows.StructScan(event); err != nil {
+			return err
+		}
+		if err = fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (a *auditAdapter) createAuditSchema(ctx context.Context) error {
+	_, err := a.conn.ExecContext(ctx, CreateAuditSchema)
+	return err
+}
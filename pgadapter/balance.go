@@ -2,9 +2,11 @@ package pgadapter
 
 import (
 	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
 	"github.com/gynshu-one/gophermart-loyalty-system/models"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 const (
@@ -23,8 +25,10 @@ const (
 
 type BalanceAdapter interface {
 	ReadBalance(ctx context.Context, userID string) (*models.Balance, error)
-	IncrementBalance(ctx context.Context, userID string, amount float64) error
-	IncrementWithdrawn(ctx context.Context, userID string, amount float64) error
+	IncrementBalance(ctx context.Context, userID string, amount float64, source, reference string) error
+	CreditOrder(ctx context.Context, userID, orderID string, accrual, bonus float64) error
+	ReadExpiring(ctx context.Context, userID string, until time.Time) ([]*models.ExpiringPoints, error)
+	ExpireLots(ctx context.Context, now time.Time) (int, error)
 }
 type balanceAdapter struct {
 	conn *sqlx.DB
@@ -42,43 +46,102 @@ func NewBalanceAdapter(ctx context.Context, conn *sqlx.DB) *balanceAdapter {
 
 func (b *balanceAdapter) ReadBalance(ctx context.Context, userID string) (*models.Balance, error) {
 	var balance []*models.Balance
-	err := b.conn.SelectContext(ctx, &balance, readBalance, userID)
+	if err := b.conn.SelectContext(ctx, &balance, readBalance, userID); err != nil {
+		return nil, err
+	}
 	if len(balance) == 0 {
 		return nil, nil
 	}
-	return balance[0], err
-}
-func (b *balanceAdapter) IncrementBalance(ctx context.Context, userID string, amount float64) error {
-	_, err := b.conn.ExecContext(ctx, updateBalance, amount, userID)
-	return err
+	return balance[0], nil
 }
 
-// IncrementWithdrawn Increments Withdrawal and Decrements balance (if possible)
-func (b *balanceAdapter) IncrementWithdrawn(ctx context.Context, userID string, amount float64) error {
+// IncrementBalance credits balance with a new lot of points, source and reference tell where points came from
+func (b *balanceAdapter) IncrementBalance(ctx context.Context, userID string, amount float64, source, reference string) error {
 	tx, err := b.conn.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Update withdrawn only if amount is available
-	result, err := tx.ExecContext(ctx, updateWithdrawn, amount, userID)
-	if err != nil {
-		_ = tx.Rollback()
+	if err = creditLotTx(ctx, tx, newLot(userID, amount, source, reference)); err != nil {
 		return err
 	}
-	rows, err := result.RowsAffected()
+	return tx.Commit()
+}
+
+// CreditOrder credits accrual of order and bonus on top of it as separate lots at once,
+// so accrual system's amount stays apart from what loyalty program added.
+// Lot of order is unique, so order credited before is skipped and failed processing can be retried
+func (b *balanceAdapter) CreditOrder(ctx context.Context, userID, orderID string, accrual, bonus float64) error {
+	if accrual <= 0 {
+		return nil
+	}
+	tx, err := b.conn.BeginTxx(ctx, nil)
 	if err != nil {
-		_ = tx.Rollback()
 		return err
 	}
+	defer tx.Rollback()
 
-	if rows == 0 {
-		_ = tx.Rollback()
-		return models.ErrorInsufficientFunds
+	created, err := createLotOnceTx(ctx, tx, newLot(userID, accrual, models.SourceOrder, orderID))
+	if err != nil || !created {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, updateBalance, accrual, userID); err != nil {
+		return err
+	}
+	if bonus > 0 {
+		if err = creditLotTx(ctx, tx, newLot(userID, bonus, models.SourceBonus, orderID)); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
+
+// ReadExpiring returns amounts of points expiring until given time grouped by day
+func (b *balanceAdapter) ReadExpiring(ctx context.Context, userID string, until time.Time) ([]*models.ExpiringPoints, error) {
+	var expiring []*models.ExpiringPoints
+	err := b.conn.SelectContext(ctx, &expiring, readExpiring, userID, until)
+	return expiring, err
+}
+
+// ExpireLots writes off remaining points of lots expired by now from balances and household pools
+// and records expiry entries.
+// Returns number of expired lots
+func (b *balanceAdapter) ExpireLots(ctx context.Context, now time.Time) (int, error) {
+	tx, err := b.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var lots []*models.PointLot
+	if err = tx.SelectContext(ctx, &lots, selectExpired, now, expireBatch); err != nil {
+		return 0, err
+	}
+	for _, lot := range lots {
+		_, err = tx.ExecContext(ctx, createExpiry, helpers.GenerateUUID(), lot.UserID, lot.ID, lot.Remaining, now)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = tx.ExecContext(ctx, updateRemaining, 0, lot.ID); err != nil {
+			return 0, err
+		}
+		if lot.HouseholdID != "" {
+			_, err = tx.ExecContext(ctx, expirePool, lot.Remaining, lot.HouseholdID)
+		} else {
+			_, err = tx.ExecContext(ctx, expireBalance, lot.Remaining, lot.UserID)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(lots), tx.Commit()
+}
 func (b *balanceAdapter) createBalanceSchema(ctx context.Context) error {
 	_, err := b.conn.ExecContext(ctx, CreateBalanceSchema)
+	if err != nil {
+		return err
+	}
+	_, err = b.conn.ExecContext(ctx, CreateLotSchema)
 	return err
 }
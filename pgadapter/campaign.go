@@ -0,0 +1,179 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateCampaignSchema = `
+    CREATE TABLE IF NOT EXISTS campaigns (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        starts_at TIMESTAMPTZ NOT NULL,
+        ends_at TIMESTAMPTZ NOT NULL,
+        multiplier FLOAT NOT NULL,
+        bonus FLOAT NOT NULL,
+        per_user_cap FLOAT NOT NULL,
+        budget FLOAT NOT NULL,
+        spent FLOAT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL,
+        deleted_at TIMESTAMPTZ
+    );
+    CREATE TABLE IF NOT EXISTS campaign_contributions (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        campaign_id VARCHAR(255) NOT NULL REFERENCES campaigns(id),
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        order_id VARCHAR(255) NOT NULL REFERENCES orders(id),
+        amount FLOAT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL,
+        UNIQUE (campaign_id, order_id)
+    );`
+	campaignFields = `id, name, starts_at, ends_at, multiplier, bonus, per_user_cap, budget, spent, created_at`
+	createCampaign = `INSERT INTO campaigns (` + campaignFields + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);`
+	readCampaigns  = `SELECT ` + campaignFields + ` FROM campaigns WHERE deleted_at IS NULL ORDER BY starts_at;`
+	readCampaign   = `SELECT ` + campaignFields + ` FROM campaigns WHERE id = $1 AND deleted_at IS NULL;`
+	readActive     = `SELECT ` + campaignFields + ` FROM campaigns WHERE deleted_at IS NULL AND starts_at <= $1 AND ends_at > $1;`
+	updateCampaign = `UPDATE campaigns SET name = $1, starts_at = $2, ends_at = $3, multiplier = $4, bonus = $5, per_user_cap = $6, budget = $7 WHERE id = $8 AND deleted_at IS NULL;`
+	deleteCampaign = `UPDATE campaigns SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL;`
+	lockCampaign   = `SELECT ` + campaignFields + ` FROM campaigns WHERE id = $1 FOR UPDATE;`
+	sumContributed = `SELECT COALESCE(SUM(amount), 0) FROM campaign_contributions WHERE campaign_id = $1 AND user_id = $2;`
+	createContrib  = `INSERT INTO campaign_contributions (id, campaign_id, user_id, order_id, amount, created_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING;`
+	incrementSpent = `UPDATE campaigns SET spent = spent + $1 WHERE id = $2;`
+)
+
+type CampaignAdapter interface {
+	CreateCampaign(ctx context.Context, campaign *models.Campaign) error
+	ReadCampaigns(ctx context.Context) ([]*models.Campaign, error)
+	ReadCampaign(ctx context.Context, id string) (*models.Campaign, error)
+	ReadActiveCampaigns(ctx context.Context, at time.Time) ([]*models.Campaign, error)
+	UpdateCampaign(ctx context.Context, campaign *models.Campaign) error
+	DeleteCampaign(ctx context.Context, id string) error
+	Contribute(ctx context.Context, contribution *models.CampaignContribution) (float64, error)
+}
+type campaignAdapter struct {
+	conn *sqlx.DB
+	CampaignAdapter
+}
+
+func NewCampaignAdapter(ctx context.Context, conn *sqlx.DB) *campaignAdapter {
+	c := &campaignAdapter{conn: conn}
+	err := c.createCampaignSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create campaign schema")
+	}
+	return c
+}
+
+func (c *campaignAdapter) CreateCampaign(ctx context.Context, campaign *models.Campaign) error {
+	_, err := c.conn.ExecContext(ctx, createCampaign, campaign.ID, campaign.Name, campaign.StartsAt, campaign.EndsAt,
+		campaign.Multiplier, campaign.Bonus, campaign.PerUserCap, campaign.Budget, campaign.Spent, campaign.CreatedAt)
+	return err
+}
+
+func (c *campaignAdapter) ReadCampaigns(ctx context.Context) ([]*models.Campaign, error) {
+	var campaigns []*models.Campaign
+	err := c.conn.SelectContext(ctx, &campaigns, readCampaigns)
+	return campaigns, err
+}
+
+// ReadCampaign returns models.ErrorNotFound if campaign doesn't exist or was deleted
+func (c *campaignAdapter) ReadCampaign(ctx context.Context, id string) (*models.Campaign, error) {
+	var campaigns []*models.Campaign
+	err := c.conn.SelectContext(ctx, &campaigns, readCampaign, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(campaigns) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	return campaigns[0], nil
+}
+
+func (c *campaignAdapter) ReadActiveCampaigns(ctx context.Context, at time.Time) ([]*models.Campaign, error) {
+	var campaigns []*models.Campaign
+	err := c.conn.SelectContext(ctx, &campaigns, readActive, at)
+	return campaigns, err
+}
+
+func (c *campaignAdapter) UpdateCampaign(ctx context.Context, campaign *models.Campaign) error {
+	result, err := c.conn.ExecContext(ctx, updateCampaign, campaign.Name, campaign.StartsAt, campaign.EndsAt,
+		campaign.Multiplier, campaign.Bonus, campaign.PerUserCap, campaign.Budget, campaign.ID)
+	return notFoundIfNoRows(result, err)
+}
+
+// DeleteCampaign keeps deleted campaign in db, so its contributions still refer to it
+func (c *campaignAdapter) DeleteCampaign(ctx context.Context, id string) error {
+	result, err := c.conn.ExecContext(ctx, deleteCampaign, time.Now(), id)
+	return notFoundIfNoRows(result, err)
+}
+
+// Contribute records contribution limited by remaining per user cap and budget of the campaign
+// and credits it in the same transaction, so budget is never spent on points nobody got.
+// Returns granted amount, which is 0 if limits are exhausted or order has already got contribution
+func (c *campaignAdapter) Contribute(ctx context.Context, contribution *models.CampaignContribution) (float64, error) {
+	tx, err := c.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock campaign so concurrent contributions can't overspend the budget
+	campaign := &models.Campaign{}
+	if err = tx.GetContext(ctx, campaign, lockCampaign, contribution.CampaignID); err != nil {
+		return 0, err
+	}
+	amount := contribution.Amount
+	if campaign.Budget > 0 && amount > campaign.Budget-campaign.Spent {
+		amount = campaign.Budget - campaign.Spent
+	}
+	if campaign.PerUserCap > 0 {
+		var contributed float64
+		if err = tx.GetContext(ctx, &contributed, sumContributed, campaign.ID, contribution.UserID); err != nil {
+			return 0, err
+		}
+		if amount > campaign.PerUserCap-contributed {
+			amount = campaign.PerUserCap - contributed
+		}
+	}
+	if amount <= 0 {
+		return 0, nil
+	}
+
+	result, err := tx.ExecContext(ctx, createContrib, contribution.ID, campaign.ID, contribution.UserID,
+		contribution.OrderID, amount, contribution.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return 0, nil
+	}
+	if _, err = tx.ExecContext(ctx, incrementSpent, amount, campaign.ID); err != nil {
+		return 0, err
+	}
+	contribution.Amount = amount
+	if contribution.HouseholdID != "" {
+		err = creditPoolTx(ctx, tx, &models.HouseholdEntry{
+			HouseholdID: contribution.HouseholdID,
+			UserID:      contribution.UserID,
+			Kind:        models.HouseholdCampaign,
+			OrderID:     contribution.OrderID,
+			Amount:      amount,
+			CreatedAt:   contribution.CreatedAt,
+		}, models.SourceCampaign, contribution.ID)
+	} else {
+		err = creditLotTx(ctx, tx, newLot(contribution.UserID, amount, models.SourceCampaign, contribution.ID))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return amount, tx.Commit()
+}
+
+func (c *campaignAdapter) createCampaignSchema(ctx context.Context) error {
+	_, err := c.conn.ExecContext(ctx, CreateCampaignSchema)
+	return err
+}
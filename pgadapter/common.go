@@ -2,9 +2,13 @@ package pgadapter
 
 import (
 	"context"
+	"database/sql"
 	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
+	"strings"
+	"time"
 )
 
 func NewConnection(ctx context.Context) *sqlx.DB {
@@ -14,3 +18,33 @@ func NewConnection(ctx context.Context) *sqlx.DB {
 	}
 	return conn
 }
+
+// notFoundIfNoRows converts update of zero rows to models.ErrorNotFound
+func notFoundIfNoRows(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrorNotFound
+	}
+	return nil
+}
+
+func isDuplicateKey(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "duplicate key value violates unique constraint")
+}
+
+// nullTime turns zero time into NULL, so queries can treat it as unbounded
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// likeEscaper escapes wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
@@ -0,0 +1,414 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateHouseholdSchema = `
+    CREATE TABLE IF NOT EXISTS households (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        owner_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        amount FLOAT NOT NULL,
+        withdrawn FLOAT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS household_members (
+        user_id VARCHAR(255) NOT NULL PRIMARY KEY REFERENCES users(id),
+        household_id VARCHAR(255) NOT NULL REFERENCES households(id),
+        role VARCHAR(255) NOT NULL,
+        pooling BOOLEAN NOT NULL,
+        joined_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS household_invitations (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        household_id VARCHAR(255) NOT NULL REFERENCES households(id),
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        invited_by VARCHAR(255) NOT NULL REFERENCES users(id),
+        status VARCHAR(255) NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS household_entries (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        household_id VARCHAR(255) NOT NULL REFERENCES households(id),
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        kind VARCHAR(255) NOT NULL,
+        order_id VARCHAR(255) NOT NULL,
+        amount FLOAT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    UPDATE household_invitations i SET status = 'CANCELLED'
+    WHERE i.status = 'PENDING' AND EXISTS (
+        SELECT 1 FROM household_invitations o
+        WHERE o.household_id = i.household_id AND o.user_id = i.user_id AND o.status = 'PENDING'
+            AND (o.created_at, o.id) < (i.created_at, i.id)
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS household_invitations_pending_idx ON household_invitations (household_id, user_id) WHERE status = 'PENDING';
+    CREATE INDEX IF NOT EXISTS household_entries_order_id_idx ON household_entries (order_id);
+    CREATE UNIQUE INDEX IF NOT EXISTS household_entries_withdrawal_idx ON household_entries (order_id) WHERE kind = 'WITHDRAWAL';
+    CREATE UNIQUE INDEX IF NOT EXISTS household_entries_accrual_idx ON household_entries (order_id) WHERE kind = 'ACCRUAL';
+    ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS household_id VARCHAR(255) REFERENCES households(id);
+    ALTER TABLE point_lots ADD COLUMN IF NOT EXISTS household_id VARCHAR(255) REFERENCES households(id);
+    CREATE INDEX IF NOT EXISTS point_lots_household_id_idx ON point_lots (household_id, accrued_at) WHERE remaining > 0;`
+	createHousehold  = `INSERT INTO households (id, name, owner_id, amount, withdrawn, created_at) VALUES ($1, $2, $3, 0, 0, $4);`
+	readHousehold    = `SELECT id, name, owner_id, amount, withdrawn, created_at FROM households WHERE id = $1;`
+	lockHousehold    = `SELECT id, name, owner_id, amount, withdrawn, created_at FROM households WHERE id = $1 FOR UPDATE;`
+	updateOwner      = `UPDATE households SET owner_id = $1 WHERE id = $2;`
+	createMember     = `INSERT INTO household_members (user_id, household_id, role, pooling, joined_at) VALUES ($1, $2, $3, $4, $5);`
+	readMembership   = `SELECT m.household_id, m.user_id, u.login, m.role, m.pooling, m.joined_at FROM household_members m JOIN users u ON u.id = m.user_id WHERE m.user_id = $1;`
+	readMembers      = `SELECT m.household_id, m.user_id, u.login, m.role, m.pooling, m.joined_at FROM household_members m JOIN users u ON u.id = m.user_id WHERE m.household_id = $1 ORDER BY m.joined_at;`
+	deleteMember     = `DELETE FROM household_members WHERE household_id = $1 AND user_id = $2 AND role != $3;`
+	deleteLastMember = `DELETE FROM household_members WHERE household_id = $1 AND user_id = $2;`
+	updateMemberRole = `UPDATE household_members SET role = $1 WHERE household_id = $2 AND user_id = $3 AND role = $4;`
+	updatePooling    = `UPDATE household_members SET pooling = $1 WHERE user_id = $2;`
+	createInvitation = `INSERT INTO household_invitations (id, household_id, user_id, invited_by, status, created_at) VALUES ($1, $2, $3, $4, $5, $6);`
+	readInvitations  = `
+    SELECT i.id, i.household_id, h.name AS household_name, i.user_id, i.invited_by, i.status, i.created_at
+    FROM household_invitations i JOIN households h ON h.id = i.household_id
+    WHERE i.user_id = $1 AND i.status = $2 ORDER BY i.created_at;`
+	lockInvitation = `
+    SELECT i.id, i.household_id, h.name AS household_name, i.user_id, i.invited_by, i.status, i.created_at
+    FROM household_invitations i JOIN households h ON h.id = i.household_id
+    WHERE i.id = $1 AND i.user_id = $2 AND i.status = $3 FOR UPDATE OF i, h;`
+	updateInvitation  = `UPDATE household_invitations SET status = $1 WHERE id = $2;`
+	declineInvitation = `UPDATE household_invitations SET status = $1 WHERE id = $2 AND user_id = $3 AND status = $4;`
+	cancelInvitation  = `UPDATE household_invitations SET status = $1 WHERE id = $2 AND household_id = $3 AND status = $4;`
+	cancelInvitations = `UPDATE household_invitations SET status = $1 WHERE household_id = $2 AND status = $3;`
+	creditPool        = `UPDATE households SET amount = amount + $1 WHERE id = $2;`
+	debitPool         = `UPDATE households SET amount = amount - $1, withdrawn = withdrawn + $1 WHERE id = $2 AND amount >= $1;`
+	insertEntry       = `INSERT INTO household_entries (id, household_id, user_id, kind, order_id, amount, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	createEntry       = insertEntry + `;`
+	createEntryOnce   = insertEntry + ` ON CONFLICT DO NOTHING;`
+)
+
+type HouseholdAdapter interface {
+	CreateHousehold(ctx context.Context, household *models.Household) error
+	ReadHousehold(ctx context.Context, id string) (*models.Household, error)
+	ReadMembership(ctx context.Context, userID string) (*models.HouseholdMember, error)
+	ReadMembers(ctx context.Context, householdID string) ([]*models.HouseholdMember, error)
+	RemoveMember(ctx context.Context, householdID, userID string) error
+	LeaveHousehold(ctx context.Context, householdID, userID string) error
+	TransferOwnership(ctx context.Context, householdID, userID string) error
+	SetPooling(ctx context.Context, userID string, pooling bool) error
+	CreateInvitation(ctx context.Context, invitation *models.HouseholdInvitation) error
+	ReadInvitations(ctx context.Context, userID string) ([]*models.HouseholdInvitation, error)
+	AcceptInvitation(ctx context.Context, id, userID string) error
+	DeclineInvitation(ctx context.Context, id, userID string) error
+	CancelInvitation(ctx context.Context, id, householdID string) error
+	CreditPool(ctx context.Context, entry *models.HouseholdEntry) error
+	WithdrawPool(ctx context.Context, entry *models.HouseholdEntry) error
+}
+type householdAdapter struct {
+	conn *sqlx.DB
+	HouseholdAdapter
+}
+
+func NewHouseholdAdapter(ctx context.Context, conn *sqlx.DB) *householdAdapter {
+	h := &householdAdapter{conn: conn}
+	err := h.createHouseholdSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create household schema")
+	}
+	return h
+}
+
+// CreateHousehold creates household owned by household.OwnerID who becomes its first member
+func (h *householdAdapter) CreateHousehold(ctx context.Context, household *models.Household) error {
+	tx, err := h.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, createHousehold, household.ID, household.Name, household.OwnerID, household.CreatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, createMember, household.OwnerID, household.ID, models.HouseholdRoleOwner, false, household.CreatedAt)
+	if isDuplicateKey(err) {
+		return models.ErrorAlreadyInHousehold
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (h *householdAdapter) ReadHousehold(ctx context.Context, id string) (*models.Household, error) {
+	household := &models.Household{}
+	err := h.conn.GetContext(ctx, household, readHousehold, id)
+	return household, err
+}
+
+// ReadMembership returns nil if user doesn't belong to any household
+func (h *householdAdapter) ReadMembership(ctx context.Context, userID string) (*models.HouseholdMember, error) {
+	var members []*models.HouseholdMember
+	err := h.conn.SelectContext(ctx, &members, readMembership, userID)
+	if len(members) == 0 {
+		return nil, err
+	}
+	return members[0], err
+}
+
+func (h *householdAdapter) ReadMembers(ctx context.Context, householdID string) ([]*models.HouseholdMember, error) {
+	var members []*models.HouseholdMember
+	err := h.conn.SelectContext(ctx, &members, readMembers, householdID)
+	return members, err
+}
+
+// RemoveMember removes member from household, owner can't be removed
+func (h *householdAdapter) RemoveMember(ctx context.Context, householdID, userID string) error {
+	result, err := h.conn.ExecContext(ctx, deleteMember, householdID, userID, models.HouseholdRoleOwner)
+	return notFoundIfNoRows(result, err)
+}
+
+// LeaveHousehold removes user from household. Owner hands household over to the longest standing member,
+// household of the last member is dissolved once its pool is empty
+func (h *householdAdapter) LeaveHousehold(ctx context.Context, householdID, userID string) error {
+	tx, err := h.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	household := &models.Household{}
+	if err = tx.GetContext(ctx, household, lockHousehold, householdID); err != nil {
+		return err
+	}
+	if household.OwnerID == userID {
+		var members []*models.HouseholdMember
+		if err = tx.SelectContext(ctx, &members, readMembers, householdID); err != nil {
+			return err
+		}
+		var successor *models.HouseholdMember
+		for _, member := range members {
+			if member.UserID != userID {
+				successor = member
+				break
+			}
+		}
+		if successor == nil {
+			if err = dissolveTx(ctx, tx, household); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}
+		if err = transferOwnershipTx(ctx, tx, household, successor.UserID); err != nil {
+			return err
+		}
+	}
+	result, err := tx.ExecContext(ctx, deleteMember, householdID, userID, models.HouseholdRoleOwner)
+	if err = notFoundIfNoRows(result, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TransferOwnership makes member with userID the owner of household, current owner stays as member
+func (h *householdAdapter) TransferOwnership(ctx context.Context, householdID, userID string) error {
+	tx, err := h.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	household := &models.Household{}
+	if err = tx.GetContext(ctx, household, lockHousehold, householdID); err != nil {
+		return err
+	}
+	if err = transferOwnershipTx(ctx, tx, household, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// transferOwnershipTx promotes member of locked household to owner and demotes current owner
+func transferOwnershipTx(ctx context.Context, tx *sqlx.Tx, household *models.Household, userID string) error {
+	result, err := tx.ExecContext(ctx, updateMemberRole, models.HouseholdRoleOwner, household.ID, userID, models.HouseholdRoleMember)
+	if err = notFoundIfNoRows(result, err); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, updateMemberRole, models.HouseholdRoleMember, household.ID, household.OwnerID, models.HouseholdRoleOwner)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, updateOwner, userID, household.ID)
+	return err
+}
+
+// dissolveTx removes the last member of locked household and cancels its pending invitations.
+// Household is kept for history of its pool, so pool has to be spent before
+func dissolveTx(ctx context.Context, tx *sqlx.Tx, household *models.Household) error {
+	if household.Amount > 0 {
+		return models.ErrorHouseholdNotEmpty
+	}
+	_, err := tx.ExecContext(ctx, cancelInvitations, models.InvitationStatusCancelled, household.ID, models.InvitationStatusPending)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, deleteLastMember, household.ID, household.OwnerID)
+	return err
+}
+
+func (h *householdAdapter) SetPooling(ctx context.Context, userID string, pooling bool) error {
+	result, err := h.conn.ExecContext(ctx, updatePooling, pooling, userID)
+	return notFoundIfNoRows(result, err)
+}
+
+// CreateInvitation returns models.ErrorInvitationPending if user already has pending invitation to the household
+func (h *householdAdapter) CreateInvitation(ctx context.Context, invitation *models.HouseholdInvitation) error {
+	_, err := h.conn.ExecContext(ctx, createInvitation, invitation.ID, invitation.HouseholdID, invitation.UserID,
+		invitation.InvitedBy, invitation.Status, invitation.CreatedAt)
+	if isDuplicateKey(err) {
+		return models.ErrorInvitationPending
+	}
+	return err
+}
+
+// ReadInvitations returns pending invitations of user
+func (h *householdAdapter) ReadInvitations(ctx context.Context, userID string) ([]*models.HouseholdInvitation, error) {
+	var invitations []*models.HouseholdInvitation
+	err := h.conn.SelectContext(ctx, &invitations, readInvitations, userID, models.InvitationStatusPending)
+	return invitations, err
+}
+
+// AcceptInvitation makes user a member of inviting household
+func (h *householdAdapter) AcceptInvitation(ctx context.Context, id, userID string) error {
+	tx, err := h.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var invitations []*models.HouseholdInvitation
+	err = tx.SelectContext(ctx, &invitations, lockInvitation, id, userID, models.InvitationStatusPending)
+	if err != nil {
+		return err
+	}
+	if len(invitations) == 0 {
+		return models.ErrorNotFound
+	}
+	_, err = tx.ExecContext(ctx, createMember, userID, invitations[0].HouseholdID, models.HouseholdRoleMember, false, time.Now())
+	if isDuplicateKey(err) {
+		return models.ErrorAlreadyInHousehold
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, updateInvitation, models.InvitationStatusAccepted, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeclineInvitation lets invited user turn down pending invitation
+func (h *householdAdapter) DeclineInvitation(ctx context.Context, id, userID string) error {
+	result, err := h.conn.ExecContext(ctx, declineInvitation, models.InvitationStatusDeclined, id, userID, models.InvitationStatusPending)
+	return notFoundIfNoRows(result, err)
+}
+
+// CancelInvitation withdraws pending invitation sent by household
+func (h *householdAdapter) CancelInvitation(ctx context.Context, id, householdID string) error {
+	result, err := h.conn.ExecContext(ctx, cancelInvitation, models.InvitationStatusCancelled, id, householdID, models.InvitationStatusPending)
+	return notFoundIfNoRows(result, err)
+}
+
+// CreditPool adds accrual of member's order to household pool.
+// Accrual entry of order is unique, so order credited before is skipped and failed processing can be retried
+func (h *householdAdapter) CreditPool(ctx context.Context, entry *models.HouseholdEntry) error {
+	tx, err := h.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	entry.Kind = models.HouseholdAccrual
+	if entry.ID == "" {
+		entry.ID = helpers.GenerateUUID()
+	}
+	result, err := tx.ExecContext(ctx, createEntryOnce, entry.ID, entry.HouseholdID, entry.UserID, entry.Kind,
+		entry.OrderID, entry.Amount, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return err
+	}
+	if err = addToPoolTx(ctx, tx, entry, models.SourceOrder, entry.OrderID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// creditPoolTx records entry and adds its amount to household pool within given transaction
+func creditPoolTx(ctx context.Context, tx *sqlx.Tx, entry *models.HouseholdEntry, source, reference string) error {
+	if err := createEntryTx(ctx, tx, entry); err != nil {
+		return err
+	}
+	return addToPoolTx(ctx, tx, entry, source, reference)
+}
+
+// addToPoolTx adds amount of recorded entry to household pool.
+// Amount is kept as lot of the pool, so pooled points expire like personal ones
+func addToPoolTx(ctx context.Context, tx *sqlx.Tx, entry *models.HouseholdEntry, source, reference string) error {
+	lot := newLot(entry.UserID, entry.Amount, source, reference)
+	lot.HouseholdID = entry.HouseholdID
+	if err := createLotTx(ctx, tx, lot); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, creditPool, entry.Amount, entry.HouseholdID)
+	return err
+}
+
+// WithdrawPool spends household points if pool has enough of them, the oldest lots of pool are spent first.
+// Order can be paid from pool only once, models.ErrorOrderRegistered is returned for the second payment
+func (h *householdAdapter) WithdrawPool(ctx context.Context, entry *models.HouseholdEntry) error {
+	tx, err := h.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, debitPool, entry.Amount, entry.HouseholdID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrorInsufficientFunds
+	}
+	if _, err = consumePoolLotsTx(ctx, tx, entry.HouseholdID, entry.Amount); err != nil {
+		return err
+	}
+	entry.Kind = models.HouseholdWithdrawal
+	err = createEntryTx(ctx, tx, entry)
+	if isDuplicateKey(err) {
+		return models.ErrorOrderRegistered
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createEntryTx(ctx context.Context, tx *sqlx.Tx, entry *models.HouseholdEntry) error {
+	if entry.ID == "" {
+		entry.ID = helpers.GenerateUUID()
+	}
+	_, err := tx.ExecContext(ctx, createEntry, entry.ID, entry.HouseholdID, entry.UserID, entry.Kind, entry.OrderID, entry.Amount, entry.CreatedAt)
+	return err
+}
+
+func (h *householdAdapter) createHouseholdSchema(ctx context.Context) error {
+	_, err := h.conn.ExecContext(ctx, CreateHouseholdSchema)
+	return err
+}
@@ -0,0 +1,86 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CreateIdentitySchema = `
+    CREATE TABLE IF NOT EXISTS user_identities (
+        issuer TEXT NOT NULL,
+        subject TEXT NOT NULL,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        email TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMPTZ NOT NULL,
+        PRIMARY KEY (issuer, subject)
+    );
+    CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);`
+	createIdentity = `
+    INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+    VALUES ($1, $2, $3, $4, $5);`
+	readIdentity = `
+    SELECT issuer, subject, user_id, email, created_at FROM user_identities
+    WHERE issuer = $1 AND subject = $2;`
+)
+
+// IdentityAdapter links users to their accounts at external identity providers
+type IdentityAdapter interface {
+	ReadIdentity(ctx context.Context, issuer, subject string) (*models.Identity, error)
+	CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.Identity) error
+}
+type identityAdapter struct {
+	conn *sqlx.DB
+	IdentityAdapter
+}
+
+func NewIdentityAdapter(ctx context.Context, conn *sqlx.DB) *identityAdapter {
+	i := &identityAdapter{conn: conn}
+	err := i.createIdentitySchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create identity schema")
+	}
+	return i
+}
+
+func (i *identityAdapter) ReadIdentity(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	var identities []*models.Identity
+	if err := i.conn.SelectContext(ctx, &identities, readIdentity, issuer, subject); err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	return identities[0], nil
+}
+
+// CreateUserWithIdentity creates user with balance the same way CreateUser does and links identity to it.
+// Taken login is models.ErrorUserAlreadyExists, identity linked meanwhile is models.ErrorIdentityLinked
+func (i *identityAdapter) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.Identity) error {
+	tx, err := i.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = createUserTx(ctx, tx, user); err != nil {
+		return err
+	}
+	identity.UserID = user.ID
+	_, err = tx.ExecContext(ctx, createIdentity, identity.Issuer, identity.Subject, identity.UserID,
+		identity.Email, identity.CreatedAt)
+	if isDuplicateKey(err) {
+		return models.ErrorIdentityLinked
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (i *identityAdapter) createIdentitySchema(ctx context.Context) error {
+	_, err := i.conn.ExecContext(ctx, CreateIdentitySchema)
+	return err
+}
@@ -0,0 +1,101 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateLoginAttemptSchema = `
+    CREATE TABLE IF NOT EXISTS login_attempts (
+        key VARCHAR(255) NOT NULL PRIMARY KEY,
+        failures INTEGER NOT NULL,
+        last_failure_at TIMESTAMPTZ NOT NULL,
+        locked_until TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS login_attempts_last_failure_at_idx ON login_attempts (last_failure_at);`
+	loginAttemptFields = `key, failures, last_failure_at, locked_until`
+	readLoginAttempts  = `SELECT ` + loginAttemptFields + ` FROM login_attempts WHERE key = $1;`
+	// registerLoginFailure starts counting anew if the last failure is older than $3
+	registerLoginFailure = `
+    INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+    ON CONFLICT (key) DO UPDATE SET
+        failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+        locked_until = CASE WHEN login_attempts.last_failure_at < $3 THEN NULL ELSE login_attempts.locked_until END,
+        last_failure_at = EXCLUDED.last_failure_at
+    RETURNING ` + loginAttemptFields + `;`
+	lockLogin                = `UPDATE login_attempts SET locked_until = $2 WHERE key = $1;`
+	resetLoginAttempts       = `DELETE FROM login_attempts WHERE key = $1;`
+	deleteStaleLoginAttempts = `
+    DELETE FROM login_attempts
+    WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < current_timestamp);`
+)
+
+// LoginAttemptAdapter keeps failed logins by key, which is either account or IP
+type LoginAttemptAdapter interface {
+	ReadLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error)
+	RegisterLoginFailure(ctx context.Context, key string, since time.Time) (*models.LoginAttempts, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error)
+}
+type loginAttemptAdapter struct {
+	conn *sqlx.DB
+	LoginAttemptAdapter
+}
+
+func NewLoginAttemptAdapter(ctx context.Context, conn *sqlx.DB) *loginAttemptAdapter {
+	l := &loginAttemptAdapter{conn: conn}
+	err := l.createLoginAttemptSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create login attempt schema")
+	}
+	return l
+}
+
+// ReadLoginAttempts returns failures of key, key without failures is models.ErrorNotFound
+func (l *loginAttemptAdapter) ReadLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	var attempts []*models.LoginAttempts
+	if err := l.conn.SelectContext(ctx, &attempts, readLoginAttempts, key); err != nil {
+		return nil, err
+	}
+	if len(attempts) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	return attempts[0], nil
+}
+
+// RegisterLoginFailure counts failure of key, failures before since are forgotten
+func (l *loginAttemptAdapter) RegisterLoginFailure(ctx context.Context, key string, since time.Time) (*models.LoginAttempts, error) {
+	attempts := &models.LoginAttempts{}
+	err := l.conn.GetContext(ctx, attempts, registerLoginFailure, key, time.Now(), since)
+	return attempts, err
+}
+
+func (l *loginAttemptAdapter) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := l.conn.ExecContext(ctx, lockLogin, key, until)
+	return err
+}
+
+func (l *loginAttemptAdapter) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := l.conn.ExecContext(ctx, resetLoginAttempts, key)
+	return err
+}
+
+// DeleteStaleLoginAttempts forgets keys which haven't failed since before and aren't locked
+func (l *loginAttemptAdapter) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error) {
+	result, err := l.conn.ExecContext(ctx, deleteStaleLoginAttempts, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+func (l *loginAttemptAdapter) createLoginAttemptSchema(ctx context.Context) error {
+	_, err := l.conn.ExecContext(ctx, CreateLoginAttemptSchema)
+	return err
+}
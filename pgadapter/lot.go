@@ -0,0 +1,156 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/config"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+const (
+	CreateLotSchema = `
+    CREATE TABLE IF NOT EXISTS point_lots (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        source VARCHAR(255) NOT NULL,
+        reference VARCHAR(255) NOT NULL,
+        amount FLOAT NOT NULL,
+        remaining FLOAT NOT NULL,
+        accrued_at TIMESTAMPTZ NOT NULL,
+        expires_at TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS point_lots_user_id_idx ON point_lots (user_id, accrued_at) WHERE remaining > 0;
+    CREATE UNIQUE INDEX IF NOT EXISTS point_lots_order_idx ON point_lots (source, reference) WHERE source IN ('ORDER', 'BONUS');
+    CREATE TABLE IF NOT EXISTS point_expirations (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        lot_id VARCHAR(255) NOT NULL REFERENCES point_lots(id),
+        amount FLOAT NOT NULL,
+        expired_at TIMESTAMPTZ NOT NULL
+    );`
+	// Lots of household pools have household_id, it is added by household schema
+	lotFields          = `id, user_id, source, reference, amount, remaining, accrued_at, expires_at, COALESCE(household_id, '') AS household_id`
+	insertLot          = `INSERT INTO point_lots (id, user_id, source, reference, amount, remaining, accrued_at, expires_at, household_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))`
+	createLot          = insertLot + `;`
+	createLotOnce      = insertLot + ` ON CONFLICT DO NOTHING;`
+	selectOpenLots     = `SELECT ` + lotFields + ` FROM point_lots WHERE user_id = $1 AND household_id IS NULL AND remaining > 0 ORDER BY accrued_at, id FOR UPDATE;`
+	selectOpenPoolLots = `SELECT ` + lotFields + ` FROM point_lots WHERE household_id = $1 AND remaining > 0 ORDER BY accrued_at, id FOR UPDATE;`
+	selectUntracked    = `
+    SELECT b.amount - COALESCE((SELECT SUM(l.remaining) FROM point_lots l WHERE l.user_id = $1 AND l.household_id IS NULL AND l.remaining > 0), 0)
+    FROM balances b WHERE b.user_id = $1;`
+	updateRemaining = `UPDATE point_lots SET remaining = $1 WHERE id = $2;`
+	selectExpired   = `SELECT ` + lotFields + ` FROM point_lots WHERE expires_at <= $1 AND remaining > 0 ORDER BY expires_at LIMIT $2 FOR UPDATE SKIP LOCKED;`
+	createExpiry    = `INSERT INTO point_expirations (id, user_id, lot_id, amount, expired_at) VALUES ($1, $2, $3, $4, $5);`
+	expireBalance   = `UPDATE balances SET amount = GREATEST(amount - $1, 0) WHERE user_id = $2;`
+	expirePool      = `UPDATE households SET amount = GREATEST(amount - $1, 0) WHERE id = $2;`
+	readExpiring    = `
+    SELECT date_trunc('day', expires_at) AS expires_at, SUM(remaining) AS amount
+    FROM point_lots
+    WHERE user_id = $1 AND household_id IS NULL AND remaining > 0 AND expires_at IS NOT NULL AND expires_at <= $2
+    GROUP BY 1 ORDER BY 1;`
+)
+
+// expireBatch limits the number of lots expired in one transaction
+const expireBatch = 500
+
+// newLot prepares lot of points which expires according to configured lifetime
+func newLot(userID string, amount float64, source, reference string) *models.PointLot {
+	lot := &models.PointLot{
+		ID:        helpers.GenerateUUID(),
+		UserID:    userID,
+		Source:    source,
+		Reference: reference,
+		Amount:    amount,
+		Remaining: amount,
+		AccruedAt: time.Now(),
+	}
+	if lifetime := config.GetConfig().PointsLifetime; lifetime > 0 {
+		expiresAt := lot.AccruedAt.Add(lifetime)
+		lot.ExpiresAt = &expiresAt
+	}
+	return lot
+}
+
+// creditLotTx stores lot and increments balance within given transaction
+func creditLotTx(ctx context.Context, tx *sqlx.Tx, lot *models.PointLot) error {
+	if err := createLotTx(ctx, tx, lot); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, updateBalance, lot.Amount, lot.UserID)
+	return err
+}
+
+// createLotTx stores lot without touching balance, pool lots are added to household pool by caller
+func createLotTx(ctx context.Context, tx *sqlx.Tx, lot *models.PointLot) error {
+	_, err := tx.ExecContext(ctx, createLot, lot.ID, lot.UserID, lot.Source, lot.Reference, lot.Amount, lot.Remaining,
+		lot.AccruedAt, lot.ExpiresAt, lot.HouseholdID)
+	return err
+}
+
+// createLotOnceTx stores lot unless the same order was credited before, reports whether lot is stored
+func createLotOnceTx(ctx context.Context, tx *sqlx.Tx, lot *models.PointLot) (bool, error) {
+	result, err := tx.ExecContext(ctx, createLotOnce, lot.ID, lot.UserID, lot.Source, lot.Reference, lot.Amount, lot.Remaining,
+		lot.AccruedAt, lot.ExpiresAt, lot.HouseholdID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// consumeLotsTx takes amount from the oldest personal points of user first, balance must be debited by amount already.
+// Points credited before lots were introduced are not tracked by lots and are the oldest ones,
+// so the part of balance lots don't cover is taken before any lot.
+// Returns consumed portions of lots
+func consumeLotsTx(ctx context.Context, tx *sqlx.Tx, userID string, amount float64) ([]*models.PointLot, error) {
+	var untracked float64
+	if err := tx.GetContext(ctx, &untracked, selectUntracked, userID); err != nil {
+		return nil, err
+	}
+	// Balance is debited already, so amount was untracked as well before the debit
+	untracked += amount
+	if untracked >= amount {
+		return nil, nil
+	}
+	if untracked > 0 {
+		amount -= untracked
+	}
+	return consumeTx(ctx, tx, selectOpenLots, userID, amount)
+}
+
+// consumePoolLotsTx takes amount from the oldest lots of household pool first
+func consumePoolLotsTx(ctx context.Context, tx *sqlx.Tx, householdID string, amount float64) ([]*models.PointLot, error) {
+	return consumeTx(ctx, tx, selectOpenPoolLots, householdID, amount)
+}
+
+// consumeTx takes amount from open lots selected by query of owner
+func consumeTx(ctx context.Context, tx *sqlx.Tx, query, owner string, amount float64) ([]*models.PointLot, error) {
+	var lots []*models.PointLot
+	if err := tx.SelectContext(ctx, &lots, query, owner); err != nil {
+		return nil, err
+	}
+
+	var consumed []*models.PointLot
+	left := amount
+	for _, lot := range lots {
+		if left <= 0 {
+			break
+		}
+		take := lot.Remaining
+		if take > left {
+			take = left
+		}
+		remaining := lot.Remaining - take
+		if _, err := tx.ExecContext(ctx, updateRemaining, remaining, lot.ID); err != nil {
+			return nil, err
+		}
+		left -= take
+		portion := *lot
+		portion.Amount = take
+		portion.Remaining = take
+		consumed = append(consumed, &portion)
+	}
+	return consumed, nil
+}
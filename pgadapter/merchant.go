@@ -0,0 +1,96 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateMerchantKeySchema = `
+    CREATE TABLE IF NOT EXISTS merchant_keys (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        prefix VARCHAR(255) NOT NULL,
+        key_hash VARCHAR(255) NOT NULL UNIQUE,
+        scopes TEXT[] NOT NULL,
+        created_by VARCHAR(255) NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL,
+        last_used_at TIMESTAMPTZ,
+        revoked_at TIMESTAMPTZ
+    );`
+	merchantKeyFields = `id, name, prefix, key_hash, scopes, created_by, created_at, last_used_at, revoked_at`
+	createMerchantKey = `
+    INSERT INTO merchant_keys (id, name, prefix, key_hash, scopes, created_by, created_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	readMerchantKeys      = `SELECT ` + merchantKeyFields + ` FROM merchant_keys ORDER BY created_at DESC;`
+	readMerchantKeyByHash = `SELECT ` + merchantKeyFields + ` FROM merchant_keys WHERE key_hash = $1 AND revoked_at IS NULL;`
+	revokeMerchantKey     = `UPDATE merchant_keys SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL;`
+	// touchMerchantKey updates last use at most once a minute, so each request doesn't write
+	touchMerchantKey = `
+    UPDATE merchant_keys SET last_used_at = $2
+    WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2 - INTERVAL '1 minute');`
+)
+
+type MerchantKeyAdapter interface {
+	CreateMerchantKey(ctx context.Context, key *models.MerchantKey) error
+	ReadMerchantKeys(ctx context.Context) ([]*models.MerchantKey, error)
+	ReadMerchantKeyByHash(ctx context.Context, hash string) (*models.MerchantKey, error)
+	RevokeMerchantKey(ctx context.Context, id string) error
+	TouchMerchantKey(ctx context.Context, id string) error
+}
+type merchantKeyAdapter struct {
+	conn *sqlx.DB
+	MerchantKeyAdapter
+}
+
+func NewMerchantKeyAdapter(ctx context.Context, conn *sqlx.DB) *merchantKeyAdapter {
+	m := &merchantKeyAdapter{conn: conn}
+	err := m.createMerchantKeySchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create merchant key schema")
+	}
+	return m
+}
+
+func (m *merchantKeyAdapter) CreateMerchantKey(ctx context.Context, key *models.MerchantKey) error {
+	_, err := m.conn.ExecContext(ctx, createMerchantKey, key.ID, key.Name, key.Prefix, key.Hash,
+		key.Scopes, key.CreatedBy, key.CreatedAt)
+	return err
+}
+
+// ReadMerchantKeys returns all keys including revoked ones, newest first
+func (m *merchantKeyAdapter) ReadMerchantKeys(ctx context.Context) ([]*models.MerchantKey, error) {
+	var keys []*models.MerchantKey
+	err := m.conn.SelectContext(ctx, &keys, readMerchantKeys)
+	return keys, err
+}
+
+// ReadMerchantKeyByHash returns key that isn't revoked, otherwise models.ErrorNotFound
+func (m *merchantKeyAdapter) ReadMerchantKeyByHash(ctx context.Context, hash string) (*models.MerchantKey, error) {
+	var keys []*models.MerchantKey
+	if err := m.conn.SelectContext(ctx, &keys, readMerchantKeyByHash, hash); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	return keys[0], nil
+}
+
+// RevokeMerchantKey stops key from working, unknown and already revoked keys are models.ErrorNotFound
+func (m *merchantKeyAdapter) RevokeMerchantKey(ctx context.Context, id string) error {
+	return notFoundIfNoRows(m.conn.ExecContext(ctx, revokeMerchantKey, id, time.Now()))
+}
+
+func (m *merchantKeyAdapter) TouchMerchantKey(ctx context.Context, id string) error {
+	_, err := m.conn.ExecContext(ctx, touchMerchantKey, id, time.Now())
+	return err
+}
+
+func (m *merchantKeyAdapter) createMerchantKeySchema(ctx context.Context) error {
+	_, err := m.conn.ExecContext(ctx, CreateMerchantKeySchema)
+	return err
+}
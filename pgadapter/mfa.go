@@ -0,0 +1,121 @@
+package pgadapter
+
+import (
+	"context"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateMFASchema = `
+    CREATE TABLE IF NOT EXISTS user_mfa (
+        user_id VARCHAR(255) NOT NULL PRIMARY KEY REFERENCES users(id),
+        secret TEXT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL,
+        enabled_at TIMESTAMPTZ,
+        last_step BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        code_hash VARCHAR(255) NOT NULL,
+        used_at TIMESTAMPTZ,
+        PRIMARY KEY (user_id, code_hash)
+    );`
+	// createMFA replaces enrollment which wasn't confirmed, enabled one is kept
+	createMFA = `
+    INSERT INTO user_mfa (user_id, secret, created_at) VALUES ($1, $2, $3)
+    ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_step = 0
+    WHERE user_mfa.enabled_at IS NULL;`
+	deleteRecoveryCodes = `DELETE FROM mfa_recovery_codes WHERE user_id = $1;`
+	createRecoveryCode  = `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2);`
+	readMFA             = `SELECT user_id, secret, created_at, enabled_at, last_step FROM user_mfa WHERE user_id = $1;`
+	enableMFA           = `UPDATE user_mfa SET enabled_at = $2, last_step = $3 WHERE user_id = $1 AND enabled_at IS NULL;`
+	// useTOTPStep accepts only steps after the last used one, so codes can't be replayed
+	useTOTPStep     = `UPDATE user_mfa SET last_step = $2 WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_step < $2;`
+	useRecoveryCode = `
+    UPDATE mfa_recovery_codes SET used_at = $3
+    WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;`
+)
+
+type MFAAdapter interface {
+	CreateMFA(ctx context.Context, mfa *models.MFA, codeHashes []string) error
+	ReadMFA(ctx context.Context, userID string) (*models.MFA, error)
+	EnableMFA(ctx context.Context, userID string, step int64) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID, hash string) error
+}
+type mfaAdapter struct {
+	conn *sqlx.DB
+	MFAAdapter
+}
+
+func NewMFAAdapter(ctx context.Context, conn *sqlx.DB) *mfaAdapter {
+	m := &mfaAdapter{conn: conn}
+	err := m.createMFASchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create mfa schema")
+	}
+	return m
+}
+
+// CreateMFA starts enrollment with new secret and recovery codes, replacing unconfirmed one.
+// If user already has second factor enabled, it is models.ErrorMFAEnabled
+func (m *mfaAdapter) CreateMFA(ctx context.Context, mfa *models.MFA, codeHashes []string) error {
+	tx, err := m.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = notFoundIfNoRows(tx.ExecContext(ctx, createMFA, mfa.UserID, mfa.Secret, mfa.CreatedAt))
+	if errors.Is(err, models.ErrorNotFound) {
+		return models.ErrorMFAEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, deleteRecoveryCodes, mfa.UserID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err = tx.ExecContext(ctx, createRecoveryCode, mfa.UserID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ReadMFA returns second factor of user, enrolled or not, user without one is models.ErrorNotFound
+func (m *mfaAdapter) ReadMFA(ctx context.Context, userID string) (*models.MFA, error) {
+	var mfas []*models.MFA
+	if err := m.conn.SelectContext(ctx, &mfas, readMFA, userID); err != nil {
+		return nil, err
+	}
+	if len(mfas) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	return mfas[0], nil
+}
+
+// EnableMFA confirms enrollment by code of given step, which can't be used again
+func (m *mfaAdapter) EnableMFA(ctx context.Context, userID string, step int64) error {
+	return notFoundIfNoRows(m.conn.ExecContext(ctx, enableMFA, userID, time.Now(), step))
+}
+
+// UseTOTPStep spends step of enabled second factor, step used before is models.ErrorNotFound
+func (m *mfaAdapter) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return notFoundIfNoRows(m.conn.ExecContext(ctx, useTOTPStep, userID, step))
+}
+
+// UseRecoveryCode spends recovery code given by hash, unknown or used one is models.ErrorNotFound
+func (m *mfaAdapter) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	return notFoundIfNoRows(m.conn.ExecContext(ctx, useRecoveryCode, userID, hash, time.Now()))
+}
+
+func (m *mfaAdapter) createMFASchema(ctx context.Context) error {
+	_, err := m.conn.ExecContext(ctx, CreateMFASchema)
+	return err
+}
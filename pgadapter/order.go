@@ -6,6 +6,7 @@ import (
 	comp "github.com/gynshu-one/gophermart-loyalty-system/pgadapter/composer"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 const (
@@ -18,14 +19,21 @@ const (
         uploaded_at DATE NOT NULL,
 	    updated_at DATE NOT NULL
     );`
-	createOrder = `INSERT INTO orders (id, user_id, status, accrual, uploaded_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6);`
-	updateOrder = `UPDATE orders SET status = $1, accrual = $2, updated_at = $3 WHERE id = $4;`
+	createOrder  = `INSERT INTO orders (id, user_id, status, accrual, uploaded_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6);`
+	updateOrder  = `UPDATE orders SET status = $1, accrual = $2, updated_at = $3 WHERE id = $4;`
+	sumAccrual   = `SELECT COALESCE(SUM(accrual), 0) FROM orders WHERE user_id = $1 AND status = $2 AND updated_at >= $3;`
+	streamOrders = `
+    SELECT id, user_id, status, accrual, uploaded_at, updated_at FROM orders
+    WHERE user_id = $1 AND ($2::TIMESTAMPTZ IS NULL OR uploaded_at >= $2) AND ($3::TIMESTAMPTZ IS NULL OR uploaded_at < $3)
+    ORDER BY uploaded_at, id;`
 )
 
 type OrderAdapter interface {
 	CreateOrder(ctx context.Context, order *models.Order) error
 	ReadOrder(ctx context.Context, condition comp.Condition) ([]*models.Order, error)
 	UpdateOrders(ctx context.Context, orders ...*models.Order) error
+	SumAccrual(ctx context.Context, userID string, since time.Time) (float64, error)
+	StreamOrders(ctx context.Context, userID string, from, to time.Time, fn func(order *models.Order) error) error
 }
 type orderAdapter struct {
 	conn *sqlx.DB
@@ -81,6 +89,33 @@ func (o *orderAdapter) UpdateOrders(ctx context.Context, orders ...*models.Order
 	return nil
 }
 
+// SumAccrual returns sum of accruals of processed orders updated since given time
+func (o *orderAdapter) SumAccrual(ctx context.Context, userID string, since time.Time) (float64, error) {
+	var sum float64
+	err := o.conn.GetContext(ctx, &sum, sumAccrual, userID, models.OrderStatusProcessed, since)
+	return sum, err
+}
+
+// StreamOrders calls fn for every order of user uploaded in [from, to) one by one,
+// so long histories aren't loaded into memory. Zero from and to are unbounded
+func (o *orderAdapter) StreamOrders(ctx context.Context, userID string, from, to time.Time, fn func(order *models.Order) error) error {
+	rows, err := o.conn.QueryxContext(ctx, streamOrders, userID, nullTime(from), nullTime(to))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		order := &models.Order{}
+		if err = rows.StructScan(order); err != nil {
+			return err
+		}
+		if err = fn(order); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func (o *orderAdapter) createOrderSchema(ctx context.Context) error {
 	_, err := o.conn.ExecContext(ctx, createOrderSchema)
 	return err
@@ -0,0 +1,110 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreatePasswordResetSchema = `
+    CREATE TABLE IF NOT EXISTS password_resets (
+        token_hash VARCHAR(255) NOT NULL PRIMARY KEY,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        created_at TIMESTAMPTZ NOT NULL,
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets (user_id);`
+	// deleteUnusedResets leaves used tokens for the record, so only the latest issued token works
+	deleteUnusedResets  = `DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL;`
+	createPasswordReset = `
+    INSERT INTO password_resets (token_hash, user_id, created_at, expires_at)
+    VALUES ($1, $2, $3, $4);`
+	readPasswordReset = `SELECT user_id FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2;`
+	usePasswordReset  = `
+    UPDATE password_resets SET used_at = $3
+    WHERE token_hash = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > $3;`
+)
+
+type PasswordResetAdapter interface {
+	CreatePasswordReset(ctx context.Context, reset *models.PasswordReset) error
+	ReadPasswordReset(ctx context.Context, hash string) (string, error)
+	ResetPassword(ctx context.Context, hash, userID, password string) error
+}
+type passwordResetAdapter struct {
+	conn *sqlx.DB
+	PasswordResetAdapter
+}
+
+func NewPasswordResetAdapter(ctx context.Context, conn *sqlx.DB) *passwordResetAdapter {
+	p := &passwordResetAdapter{conn: conn}
+	err := p.createPasswordResetSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create password reset schema")
+	}
+	return p
+}
+
+// CreatePasswordReset stores new reset token of user, tokens issued before stop working
+func (p *passwordResetAdapter) CreatePasswordReset(ctx context.Context, reset *models.PasswordReset) error {
+	tx, err := p.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, deleteUnusedResets, reset.UserID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, createPasswordReset, reset.Hash, reset.UserID, reset.CreatedAt, reset.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReadPasswordReset returns whose reset token is without spending it,
+// unknown, expired and already used tokens are models.ErrorNotFound
+func (p *passwordResetAdapter) ReadPasswordReset(ctx context.Context, hash string) (string, error) {
+	var userIDs []string
+	if err := p.conn.SelectContext(ctx, &userIDs, readPasswordReset, hash, time.Now()); err != nil {
+		return "", err
+	}
+	if len(userIDs) == 0 {
+		return "", models.ErrorNotFound
+	}
+	return userIDs[0], nil
+}
+
+// ResetPassword spends reset token of user and replaces password of user together,
+// models.ErrorNotFound is returned if token was spent or expired meanwhile
+func (p *passwordResetAdapter) ResetPassword(ctx context.Context, hash, userID, password string) error {
+	hashedPassword, err := helpers.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	tx, err := p.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, usePasswordReset, hash, userID, time.Now())
+	if err = notFoundIfNoRows(result, err); err != nil {
+		return err
+	}
+	result, err = tx.ExecContext(ctx, updatePass, hashedPassword, userID)
+	if err = notFoundIfNoRows(result, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *passwordResetAdapter) createPasswordResetSchema(ctx context.Context) error {
+	_, err := p.conn.ExecContext(ctx, CreatePasswordResetSchema)
+	return err
+}
@@ -0,0 +1,118 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateReferralSchema = `
+    CREATE TABLE IF NOT EXISTS referrals (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        referrer_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        referred_id VARCHAR(255) NOT NULL UNIQUE REFERENCES users(id),
+        status VARCHAR(255) NOT NULL,
+        bonus FLOAT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL,
+        rewarded_at TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS referrals_referrer_id_idx ON referrals (referrer_id);`
+	createReferral = `INSERT INTO referrals (id, referrer_id, referred_id, status, bonus, created_at) VALUES ($1, $2, $3, $4, $5, $6);`
+	readReferrals  = `
+    SELECT r.id, r.referrer_id, r.referred_id, u.login AS referred_login, r.status, r.bonus, r.created_at, r.rewarded_at
+    FROM referrals r JOIN users u ON u.id = r.referred_id
+    WHERE r.referrer_id = $1 ORDER BY r.created_at;`
+	selectPendingReferral = `
+    SELECT id, referrer_id, referred_id, '' AS referred_login, status, bonus, created_at, rewarded_at
+    FROM referrals WHERE referred_id = $1 AND status = $2 FOR UPDATE;`
+	lockReferrer    = `SELECT id FROM users WHERE id = $1 FOR UPDATE;`
+	countRewarded   = `SELECT COUNT(*) FROM referrals WHERE referrer_id = $1 AND status = $2;`
+	resolveReferral = `UPDATE referrals SET status = $1, bonus = $2, rewarded_at = $3 WHERE id = $4;`
+)
+
+type ReferralAdapter interface {
+	CreateReferral(ctx context.Context, referral *models.Referral) error
+	ReadReferrals(ctx context.Context, referrerID string) ([]*models.Referral, error)
+	ClaimReferral(ctx context.Context, referredID string, bonus float64, limit int) (*models.Referral, error)
+}
+type referralAdapter struct {
+	conn *sqlx.DB
+	ReferralAdapter
+}
+
+func NewReferralAdapter(ctx context.Context, conn *sqlx.DB) *referralAdapter {
+	r := &referralAdapter{conn: conn}
+	err := r.createReferralSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create referral schema")
+	}
+	return r
+}
+
+func (r *referralAdapter) CreateReferral(ctx context.Context, referral *models.Referral) error {
+	_, err := r.conn.ExecContext(ctx, createReferral, referral.ID, referral.ReferrerID, referral.ReferredID, referral.Status, referral.Bonus, referral.CreatedAt)
+	return err
+}
+
+func (r *referralAdapter) ReadReferrals(ctx context.Context, referrerID string) ([]*models.Referral, error) {
+	var referrals []*models.Referral
+	err := r.conn.SelectContext(ctx, &referrals, readReferrals, referrerID)
+	return referrals, err
+}
+
+// ClaimReferral resolves pending referral of referred user exactly once.
+// Referral is rewarded with bonus credited to both users in the same transaction, unless referrer
+// has already reached the limit, then it is rejected. Returns nil if there is no pending referral
+func (r *referralAdapter) ClaimReferral(ctx context.Context, referredID string, bonus float64, limit int) (*models.Referral, error) {
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var pending []*models.Referral
+	err = tx.SelectContext(ctx, &pending, selectPendingReferral, referredID, models.ReferralStatusPending)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	referral := pending[0]
+
+	// Serialize claims of the same referrer so the limit can't be exceeded
+	if _, err = tx.ExecContext(ctx, lockReferrer, referral.ReferrerID); err != nil {
+		return nil, err
+	}
+	var rewarded int
+	if err = tx.GetContext(ctx, &rewarded, countRewarded, referral.ReferrerID, models.ReferralStatusRewarded); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	referral.Status = models.ReferralStatusRewarded
+	referral.Bonus = bonus
+	referral.RewardedAt = &now
+	if rewarded >= limit {
+		referral.Status = models.ReferralStatusRejected
+		referral.Bonus = 0
+		referral.RewardedAt = nil
+	}
+	_, err = tx.ExecContext(ctx, resolveReferral, referral.Status, referral.Bonus, referral.RewardedAt, referral.ID)
+	if err != nil {
+		return nil, err
+	}
+	if referral.Status == models.ReferralStatusRewarded && referral.Bonus > 0 {
+		for _, userID := range []string{referral.ReferredID, referral.ReferrerID} {
+			if err = creditLotTx(ctx, tx, newLot(userID, referral.Bonus, models.SourceReferral, referral.ID)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return referral, tx.Commit()
+}
+
+func (r *referralAdapter) createReferralSchema(ctx context.Context) error {
+	_, err := r.conn.ExecContext(ctx, CreateReferralSchema)
+	return err
+}
@@ -0,0 +1,196 @@
+package pgadapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	// CreateSessionSchema keeps scs session data in sessions and what users see about them in user_sessions
+	CreateSessionSchema = `
+    CREATE TABLE IF NOT EXISTS sessions (
+        token TEXT NOT NULL PRIMARY KEY,
+        data BYTEA NOT NULL,
+        expiry TIMESTAMPTZ NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry);
+    CREATE TABLE IF NOT EXISTS user_sessions (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        token TEXT NOT NULL UNIQUE,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        device TEXT NOT NULL,
+        ip VARCHAR(255) NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL,
+        last_seen_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS user_sessions_user_id_idx ON user_sessions (user_id);`
+	findSessionData   = `SELECT data FROM sessions WHERE token = $1 AND current_timestamp < expiry;`
+	commitSessionData = `
+    INSERT INTO sessions (token, data, expiry) VALUES ($1, $2, $3)
+    ON CONFLICT (token) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry;`
+	deleteSessionData = `DELETE FROM sessions WHERE token = $1;`
+	sessionFields     = `us.id, us.token, us.user_id, us.device, us.ip, us.created_at, us.last_seen_at`
+	createSession     = `
+    INSERT INTO user_sessions (id, token, user_id, device, ip, created_at, last_seen_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	readSessions = `
+    SELECT ` + sessionFields + ` FROM user_sessions us JOIN sessions s ON s.token = us.token
+    WHERE us.user_id = $1 AND current_timestamp < s.expiry
+    ORDER BY us.last_seen_at DESC;`
+	// touchSession updates last seen at most once a minute, so each request doesn't write
+	touchSession  = `UPDATE user_sessions SET last_seen_at = $2 WHERE token = $1 AND last_seen_at < $2 - INTERVAL '1 minute';`
+	deleteSession = `DELETE FROM user_sessions WHERE id = $1 AND user_id = $2 RETURNING token;`
+	// deleteUserSessions spares session $2, so user stays logged in where the request came from
+	deleteUserSessions = `
+    WITH ended AS (DELETE FROM user_sessions WHERE user_id = $1 AND id <> $2 RETURNING token)
+    DELETE FROM sessions WHERE token IN (SELECT token FROM ended);`
+	deleteExpiredData = `DELETE FROM sessions WHERE expiry < current_timestamp;`
+	// deleteOrphanedMeta spares sessions created after $1, their data is committed only when login request ends
+	deleteOrphanedMeta = `
+    DELETE FROM user_sessions us
+    WHERE us.created_at < $1 AND NOT EXISTS (SELECT 1 FROM sessions s WHERE s.token = us.token);`
+)
+
+// sessionCleanupGrace is how long new session may have no data
+const sessionCleanupGrace = time.Minute
+
+// SessionAdapter is scs store of server-side sessions, which also knows whose sessions they are
+type SessionAdapter interface {
+	Find(token string) ([]byte, bool, error)
+	Commit(token string, b []byte, expiry time.Time) error
+	Delete(token string) error
+	FindCtx(ctx context.Context, token string) ([]byte, bool, error)
+	CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error
+	DeleteCtx(ctx context.Context, token string) error
+	CreateSession(ctx context.Context, session *models.Session) error
+	ReadSessions(ctx context.Context, userID string) ([]*models.Session, error)
+	TouchSession(ctx context.Context, token string) error
+	DeleteSession(ctx context.Context, userID, id string) error
+	DeleteUserSessions(ctx context.Context, userID, exceptID string) error
+	DeleteExpiredSessions(ctx context.Context) (int, error)
+}
+type sessionAdapter struct {
+	conn *sqlx.DB
+	SessionAdapter
+}
+
+func NewSessionAdapter(ctx context.Context, conn *sqlx.DB) *sessionAdapter {
+	s := &sessionAdapter{conn: conn}
+	err := s.createSessionSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create session schema")
+	}
+	return s
+}
+
+func (s *sessionAdapter) Find(token string) ([]byte, bool, error) {
+	return s.FindCtx(context.Background(), token)
+}
+
+func (s *sessionAdapter) Commit(token string, b []byte, expiry time.Time) error {
+	return s.CommitCtx(context.Background(), token, b, expiry)
+}
+
+func (s *sessionAdapter) Delete(token string) error {
+	return s.DeleteCtx(context.Background(), token)
+}
+
+// FindCtx returns data of unexpired session, unknown token isn't an error
+func (s *sessionAdapter) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	var data []byte
+	err := s.conn.GetContext(ctx, &data, findSessionData, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (s *sessionAdapter) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	_, err := s.conn.ExecContext(ctx, commitSessionData, token, b, expiry)
+	return err
+}
+
+func (s *sessionAdapter) DeleteCtx(ctx context.Context, token string) error {
+	_, err := s.conn.ExecContext(ctx, deleteSessionData, token)
+	return err
+}
+
+func (s *sessionAdapter) CreateSession(ctx context.Context, session *models.Session) error {
+	_, err := s.conn.ExecContext(ctx, createSession, session.ID, session.Token, session.UserID,
+		session.Device, session.IP, session.CreatedAt, session.LastSeenAt)
+	return err
+}
+
+// ReadSessions returns unexpired sessions of user, most recently used first
+func (s *sessionAdapter) ReadSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	var sessions []*models.Session
+	err := s.conn.SelectContext(ctx, &sessions, readSessions, userID)
+	return sessions, err
+}
+
+func (s *sessionAdapter) TouchSession(ctx context.Context, token string) error {
+	_, err := s.conn.ExecContext(ctx, touchSession, token, time.Now())
+	return err
+}
+
+// DeleteSession ends session of user given by id, its token stops working at once
+func (s *sessionAdapter) DeleteSession(ctx context.Context, userID, id string) error {
+	tx, err := s.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var tokens []string
+	if err = tx.SelectContext(ctx, &tokens, deleteSession, id, userID); err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return models.ErrorNotFound
+	}
+	if _, err = tx.ExecContext(ctx, deleteSessionData, tokens[0]); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUserSessions ends all sessions of user but exceptID, which may be empty
+func (s *sessionAdapter) DeleteUserSessions(ctx context.Context, userID, exceptID string) error {
+	_, err := s.conn.ExecContext(ctx, deleteUserSessions, userID, exceptID)
+	return err
+}
+
+// DeleteExpiredSessions removes expired session data and sessions left without data, e.g. after token renewal
+func (s *sessionAdapter) DeleteExpiredSessions(ctx context.Context) (int, error) {
+	tx, err := s.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, deleteExpiredData); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, deleteOrphanedMeta, time.Now().Add(-sessionCleanupGrace))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(deleted), tx.Commit()
+}
+
+func (s *sessionAdapter) createSessionSchema(ctx context.Context) error {
+	_, err := s.conn.ExecContext(ctx, CreateSessionSchema)
+	return err
+}
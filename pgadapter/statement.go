@@ -0,0 +1,159 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateStatementSchema = `
+    CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+    CREATE INDEX IF NOT EXISTS withdrawals_user_id_idx ON withdrawals (user_id);
+    CREATE TABLE IF NOT EXISTS monthly_statements (
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        month VARCHAR(7) NOT NULL,
+        opening FLOAT NOT NULL,
+        accrued FLOAT NOT NULL,
+        withdrawn FLOAT NOT NULL,
+        expired FLOAT NOT NULL,
+        closing FLOAT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL,
+        PRIMARY KEY (user_id, month)
+    );`
+	// statementEvents lists every event that changed personal balance of user $1.
+	// Accruals pooled into a household, payments from its pool and expiry of its lots didn't touch
+	// personal balance, so they are skipped. Restoring adjustments only undo changes missing here, so are they.
+	// Orders show accrual of accrual system, tier and campaign bonuses come as separate lots
+	statementEvents = `
+    SELECT 'ACCRUAL' AS kind, o.id AS reference, o.accrual AS amount, o.updated_at::TIMESTAMPTZ AS occurred_at
+    FROM orders o
+    WHERE o.user_id = $1 AND o.status = 'PROCESSED' AND o.accrual > 0
+        AND NOT EXISTS (SELECT 1 FROM household_entries e WHERE e.order_id = o.id AND e.kind = 'ACCRUAL')
+    UNION ALL
+    SELECT 'WITHDRAWAL', w.order_id, -w.sum, w.processed_at::TIMESTAMPTZ
+    FROM withdrawals w WHERE w.user_id = $1 AND w.household_id IS NULL
+    UNION ALL
+    SELECT l.source, l.reference, l.amount, l.accrued_at
+    FROM point_lots l WHERE l.user_id = $1 AND l.household_id IS NULL AND l.source IN ('REFERRAL', 'VOUCHER', 'BONUS', 'CAMPAIGN')
+    UNION ALL
+    SELECT 'TRANSFER_IN', t.id, t.amount, t.created_at
+    FROM transfers t WHERE t.receiver_id = $1
+    UNION ALL
+    SELECT 'TRANSFER_OUT', t.id, -t.amount, t.created_at
+    FROM transfers t WHERE t.sender_id = $1
+    UNION ALL
+    SELECT 'EXPIRATION', x.lot_id, -x.amount, x.expired_at
+    FROM point_expirations x JOIN point_lots l ON l.id = x.lot_id WHERE x.user_id = $1 AND l.household_id IS NULL
+    UNION ALL
+    SELECT 'ADJUSTMENT', a.id, a.amount, a.created_at
+    FROM balance_adjustments a WHERE a.user_id = $1 AND NOT a.restores`
+	// readStatement computes running balance over whole history before filtering,
+	// so the balance of the first entry of a page is still correct
+	readStatement = `
+    WITH events AS (` + statementEvents + `
+    ), ledger AS (
+        SELECT kind, reference, amount, occurred_at,
+            SUM(amount) OVER (ORDER BY occurred_at, kind, reference ROWS UNBOUNDED PRECEDING) AS balance
+        FROM events
+    )
+    SELECT kind, reference, amount, balance, occurred_at FROM ledger
+    WHERE ($2::TIMESTAMPTZ IS NULL OR occurred_at >= $2) AND ($3::TIMESTAMPTZ IS NULL OR occurred_at < $3)
+    ORDER BY occurred_at, kind, reference
+    LIMIT $4 OFFSET $5;`
+	selectUnstated = `
+    SELECT id FROM users u
+    WHERE NOT EXISTS (SELECT 1 FROM monthly_statements s WHERE s.user_id = u.id AND s.month = $1)
+    ORDER BY id LIMIT $2;`
+	// createMonthlyStatement summarizes events of user $1 in month $2 which lasts from $3 till $4.
+	// Existing statement is never overwritten, so re-runs can't change issued statements
+	createMonthlyStatement = `
+    WITH events AS (` + statementEvents + `
+    )
+    INSERT INTO monthly_statements (user_id, month, opening, accrued, withdrawn, expired, closing, created_at)
+    SELECT $1, $2,
+        COALESCE(SUM(amount) FILTER (WHERE occurred_at < $3), 0),
+        COALESCE(SUM(amount) FILTER (WHERE occurred_at >= $3 AND amount > 0 AND kind != 'EXPIRATION'), 0),
+        COALESCE(-SUM(amount) FILTER (WHERE occurred_at >= $3 AND amount < 0 AND kind != 'EXPIRATION'), 0),
+        COALESCE(-SUM(amount) FILTER (WHERE occurred_at >= $3 AND kind = 'EXPIRATION'), 0),
+        COALESCE(SUM(amount), 0),
+        $5
+    FROM events WHERE occurred_at < $4
+    ON CONFLICT DO NOTHING;`
+	readMonthlyStatement = `
+    SELECT user_id, month, opening, accrued, withdrawn, expired, closing, created_at
+    FROM monthly_statements WHERE user_id = $1 AND month = $2;`
+)
+
+// statementBatch limits the number of monthly statements generated in one call
+const statementBatch = 500
+
+// MonthLayout is the format of monthly statement keys
+const MonthLayout = "2006-01"
+
+type StatementAdapter interface {
+	ReadStatement(ctx context.Context, userID string, filter models.StatementFilter) ([]*models.StatementEntry, error)
+	GenerateMonthlyStatements(ctx context.Context, month time.Time) (int, error)
+	ReadMonthlyStatement(ctx context.Context, userID, month string) (*models.MonthlyStatement, error)
+}
+type statementAdapter struct {
+	conn *sqlx.DB
+	StatementAdapter
+}
+
+func NewStatementAdapter(ctx context.Context, conn *sqlx.DB) *statementAdapter {
+	s := &statementAdapter{conn: conn}
+	err := s.createStatementSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create statement schema")
+	}
+	return s
+}
+
+// ReadStatement returns page of balance-affecting events in chronological order.
+// Orders and withdrawals keep only dates, so they go before other events of the same day
+func (s *statementAdapter) ReadStatement(ctx context.Context, userID string, filter models.StatementFilter) ([]*models.StatementEntry, error) {
+	var entries []*models.StatementEntry
+	err := s.conn.SelectContext(ctx, &entries, readStatement, userID,
+		nullTime(filter.From), nullTime(filter.To), filter.Limit, filter.Offset)
+	return entries, err
+}
+
+// GenerateMonthlyStatements creates statements of the month starting at given time for next batch of users
+// who don't have one yet. Returns the number of users processed, 0 means the month is done.
+// Every statement is stored separately, so interrupted generation resumes where it stopped
+func (s *statementAdapter) GenerateMonthlyStatements(ctx context.Context, month time.Time) (int, error) {
+	key := month.Format(MonthLayout)
+	var users []string
+	if err := s.conn.SelectContext(ctx, &users, selectUnstated, key, statementBatch); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for _, userID := range users {
+		_, err := s.conn.ExecContext(ctx, createMonthlyStatement, userID, key, month, month.AddDate(0, 1, 0), now)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
+
+// ReadMonthlyStatement returns models.ErrorNotFound if statement of the month isn't generated
+func (s *statementAdapter) ReadMonthlyStatement(ctx context.Context, userID, month string) (*models.MonthlyStatement, error) {
+	var statements []*models.MonthlyStatement
+	err := s.conn.SelectContext(ctx, &statements, readMonthlyStatement, userID, month)
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	return statements[0], nil
+}
+
+func (s *statementAdapter) createStatementSchema(ctx context.Context) error {
+	_, err := s.conn.ExecContext(ctx, CreateStatementSchema)
+	return err
+}
@@ -0,0 +1,83 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CreateTierSchema = `
+    CREATE TABLE IF NOT EXISTS tier_changes (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        tier VARCHAR(255) NOT NULL,
+        accrued FLOAT NOT NULL,
+        changed_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS tier_changes_user_id_idx ON tier_changes (user_id, changed_at);`
+	createTierChange   = `INSERT INTO tier_changes (id, user_id, tier, accrued, changed_at) VALUES ($1, $2, $3, $4, $5);`
+	readLastTierChange = `SELECT id, user_id, tier, accrued, changed_at FROM tier_changes WHERE user_id = $1 ORDER BY changed_at DESC LIMIT 1;`
+	lockTierUser       = `SELECT id FROM users WHERE id = $1 FOR UPDATE;`
+)
+
+type TierAdapter interface {
+	CreateTierChange(ctx context.Context, change *models.TierChange) (*models.TierChange, error)
+	ReadLastTierChange(ctx context.Context, userID string) (*models.TierChange, error)
+}
+type tierAdapter struct {
+	conn *sqlx.DB
+	TierAdapter
+}
+
+func NewTierAdapter(ctx context.Context, conn *sqlx.DB) *tierAdapter {
+	t := &tierAdapter{conn: conn}
+	err := t.createTierSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create tier schema")
+	}
+	return t
+}
+
+// CreateTierChange records change unless the last change of user is already to the same tier.
+// Evaluations of the same user are serialized, so concurrent ones can't record the change twice.
+// Returns the last change of user, which is change itself if it was recorded
+func (t *tierAdapter) CreateTierChange(ctx context.Context, change *models.TierChange) (*models.TierChange, error) {
+	tx, err := t.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, lockTierUser, change.UserID); err != nil {
+		return nil, err
+	}
+	var changes []*models.TierChange
+	if err = tx.SelectContext(ctx, &changes, readLastTierChange, change.UserID); err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 && changes[0].Tier == change.Tier {
+		return changes[0], nil
+	}
+	_, err = tx.ExecContext(ctx, createTierChange, change.ID, change.UserID, change.Tier, change.Accrued, change.ChangedAt)
+	if err != nil {
+		return nil, err
+	}
+	return change, tx.Commit()
+}
+
+// ReadLastTierChange returns nil if user has never changed tier
+func (t *tierAdapter) ReadLastTierChange(ctx context.Context, userID string) (*models.TierChange, error) {
+	var changes []*models.TierChange
+	err := t.conn.SelectContext(ctx, &changes, readLastTierChange, userID)
+	if len(changes) == 0 {
+		return nil, err
+	}
+	return changes[0], err
+}
+
+func (t *tierAdapter) createTierSchema(ctx context.Context) error {
+	_, err := t.conn.ExecContext(ctx, CreateTierSchema)
+	return err
+}
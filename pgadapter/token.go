@@ -0,0 +1,162 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateTokenSchema = `
+    CREATE TABLE IF NOT EXISTS token_families (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        created_at TIMESTAMPTZ NOT NULL,
+        revoked_at TIMESTAMPTZ
+    );
+    CREATE TABLE IF NOT EXISTS refresh_tokens (
+        token_hash VARCHAR(255) NOT NULL PRIMARY KEY,
+        family_id VARCHAR(255) NOT NULL REFERENCES token_families(id),
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS token_families_user_id_idx ON token_families (user_id);`
+	createFamily       = `INSERT INTO token_families (id, user_id, created_at) VALUES ($1, $2, $3);`
+	createRefreshToken = `
+    INSERT INTO refresh_tokens (token_hash, family_id, user_id, expires_at, created_at)
+    VALUES ($1, $2, $3, $4, $5);`
+	lockRefreshToken = `
+    SELECT t.token_hash, t.family_id, t.user_id, t.expires_at, t.used_at, t.created_at, f.revoked_at
+    FROM refresh_tokens t JOIN token_families f ON f.id = t.family_id
+    WHERE t.token_hash = $1 FOR UPDATE;`
+	useRefreshToken = `UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1;`
+	revokeFamily    = `UPDATE token_families SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL;`
+	// revokeUserFamilies spares family $2, so user stays logged in where the request came from
+	revokeUserFamilies = `UPDATE token_families SET revoked_at = $3 WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL;`
+	readFamily         = `SELECT id, user_id, created_at, revoked_at FROM token_families WHERE id = $1;`
+)
+
+type TokenAdapter interface {
+	CreateFamily(ctx context.Context, family *models.TokenFamily, token *models.RefreshToken) error
+	RotateRefreshToken(ctx context.Context, hash string, next *models.RefreshToken) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	ReadFamily(ctx context.Context, familyID string) (*models.TokenFamily, error)
+	RevokeUserFamilies(ctx context.Context, userID, exceptID string) error
+}
+type tokenAdapter struct {
+	conn *sqlx.DB
+	TokenAdapter
+}
+
+func NewTokenAdapter(ctx context.Context, conn *sqlx.DB) *tokenAdapter {
+	t := &tokenAdapter{conn: conn}
+	err := t.createTokenSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create token schema")
+	}
+	return t
+}
+
+// CreateFamily starts new session with its first refresh token
+func (t *tokenAdapter) CreateFamily(ctx context.Context, family *models.TokenFamily, token *models.RefreshToken) error {
+	tx, err := t.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, createFamily, family.ID, family.UserID, family.CreatedAt); err != nil {
+		return err
+	}
+	token.FamilyID, token.UserID = family.ID, family.UserID
+	if err = createRefreshTokenTx(ctx, tx, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RotateRefreshToken exchanges token given by hash for next one of the same family, filling its FamilyID and UserID.
+// Unknown and expired tokens are models.ErrorNotFound. Token used for the second time means it was stolen,
+// so its whole family is revoked and models.ErrorTokenReused is returned
+func (t *tokenAdapter) RotateRefreshToken(ctx context.Context, hash string, next *models.RefreshToken) error {
+	tx, err := t.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var tokens []*struct {
+		models.RefreshToken
+		RevokedAt *time.Time `db:"revoked_at"`
+	}
+	if err = tx.SelectContext(ctx, &tokens, lockRefreshToken, hash); err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return models.ErrorNotFound
+	}
+	token := tokens[0]
+	if token.RevokedAt != nil {
+		return models.ErrorSessionRevoked
+	}
+	now := time.Now()
+	if token.UsedAt != nil {
+		if _, err = tx.ExecContext(ctx, revokeFamily, token.FamilyID, now); err != nil {
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		next.FamilyID, next.UserID = token.FamilyID, token.UserID
+		return models.ErrorTokenReused
+	}
+	if !token.ExpiresAt.After(now) {
+		return models.ErrorNotFound
+	}
+
+	if _, err = tx.ExecContext(ctx, useRefreshToken, hash, now); err != nil {
+		return err
+	}
+	next.FamilyID, next.UserID = token.FamilyID, token.UserID
+	if err = createRefreshTokenTx(ctx, tx, next); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeFamily ends session, its access and refresh tokens stop working
+func (t *tokenAdapter) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := t.conn.ExecContext(ctx, revokeFamily, familyID, time.Now())
+	return err
+}
+
+// RevokeUserFamilies ends all sessions of user but exceptID, which may be empty
+func (t *tokenAdapter) RevokeUserFamilies(ctx context.Context, userID, exceptID string) error {
+	_, err := t.conn.ExecContext(ctx, revokeUserFamilies, userID, exceptID, time.Now())
+	return err
+}
+
+func (t *tokenAdapter) ReadFamily(ctx context.Context, familyID string) (*models.TokenFamily, error) {
+	var families []*models.TokenFamily
+	if err := t.conn.SelectContext(ctx, &families, readFamily, familyID); err != nil {
+		return nil, err
+	}
+	if len(families) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	return families[0], nil
+}
+
+func createRefreshTokenTx(ctx context.Context, tx *sqlx.Tx, token *models.RefreshToken) error {
+	_, err := tx.ExecContext(ctx, createRefreshToken, token.Hash, token.FamilyID, token.UserID, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+func (t *tokenAdapter) createTokenSchema(ctx context.Context) error {
+	_, err := t.conn.ExecContext(ctx, CreateTokenSchema)
+	return err
+}
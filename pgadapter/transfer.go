@@ -0,0 +1,132 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateTransferSchema = `
+    CREATE TABLE IF NOT EXISTS transfers (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        sender_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        receiver_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        amount FLOAT NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS transfers_sender_id_idx ON transfers (sender_id, created_at);
+    CREATE INDEX IF NOT EXISTS transfers_receiver_id_idx ON transfers (receiver_id, created_at);`
+	lockBalances   = `SELECT user_id FROM balances WHERE user_id IN ($1, $2) ORDER BY user_id FOR UPDATE;`
+	sumSent        = `SELECT COALESCE(SUM(amount), 0) FROM transfers WHERE sender_id = $1 AND created_at >= $2;`
+	debitBalance   = `UPDATE balances SET amount = amount - $1 WHERE user_id = $2 AND amount >= $1;`
+	createTransfer = `INSERT INTO transfers (id, sender_id, receiver_id, amount, created_at) VALUES ($1, $2, $3, $4, $5);`
+	readTransfers  = `
+    SELECT t.id, t.sender_id, s.login AS sender_login, t.receiver_id, r.login AS receiver_login, t.amount, t.created_at
+    FROM transfers t
+    JOIN users s ON s.id = t.sender_id
+    JOIN users r ON r.id = t.receiver_id
+    WHERE t.sender_id = $1 OR t.receiver_id = $1
+    ORDER BY t.created_at DESC;`
+)
+
+type TransferAdapter interface {
+	CreateTransfer(ctx context.Context, transfer *models.Transfer, dailyLimit float64) error
+	ReadTransfers(ctx context.Context, userID string) ([]*models.Transfer, error)
+}
+type transferAdapter struct {
+	conn *sqlx.DB
+	TransferAdapter
+}
+
+func NewTransferAdapter(ctx context.Context, conn *sqlx.DB) *transferAdapter {
+	t := &transferAdapter{conn: conn}
+	err := t.createTransferSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create transfer schema")
+	}
+	return t
+}
+
+// CreateTransfer moves points from sender to receiver in one transaction.
+// Sender must have enough points and stay within daily limit (0 means unlimited).
+// Receiver gets the sender's oldest lots with their original expiration, so transfers can't extend points lifetime
+func (t *transferAdapter) CreateTransfer(ctx context.Context, transfer *models.Transfer, dailyLimit float64) error {
+	tx, err := t.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock both balances in the same order, so opposite transfers don't deadlock
+	if _, err = tx.ExecContext(ctx, lockBalances, transfer.SenderID, transfer.ReceiverID); err != nil {
+		return err
+	}
+
+	if dailyLimit > 0 {
+		var sent float64
+		dayStart := transfer.CreatedAt.Truncate(24 * time.Hour)
+		if err = tx.GetContext(ctx, &sent, sumSent, transfer.SenderID, dayStart); err != nil {
+			return err
+		}
+		if sent+transfer.Amount > dailyLimit {
+			return models.ErrorTransferLimit
+		}
+	}
+
+	// Same rule as IncrementWithdrawn, balance can't go below zero
+	result, err := tx.ExecContext(ctx, debitBalance, transfer.Amount, transfer.SenderID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrorInsufficientFunds
+	}
+
+	consumed, err := consumeLotsTx(ctx, tx, transfer.SenderID, transfer.Amount)
+	if err != nil {
+		return err
+	}
+	left := transfer.Amount
+	for _, portion := range consumed {
+		lot := newLot(transfer.ReceiverID, portion.Amount, models.SourceTransfer, transfer.ID)
+		lot.ExpiresAt = portion.ExpiresAt
+		if err = creditLotTx(ctx, tx, lot); err != nil {
+			return err
+		}
+		left -= portion.Amount
+	}
+
+	// Points credited before lots were introduced never expire
+	if left > 0 {
+		lot := newLot(transfer.ReceiverID, left, models.SourceTransfer, transfer.ID)
+		lot.ExpiresAt = nil
+		if err = creditLotTx(ctx, tx, lot); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, createTransfer, transfer.ID, transfer.SenderID, transfer.ReceiverID, transfer.Amount, transfer.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReadTransfers returns both sent and received transfers of user
+func (t *transferAdapter) ReadTransfers(ctx context.Context, userID string) ([]*models.Transfer, error) {
+	var transfers []*models.Transfer
+	err := t.conn.SelectContext(ctx, &transfers, readTransfers, userID)
+	return transfers, err
+}
+
+func (t *transferAdapter) createTransferSchema(ctx context.Context) error {
+	_, err := t.conn.ExecContext(ctx, CreateTransferSchema)
+	return err
+}
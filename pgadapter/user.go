@@ -9,12 +9,20 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 	"strings"
+	"time"
 )
 
 const (
 	createBalance = `INSERT INTO balances (id, user_id, amount, withdrawn) VALUES ($1, $2, $3, $4);`
-	createUser    = `INSERT INTO users (id, login, password) VALUES ($1, $2, $3);`
-	readUser      = `SELECT id, login, password FROM users WHERE login = $1;`
+	createUser    = `INSERT INTO users (id, login, password, referral_code, role) VALUES ($1, $2, $3, $4, $5);`
+	userFields    = `id, login, password, referral_code, locked_at, role`
+	readUser      = `SELECT ` + userFields + ` FROM users WHERE login = $1;`
+	readUserByID  = `SELECT ` + userFields + ` FROM users WHERE id = $1;`
+	readUserByRef = `SELECT ` + userFields + ` FROM users WHERE referral_code = $1;`
+	searchUsers   = `SELECT ` + userFields + ` FROM users WHERE login ILIKE $1 ESCAPE '\' ORDER BY login LIMIT $2;`
+	updateLocked  = `UPDATE users SET locked_at = $1 WHERE id = $2;`
+	updateRole    = `UPDATE users SET role = $1 WHERE id = $2;`
+	updatePass    = `UPDATE users SET password = $1 WHERE id = $2;`
 )
 const (
 	CreateUserSchema = `
@@ -22,12 +30,22 @@ const (
         id VARCHAR(255) NOT NULL PRIMARY KEY,
         login VARCHAR(255) NOT NULL UNIQUE,
         password VARCHAR(255) NOT NULL
-    );`
+    );
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code VARCHAR(255) UNIQUE;
+    UPDATE users SET referral_code = upper(substr(md5(id), 1, 13)) WHERE referral_code IS NULL;
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(255) NOT NULL DEFAULT 'customer';`
 )
 
 type UserAdapter interface {
 	CreateUser(ctx context.Context, user *models.User) error
 	ReadUser(ctx context.Context, id string) (*models.User, error)
+	ReadUserByID(ctx context.Context, id string) (*models.User, error)
+	ReadUserByReferralCode(ctx context.Context, code string) (*models.User, error)
+	SearchUsers(ctx context.Context, login string, limit int) ([]*models.User, error)
+	SetLocked(ctx context.Context, id string, locked bool) error
+	SetRole(ctx context.Context, id, role string) error
+	SetPassword(ctx context.Context, id, password string) error
 }
 type userAdapter struct {
 	conn *sqlx.DB
@@ -43,33 +61,20 @@ func NewAdapter(ctx context.Context, conn *sqlx.DB) *userAdapter {
 	return a
 }
 
+// CreateUser creates user logging in by password, logins with models.LoginSeparator are left to OIDC users
 func (u *userAdapter) CreateUser(ctx context.Context, user *models.User) error {
-	hashedPassword, err := helpers.HashPassword(user.Password)
-	if err != nil {
-		log.Warn().Err(err).Msg("failed to hash password")
-		hashedPassword = user.Password
+	if strings.Contains(user.Login, models.LoginSeparator) {
+		return models.ErrorReservedLogin
 	}
 	tx, err := u.conn.BeginTxx(ctx, nil)
-
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	_, err = u.conn.ExecContext(ctx, createUser, user.ID, user.Login, hashedPassword)
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
-			return models.ErrorUserAlreadyExists
-		}
-		tx.Rollback()
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx, createBalance, uuid.New().String(), user.ID, 0, 0)
-	if err != nil {
-		tx.Rollback()
+	if err = createUserTx(ctx, tx, user); err != nil {
 		return err
 	}
-
 	return tx.Commit()
 }
 
@@ -79,6 +84,74 @@ func (u *userAdapter) ReadUser(ctx context.Context, id string) (*models.User, er
 	return user, err
 }
 
+func (u *userAdapter) ReadUserByID(ctx context.Context, id string) (*models.User, error) {
+	user := &models.User{}
+	err := u.conn.GetContext(ctx, user, readUserByID, id)
+	return user, err
+}
+
+func (u *userAdapter) ReadUserByReferralCode(ctx context.Context, code string) (*models.User, error) {
+	user := &models.User{}
+	err := u.conn.GetContext(ctx, user, readUserByRef, code)
+	return user, err
+}
+
+// SearchUsers finds users whose login contains given string
+func (u *userAdapter) SearchUsers(ctx context.Context, login string, limit int) ([]*models.User, error) {
+	var users []*models.User
+	pattern := "%" + likeEscaper.Replace(login) + "%"
+	err := u.conn.SelectContext(ctx, &users, searchUsers, pattern, limit)
+	return users, err
+}
+
+// SetLocked locks or unlocks account, locked users can't log in or use their tokens
+func (u *userAdapter) SetLocked(ctx context.Context, id string, locked bool) error {
+	var lockedAt *time.Time
+	if locked {
+		now := time.Now()
+		lockedAt = &now
+	}
+	result, err := u.conn.ExecContext(ctx, updateLocked, lockedAt, id)
+	return notFoundIfNoRows(result, err)
+}
+
+// SetRole changes role of user, it takes effect when user refreshes token
+func (u *userAdapter) SetRole(ctx context.Context, id, role string) error {
+	result, err := u.conn.ExecContext(ctx, updateRole, role, id)
+	return notFoundIfNoRows(result, err)
+}
+
+// SetPassword replaces password of user, it is stored hashed
+func (u *userAdapter) SetPassword(ctx context.Context, id, password string) error {
+	hashedPassword, err := helpers.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	result, err := u.conn.ExecContext(ctx, updatePass, hashedPassword, id)
+	return notFoundIfNoRows(result, err)
+}
+
+// createUserTx creates user with hashed password and empty balance, user gets referral code and default role
+func createUserTx(ctx context.Context, tx *sqlx.Tx, user *models.User) error {
+	hashedPassword, err := helpers.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	user.ReferralCode = helpers.GenerateCode(8)
+	if user.Role == "" {
+		user.Role = models.RoleCustomer
+	}
+	_, err = tx.ExecContext(ctx, createUser, user.ID, user.Login, hashedPassword, user.ReferralCode, user.Role)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return models.ErrorUserAlreadyExists
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, createBalance, uuid.New().String(), user.ID, 0, 0)
+	return err
+}
+
 func (u *userAdapter) createUserSchema(ctx context.Context) error {
 	_, err := u.conn.ExecContext(ctx, CreateUserSchema)
 	return err
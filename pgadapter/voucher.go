@@ -0,0 +1,141 @@
+package pgadapter
+
+import (
+	"context"
+	"github.com/gynshu-one/gophermart-loyalty-system/helpers"
+	"github.com/gynshu-one/gophermart-loyalty-system/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	CreateVoucherSchema = `
+    CREATE TABLE IF NOT EXISTS vouchers (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        code_hash VARCHAR(255) NOT NULL UNIQUE,
+        points FLOAT NOT NULL,
+        max_redemptions INT NOT NULL,
+        redemptions INT NOT NULL,
+        expires_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS voucher_redemptions (
+        id VARCHAR(255) NOT NULL PRIMARY KEY,
+        voucher_id VARCHAR(255) NOT NULL REFERENCES vouchers(id),
+        user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+        points FLOAT NOT NULL,
+        redeemed_at TIMESTAMPTZ NOT NULL,
+        UNIQUE (voucher_id, user_id)
+    );`
+	voucherFields     = `id, code_hash, points, max_redemptions, redemptions, expires_at, created_at`
+	createVoucher     = `INSERT INTO vouchers (` + voucherFields + `) VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	readVouchers      = `SELECT ` + voucherFields + ` FROM vouchers ORDER BY created_at DESC;`
+	lockVoucher       = `SELECT ` + voucherFields + ` FROM vouchers WHERE code_hash = $1 FOR UPDATE;`
+	readRedemption    = `SELECT id, voucher_id, user_id, points, redeemed_at FROM voucher_redemptions WHERE voucher_id = $1 AND user_id = $2;`
+	createRedemption  = `INSERT INTO voucher_redemptions (id, voucher_id, user_id, points, redeemed_at) VALUES ($1, $2, $3, $4, $5);`
+	incrementRedeemed = `UPDATE vouchers SET redemptions = redemptions + 1 WHERE id = $1;`
+)
+
+type VoucherAdapter interface {
+	CreateVouchers(ctx context.Context, vouchers ...*models.Voucher) error
+	ReadVouchers(ctx context.Context) ([]*models.Voucher, error)
+	RedeemVoucher(ctx context.Context, codeHash, userID string) (*models.VoucherRedemption, error)
+}
+type voucherAdapter struct {
+	conn *sqlx.DB
+	VoucherAdapter
+}
+
+func NewVoucherAdapter(ctx context.Context, conn *sqlx.DB) *voucherAdapter {
+	v := &voucherAdapter{conn: conn}
+	err := v.createVoucherSchema(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create voucher schema")
+	}
+	return v
+}
+
+func (v *voucherAdapter) CreateVouchers(ctx context.Context, vouchers ...*models.Voucher) error {
+	tx, err := v.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, voucher := range vouchers {
+		_, err = tx.ExecContext(ctx, createVoucher, voucher.ID, voucher.CodeHash, voucher.Points,
+			voucher.MaxRedemptions, voucher.Redemptions, voucher.ExpiresAt, voucher.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (v *voucherAdapter) ReadVouchers(ctx context.Context) ([]*models.Voucher, error) {
+	var vouchers []*models.Voucher
+	err := v.conn.SelectContext(ctx, &vouchers, readVouchers)
+	return vouchers, err
+}
+
+// RedeemVoucher credits voucher points to user and records redemption in one transaction.
+// Redeeming the same voucher by the same user again returns the first redemption without crediting twice
+func (v *voucherAdapter) RedeemVoucher(ctx context.Context, codeHash, userID string) (*models.VoucherRedemption, error) {
+	tx, err := v.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var vouchers []*models.Voucher
+	if err = tx.SelectContext(ctx, &vouchers, lockVoucher, codeHash); err != nil {
+		return nil, err
+	}
+	if len(vouchers) == 0 {
+		return nil, models.ErrorNotFound
+	}
+	voucher := vouchers[0]
+
+	var redeemed []*models.VoucherRedemption
+	if err = tx.SelectContext(ctx, &redeemed, readRedemption, voucher.ID, userID); err != nil {
+		return nil, err
+	}
+	if len(redeemed) > 0 {
+		return redeemed[0], nil
+	}
+
+	now := time.Now()
+	if voucher.ExpiresAt != nil && !voucher.ExpiresAt.After(now) {
+		return nil, models.ErrorVoucherExpired
+	}
+	if voucher.Redemptions >= voucher.MaxRedemptions {
+		return nil, models.ErrorVoucherExhausted
+	}
+
+	redemption := &models.VoucherRedemption{
+		ID:         helpers.GenerateUUID(),
+		VoucherID:  voucher.ID,
+		UserID:     userID,
+		Points:     voucher.Points,
+		RedeemedAt: now,
+	}
+	_, err = tx.ExecContext(ctx, createRedemption, redemption.ID, redemption.VoucherID, redemption.UserID, redemption.Points, redemption.RedeemedAt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, incrementRedeemed, voucher.ID); err != nil {
+		return nil, err
+	}
+
+	// Voucher points are kept as a lot, so they show up in history and expire like accruals
+	if err = creditLotTx(ctx, tx, newLot(userID, voucher.Points, models.SourceVoucher, redemption.ID)); err != nil {
+		return nil, err
+	}
+	return redemption, tx.Commit()
+}
+
+func (v *voucherAdapter) createVoucherSchema(ctx context.Context) error {
+	_, err := v.conn.ExecContext(ctx, CreateVoucherSchema)
+	return err
+}
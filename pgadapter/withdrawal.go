@@ -6,6 +6,7 @@ import (
 	"github.com/gynshu-one/gophermart-loyalty-system/models"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 const (
@@ -17,13 +18,21 @@ const (
         sum FLOAT NOT NULL,
         processed_at DATE NOT NULL
     );`
-	selectWithdrawal = `SELECT id, user_id, order_id, sum, processed_at FROM withdrawals WHERE user_id = $1;`
-	createWithdrawal = `INSERT INTO withdrawals (id, user_id, order_id, sum, processed_at) VALUES ($1, $2, $3, $4, $5);`
+	// Withdrawals paid from household pool have household_id, it is added by household schema
+	withdrawalFields = `id, user_id, order_id, sum, processed_at, COALESCE(household_id, '') AS household_id`
+	selectWithdrawal = `SELECT ` + withdrawalFields + ` FROM withdrawals WHERE user_id = $1;`
+	createWithdrawal = `INSERT INTO withdrawals (id, user_id, order_id, sum, processed_at, household_id) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''));`
+	streamWithdrawal = `
+    SELECT ` + withdrawalFields + ` FROM withdrawals
+    WHERE user_id = $1 AND ($2::TIMESTAMPTZ IS NULL OR processed_at >= $2) AND ($3::TIMESTAMPTZ IS NULL OR processed_at < $3)
+    ORDER BY processed_at, id;`
 )
 
 type WithdrawalAdapter interface {
+	Withdraw(ctx context.Context, order *models.Order, withdrawal *models.Withdrawal) error
 	CreateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error
 	ReadWithdrawal(ctx context.Context, userID string) ([]*models.Withdrawal, error)
+	StreamWithdrawals(ctx context.Context, userID string, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error
 }
 type withdrawalAdapter struct {
 	conn *sqlx.DB
@@ -39,9 +48,55 @@ func NewWithdrawalAdapter(ctx context.Context, conn *sqlx.DB) *withdrawalAdapter
 	return w
 }
 
+// Withdraw registers order, debits balance of user and records withdrawal at once,
+// so points are never spent without a withdrawal in the ledger.
+// Returns models.ErrorOrderRegistered if order exists and models.ErrorInsufficientFunds if balance is lower than sum
+func (w *withdrawalAdapter) Withdraw(ctx context.Context, order *models.Order, withdrawal *models.Withdrawal) error {
+	tx, err := w.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, createOrder, order.ID, order.UserID, order.Status, order.Accrual, order.UploadedAt, order.UpdatedAt)
+	if isDuplicateKey(err) {
+		return models.ErrorOrderRegistered
+	}
+	if err != nil {
+		return err
+	}
+
+	// Update withdrawn only if amount is available
+	result, err := tx.ExecContext(ctx, updateWithdrawn, withdrawal.Sum, withdrawal.UserID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrorInsufficientFunds
+	}
+	// Spend the oldest points first
+	if _, err = consumeLotsTx(ctx, tx, withdrawal.UserID, withdrawal.Sum); err != nil {
+		return err
+	}
+
+	if withdrawal.ID == "" {
+		withdrawal.ID = helpers.GenerateUUID()
+	}
+	_, err = tx.ExecContext(ctx, createWithdrawal, withdrawal.ID, withdrawal.UserID, withdrawal.OrderID, withdrawal.Sum,
+		withdrawal.ProcessedAt, withdrawal.HouseholdID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (w *withdrawalAdapter) CreateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error {
 	withdrawal.ID = helpers.GenerateUUID()
-	_, err := w.conn.ExecContext(ctx, createWithdrawal, withdrawal.ID, withdrawal.UserID, withdrawal.OrderID, withdrawal.Sum, withdrawal.ProcessedAt)
+	_, err := w.conn.ExecContext(ctx, createWithdrawal, withdrawal.ID, withdrawal.UserID, withdrawal.OrderID, withdrawal.Sum, withdrawal.ProcessedAt, withdrawal.HouseholdID)
 	return err
 }
 
@@ -51,6 +106,26 @@ func (w *withdrawalAdapter) ReadWithdrawal(ctx context.Context, userID string) (
 	return withdrawal, err
 }
 
+// StreamWithdrawals calls fn for every withdrawal of user processed in [from, to) one by one.
+// Zero from and to are unbounded
+func (w *withdrawalAdapter) StreamWithdrawals(ctx context.Context, userID string, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error {
+	rows, err := w.conn.QueryxContext(ctx, streamWithdrawal, userID, nullTime(from), nullTime(to))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		withdrawal := &models.Withdrawal{}
+		if err = rows.StructScan(withdrawal); err != nil {
+			return err
+		}
+		if err = fn(withdrawal); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func (w *withdrawalAdapter) createWithdrawalSchema(ctx context.Context) error {
 	_, err := w.conn.ExecContext(ctx, CreateWithdrawalSchema)
 	return err